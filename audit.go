@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type purposeKey struct{}
+
+// withPurpose returns the request with a description of why it's being made,
+// which is included in the audit log.
+func withPurpose(req *http.Request, purpose string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), purposeKey{}, purpose))
+}
+
+// AuditEntry describes a single outbound request.
+type AuditEntry struct {
+	Purpose string `json:"purpose"`
+	// Method is the HTTP method, or for connections that aren't HTTP, the
+	// network, like "udp4", or "exec" for a command, whose Host is the path of
+	// the command.
+	Method        string `json:"method"`
+	Host          string `json:"host"`
+	Port          string `json:"port"`
+	StatusCode    int    `json:"status_code,omitempty"`
+	BytesSent     int64  `json:"bytes_sent"`
+	BytesReceived int64  `json:"bytes_received"`
+	Error         string `json:"error,omitempty"`
+}
+
+// auditLog collects an entry for every outbound request made during a run.
+type auditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+}
+
+func shouldAudit() bool {
+	value := os.Getenv("DDNS_AUDIT")
+	return value == "1" || value == "true"
+}
+
+func (a *auditLog) add(entry AuditEntry) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, entry)
+}
+
+// report logs every request recorded so far as a single audit record.
+func (a *auditLog) report(logger *slog.Logger) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	hosts := make(map[string]bool)
+	for _, entry := range a.entries {
+		hosts[entry.Host] = true
+	}
+
+	logger.Info("Outbound request audit",
+		"component", "audit",
+		"request_count", len(a.entries),
+		"host_count", len(hosts),
+		"requests", a.entries)
+}
+
+// auditTransport records every request that passes through it.
+type auditTransport struct {
+	next http.RoundTripper
+	log  *auditLog
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	purpose, _ := req.Context().Value(purposeKey{}).(string)
+	if purpose == "" {
+		purpose = "unknown"
+	}
+
+	port := req.URL.Port()
+	if port == "" {
+		port = "443"
+		if req.URL.Scheme == "http" {
+			port = "80"
+		}
+	}
+
+	entry := AuditEntry{
+		Purpose: purpose,
+		Method:  req.Method,
+		Host:    req.URL.Hostname(),
+		Port:    port,
+	}
+	if req.ContentLength > 0 {
+		entry.BytesSent = req.ContentLength
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		entry.Error = err.Error()
+		t.log.add(entry)
+		return nil, err
+	}
+
+	entry.StatusCode = resp.StatusCode
+	// The entry is recorded once the body is closed so that the number of bytes
+	// received is known.
+	resp.Body = &countingBody{ReadCloser: resp.Body, onClose: func(n int64) {
+		entry.BytesReceived = n
+		t.log.add(entry)
+	}}
+	return resp, nil
+}
+
+// countingBody counts the bytes read from a response body, and reports the
+// count when it's closed.
+type countingBody struct {
+	io.ReadCloser
+	count   int64
+	once    sync.Once
+	onClose func(int64)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.count += int64(n)
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.onClose(b.count) })
+	return err
+}
+
+type auditLogKey struct{}
+
+// withAuditLog returns a context that carries the audit log, for recording the
+// connections that aren't made with the audited HTTP client.
+func withAuditLog(ctx context.Context, log *auditLog) context.Context {
+	return context.WithValue(ctx, auditLogKey{}, log)
+}
+
+// auditLogFrom returns the audit log in the context, or nil if there isn't
+// one, which records nothing.
+func auditLogFrom(ctx context.Context) *auditLog {
+	log, _ := ctx.Value(auditLogKey{}).(*auditLog)
+	return log
+}
+
+// auditDial connects to the address like net.DialTimeout, and records the
+// connection in the audit log in the context once it's closed, with the bytes
+// sent and received over it. It's used for everything that talks to the
+// network without the HTTP client, like DNS, STUN, and SNMP.
+func auditDial(ctx context.Context, purpose string, network string, address string, timeout time.Duration) (net.Conn, error) {
+	log := auditLogFrom(ctx)
+	host, port, _ := net.SplitHostPort(address)
+	entry := AuditEntry{Purpose: purpose, Method: network, Host: host, Port: port}
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		entry.Error = err.Error()
+		log.add(entry)
+		return nil, err
+	}
+	if log == nil {
+		return conn, nil
+	}
+	return &auditConn{Conn: conn, entry: entry, log: log}, nil
+}
+
+// auditConn counts the bytes sent and received over a connection, and records
+// them when it's closed.
+type auditConn struct {
+	net.Conn
+	mu    sync.Mutex
+	entry AuditEntry
+	once  sync.Once
+	log   *auditLog
+}
+
+func (c *auditConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.entry.BytesReceived += int64(n)
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *auditConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.mu.Lock()
+	c.entry.BytesSent += int64(n)
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *auditConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.log.add(c.entry)
+	})
+	return err
+}
+
+// withAudit wraps the client's transport so that its requests are recorded in the log.
+func withAudit(client *http.Client, log *auditLog) *http.Client {
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	audited := *client
+	audited.Transport = &auditTransport{next: transport, log: log}
+	return &audited
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// A record that keeps failing is skipped by the daemon's interval and schedules
+// for recordBackoffBase after its first failure, twice that after its second,
+// and so on, up to recordBackoffMax. This stops a record that can't succeed,
+// like one with a revoked token, from being retried at the full rate forever.
+const (
+	recordBackoffBase = time.Minute
+	recordBackoffMax  = time.Hour
+)
+
+// recordBackoff is when a record that has been failing is next synced.
+type recordBackoff struct {
+	failures    int
+	nextAttempt time.Time
+}
+
+// updateBackoff records the outcome of every record in the status, backing
+// off records that failed again and resetting ones that succeeded. The caller
+// must hold d.mu.
+func (d *daemon) updateBackoff(status *RunStatus) {
+	for _, record := range status.Records {
+		// Being offline, or skipped during a Cloudflare outage or while
+		// stopping, isn't the record's fault, and it should be synced as soon
+		// as that's over.
+		if record.Error == errOffline.Error() || record.Error == errCircuitOpen.Error() || record.Error == errStopping.Error() {
+			continue
+		}
+		key := recordKey(record)
+		backoff := d.backoff[key]
+		if record.Result != recordFailed {
+			if backoff != nil {
+				d.logger.Info("Record recovered, syncing it at the usual rate again",
+					"record_type", record.Type, "record_name", record.Name, "failures", backoff.failures)
+				delete(d.backoff, key)
+			}
+			continue
+		}
+
+		if backoff == nil {
+			backoff = &recordBackoff{}
+			d.backoff[key] = backoff
+		}
+		backoff.failures++
+		delay := min(recordBackoffBase<<min(backoff.failures-1, 16), recordBackoffMax)
+		backoff.nextAttempt = status.FinishTime.Add(delay)
+		if backoff.failures > 1 {
+			d.logger.Warn("Record keeps failing, syncing it less often",
+				"record_type", record.Type, "record_name", record.Name,
+				"failures", backoff.failures, "next_attempt", backoff.nextAttempt)
+		}
+	}
+}
+
+// withoutBackedOff returns a copy of the configuration without the records
+// that are being backed off until later.
+func (d *daemon) withoutBackedOff(c *DNSConfiguration) *DNSConfiguration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.backoff) == 0 {
+		return c
+	}
+
+	now := time.Now()
+	excluded := func(recordType string) func(DNSRecord) bool {
+		return func(record DNSRecord) bool {
+			backoff := d.backoff[recordKey(RecordStatus{Name: record.Name, Type: recordType, RecordID: record.RecordID})]
+			if backoff == nil || !now.Before(backoff.nextAttempt) {
+				return false
+			}
+			d.logger.Debug("Skipping record that keeps failing",
+				"record_type", recordType, "record_name", record.Name,
+				"failures", backoff.failures, "next_attempt", backoff.nextAttempt)
+			return true
+		}
+	}
+	filtered := *c
+	filtered.A = slices.DeleteFunc(slices.Clone(c.A), excluded("A"))
+	filtered.AAAA = slices.DeleteFunc(slices.Clone(c.AAAA), excluded("AAAA"))
+	return &filtered
+}
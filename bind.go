@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// BindConfig chooses where outbound connections are made from, for machines
+// with more than one uplink where the default route isn't the one whose
+// address should be published.
+type BindConfig struct {
+	// Interface is the name of the network interface to connect through. It's
+	// only supported on Linux.
+	Interface string `json:"interface,omitempty"`
+	// Address is the local address to connect from.
+	Address string `json:"address,omitempty"`
+}
+
+// dialer returns a copy of the base dialer that connects from the interface or
+// address. A nil base has the default settings.
+func (b *BindConfig) dialer(base *net.Dialer) (*net.Dialer, error) {
+	if base == nil {
+		base = (*HTTPConfig)(nil).dialer()
+	}
+	dialer := *base
+	if b.Address != "" {
+		address, err := netip.ParseAddr(b.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bind address %q", b.Address)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: address.AsSlice()}
+	}
+	if b.Interface != "" {
+		control, err := bindToInterface(b.Interface)
+		if err != nil {
+			return nil, err
+		}
+		dialer.Control = control
+	}
+	return &dialer, nil
+}
+
+// withBinding returns a copy of the client that makes its connections from the
+// interface or address, with the timeout and keep-alive of its transport.
+func withBinding(client *http.Client, bind *BindConfig) (*http.Client, error) {
+	if _, err := bind.dialer(nil); err != nil {
+		return nil, err
+	}
+	return withTransport(client, "bind "+bind.Interface+" "+bind.Address, "", func(transport *http.Transport) {
+		// This can't fail, because the settings were checked above.
+		dialer, _ := bind.dialer(transportDialer(transport))
+		setTransportDialer(transport, dialer)
+	}), nil
+}
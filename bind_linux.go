@@ -0,0 +1,27 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindToInterface returns a function that binds sockets to the interface with
+// SO_BINDTODEVICE, so that they're routed through it whatever the routing
+// table says.
+func bindToInterface(name string) (func(network string, address string, conn syscall.RawConn) error, error) {
+	return func(network string, address string, conn syscall.RawConn) error {
+		var bindErr error
+		err := conn.Control(func(fd uintptr) {
+			bindErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+		})
+		if err != nil {
+			return err
+		}
+		if bindErr != nil {
+			return fmt.Errorf("failed to bind to interface %s: %w", name, bindErr)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+func bindToInterface(name string) (func(network string, address string, conn syscall.RawConn) error, error) {
+	return nil, errors.New("binding to an interface is only supported on Linux, bind to its address instead")
+}
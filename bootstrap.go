@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// resolvesTo reports whether the name resolves to the address when queried
+// directly from the given resolver.
+func resolvesTo(ctx context.Context, resolverAddress string, name string, address netip.Addr) (bool, error) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, resolverAddress)
+		},
+	}
+
+	network := "ip4"
+	if address.Is6() {
+		network = "ip6"
+	}
+
+	addresses, err := resolver.LookupNetIP(ctx, network, name)
+	if err != nil {
+		return false, err
+	}
+
+	for _, resolved := range addresses {
+		if resolved.Unmap() == address {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// dnsFlagAD is the flag in a DNS message's header that says the resolver
+// validated the answer with DNSSEC. In a query, it asks for the flag to be set
+// in the response.
+const dnsFlagAD = 0x20
+
+// resolvesToValidated reports whether the name resolves to the address when
+// queried from the DNS over HTTPS resolver at the URL, which must have
+// validated the answer with DNSSEC. The answer is only trusted because HTTPS
+// authenticates the resolver, since anyone on the path could set the flag on a
+// plain DNS response.
+func resolvesToValidated(ctx context.Context, client *http.Client, resolverURL string, name string, address netip.Addr) (bool, error) {
+	qtype := uint16(dnsTypeA)
+	if address.Is6() {
+		qtype = dnsTypeAAAA
+	}
+	qname, err := dnsName(name)
+	if err != nil {
+		return false, err
+	}
+	// The ID is 0, as RFC 8484 recommends, with recursion desired, the AD
+	// flag, one question, and an OPT record with the DNSSEC OK bit.
+	query := []byte{0, 0, 0x01, dnsFlagAD, 0, 1, 0, 0, 0, 0, 0, 1}
+	query = append(query, qname...)
+	query = binary.BigEndian.AppendUint16(query, qtype)
+	query = binary.BigEndian.AppendUint16(query, dnsClassIN)
+	query = append(query, 0, 0, 41, 0x10, 0, 0, 0, 0x80, 0, 0, 0)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", resolverURL, bytes.NewReader(query))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	message, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return false, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("resolver responded with %d", resp.StatusCode)
+	}
+
+	answers, err := parseDNSAnswers(message)
+	if err != nil {
+		return false, err
+	}
+	if message[3]&dnsFlagAD == 0 {
+		return false, errors.New("the answer isn't validated with DNSSEC")
+	}
+	for _, answer := range answers {
+		if resolved, ok := netip.AddrFromSlice(answer.rdata); answer.rrType == qtype && ok && resolved.Unmap() == address {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// waitForPropagation blocks until every record resolves to its new address on
+// every resolver, according to lookup, or the context is done.
+func waitForPropagation(ctx context.Context, logger *slog.Logger, records []RecordStatus, resolvers []string,
+	lookup func(ctx context.Context, resolver string, name string, address netip.Addr) (bool, error)) error {
+	type check struct {
+		record   RecordStatus
+		address  netip.Addr
+		resolver string
+	}
+
+	var pending []check
+	for _, record := range records {
+		address, err := netip.ParseAddr(record.IP)
+		if err != nil {
+			return fmt.Errorf("record %s has an invalid address %q: %w", record.Name, record.IP, err)
+		}
+		for _, resolver := range resolvers {
+			pending = append(pending, check{record: record, address: address, resolver: resolver})
+		}
+	}
+
+	for {
+		var remaining []check
+		for _, c := range pending {
+			logger := logger.With("record_name", c.record.Name, "record_type", c.record.Type, "resolver", c.resolver)
+
+			lookupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			resolved, err := lookup(lookupCtx, c.resolver, c.record.Name, c.address)
+			cancel()
+
+			switch {
+			case err != nil:
+				logger.Info("Record doesn't resolve yet", "error", err)
+				remaining = append(remaining, c)
+			case !resolved:
+				logger.Info("Record doesn't resolve to the new address yet", "ip", c.record.IP)
+				remaining = append(remaining, c)
+			default:
+				logger.Info("Record resolves to the new address", "ip", c.record.IP)
+			}
+		}
+
+		if len(remaining) == 0 {
+			return nil
+		}
+		pending = remaining
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d lookups still don't resolve to the new address: %w", len(pending), ctx.Err())
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// dohResolvers are the resolvers that are checked with -dnssec, which validate
+// answers with DNSSEC and are queried over HTTPS.
+const dohResolvers = "https://cloudflare-dns.com/dns-query,https://dns.google/dns-query"
+
+// bootstrapCommand handles "clouddns bootstrap", which syncs every record and
+// exits with a non-zero status if any of them couldn't be synced. With -wait,
+// it also waits until the records resolve to their new addresses on public
+// resolvers, so that provisioning scripts know when the names are usable.
+func bootstrapCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	wait := flags.Bool("wait", false, "wait until the records resolve to the new addresses")
+	timeout := flags.Duration("timeout", 5*time.Minute, "maximum time to wait for the records to resolve")
+	resolvers := flags.String("resolvers", "", "comma-separated list of resolvers to check (default 1.1.1.1:53,8.8.8.8:53, or "+dohResolvers+" with -dnssec)")
+	dnssec := flags.Bool("dnssec", false, "only accept answers that the resolvers validated with DNSSEC, asking them over DNS over HTTPS")
+	flags.Parse(args)
+
+	lookup := resolvesTo
+	if *dnssec {
+		if *resolvers == "" {
+			*resolvers = dohResolvers
+		}
+		for _, resolver := range strings.Split(*resolvers, ",") {
+			if !strings.HasPrefix(resolver, "https://") {
+				return fmt.Errorf("-dnssec needs DNS over HTTPS resolvers, like https://cloudflare-dns.com/dns-query, but %s isn't one", resolver)
+			}
+		}
+		client, err := newHTTPClient(nil, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create HTTP client: %w", err)
+		}
+		lookup = func(ctx context.Context, resolver string, name string, address netip.Addr) (bool, error) {
+			return resolvesToValidated(ctx, client, resolver, name, address)
+		}
+	} else if *resolvers == "" {
+		*resolvers = "1.1.1.1:53,8.8.8.8:53"
+	}
+
+	status, err := run(context.Background(), logger)
+	if err != nil {
+		return err
+	}
+
+	if failed := status.failedRecords(); failed > 0 {
+		return fmt.Errorf("%d of %d records failed to sync", failed, len(status.Records))
+	}
+
+	if !*wait {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	logger = logger.With("component", "bootstrap")
+	logger.Info("Waiting for records to resolve", "timeout", timeout.String())
+	if err := waitForPropagation(ctx, logger, status.Records, strings.Split(*resolvers, ","), lookup); err != nil {
+		return err
+	}
+
+	logger.Info("All records resolve to their new addresses")
+	return nil
+}
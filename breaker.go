@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+// cloudflareBreakerThreshold is how many requests to the Cloudflare API can
+// fail in a row, for reasons that are likely to be temporary, before the rest
+// of the run stops making them. Without it, every record would wait out its
+// own timeouts and retries during an outage, one after another.
+const cloudflareBreakerThreshold = 5
+
+// errCircuitOpen is the error of the records that were skipped because the
+// Cloudflare API kept failing.
+var errCircuitOpen = errors.New("skipped because the Cloudflare API kept failing")
+
+// circuitBreaker counts the requests to the Cloudflare API that failed in a
+// row during a run, and stops any more from being made once there are too
+// many. It's shared by every record in the run through the context.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	failures int
+	skipped  int
+	lastErr  error
+}
+
+type breakerKey struct{}
+
+// withBreaker returns a context that carries the breaker.
+func withBreaker(ctx context.Context, b *circuitBreaker) context.Context {
+	return context.WithValue(ctx, breakerKey{}, b)
+}
+
+// breakerFrom returns the breaker in the context, or nil if there isn't one,
+// which lets every request through.
+func breakerFrom(ctx context.Context) *circuitBreaker {
+	b, _ := ctx.Value(breakerKey{}).(*circuitBreaker)
+	return b
+}
+
+// call makes the request unless too many have failed in a row already, and
+// counts whether it failed. Only transient failures count, because an error
+// like a missing permission means the API is working. Being rate limited
+// doesn't count either way, because it's waited out instead.
+func (b *circuitBreaker) call(request func() error) error {
+	if b == nil {
+		return request()
+	}
+
+	b.mu.Lock()
+	if b.failures >= cloudflareBreakerThreshold {
+		b.skipped++
+		b.mu.Unlock()
+		return errCircuitOpen
+	}
+	b.mu.Unlock()
+
+	err := request()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var transient *transientError
+	switch {
+	case errors.As(err, &transient) && transient.rateLimited:
+	case transient != nil:
+		b.failures++
+		b.lastErr = err
+	default:
+		b.failures = 0
+	}
+	return err
+}
+
+// logSummary logs how many records were skipped, if any were.
+func (b *circuitBreaker) logSummary(logger *slog.Logger) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.skipped == 0 {
+		return
+	}
+	logger.Error("The Cloudflare API kept failing, so the remaining records were skipped",
+		"failures_in_a_row", b.failures, "skipped", b.skipped, "last_error", b.lastErr)
+}
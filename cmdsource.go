@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// CommandSource finds the address by running a command and reading what it
+// prints, for setups that none of the other sources can handle.
+type CommandSource struct {
+	// Command is the program to run and its arguments. It isn't run by a
+	// shell, so pipelines need to be written as ["sh", "-c", "..."].
+	Command []string `json:"command"`
+	// Timeout is how many seconds the command can run before it's killed. The
+	// default is 60.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// currentIP runs the command and returns the first line it prints. The record
+// type is passed in the environment, so one script can handle both families.
+func (s *CommandSource) currentIP(ctx context.Context, recordType string) (string, error) {
+	output, err := runCommand(ctx, "ip_lookup", s.Command, s.Timeout, []string{"DDNS_RECORD_TYPE=" + recordType})
+	if err != nil {
+		return "", err
+	}
+	for line := range strings.SplitSeq(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", errors.New("command didn't print an address")
+}
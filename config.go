@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// readConfig reads the configuration at path and returns it as JSON. If path is
+// a directory, every configuration file in it is read in name order and the
+// files are merged into one configuration. If path is "-", the configuration
+// is read from standard input, and if it's an HTTPS URL, it's fetched from there.
+func readConfig(logger *slog.Logger, path string) ([]byte, error) {
+	if isConfigURL(path) {
+		data, err := fetchConfig(logger, path)
+		if err != nil {
+			return nil, err
+		}
+		// The format is detected from the path of the URL, without the query.
+		configURL, _ := url.Parse(path)
+		value, err := parseConfig(configURL.Path, data)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(value)
+	}
+
+	if path == "-" {
+		data, err := readStdin()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config from stdin: %w", err)
+		}
+		value, err := parseConfig(path, data)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(value)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if !info.IsDir() {
+		value, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(value)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config directory: %w", err)
+	}
+
+	var merged any
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !isConfigFileName(name) {
+			continue
+		}
+
+		value, err := readConfigFile(filepath.Join(path, name))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		merged = mergeConfig(merged, value)
+	}
+
+	if merged == nil {
+		return nil, fmt.Errorf("no config files found in %s", path)
+	}
+	return json.Marshal(merged)
+}
+
+// readConfigFile reads a single configuration file.
+func readConfigFile(path string) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return parseConfig(path, data)
+}
+
+// parseConfig parses the contents of a configuration file into generic values,
+// decrypting it if it's encrypted with SOPS, and expanding its environment
+// variable placeholders.
+func parseConfig(path string, data []byte) (any, error) {
+	configJSON, err := configToJSON(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	value, err := decodeConfigJSON(configJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	if isSOPSEncrypted(value) {
+		configJSON, err = decryptSOPS(configFormat(path, data), data)
+		if err != nil {
+			return nil, err
+		}
+		value, err = decodeConfigJSON(configJSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse decrypted config file: %w", err)
+		}
+	}
+
+	value, err = expandEnvValue(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand config file: %w", err)
+	}
+	return value, nil
+}
+
+var (
+	stdinOnce sync.Once
+	stdinData []byte
+	stdinErr  error
+)
+
+// readStdin reads all of standard input the first time it's called, and returns
+// the same data every time after that, so that a configuration read from stdin
+// can be reloaded.
+func readStdin() ([]byte, error) {
+	stdinOnce.Do(func() {
+		stdinData, stdinErr = io.ReadAll(os.Stdin)
+	})
+	return stdinData, stdinErr
+}
+
+func isConfigFileName(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".jsonc", ".yaml", ".yml", ".toml":
+		return true
+	}
+	return false
+}
+
+// mergeConfig merges the configuration from a later file into an earlier one.
+// Lists, like the records, are concatenated and objects are merged key by key.
+// Any other value in the later file replaces the earlier one.
+func mergeConfig(earlier, later any) any {
+	switch later := later.(type) {
+	case map[string]any:
+		earlier, ok := earlier.(map[string]any)
+		if !ok {
+			return later
+		}
+		for key, value := range later {
+			earlier[key] = mergeConfig(earlier[key], value)
+		}
+		return earlier
+	case []any:
+		earlier, ok := earlier.([]any)
+		if !ok {
+			return later
+		}
+		return append(earlier, later...)
+	default:
+		return later
+	}
+}
+
+// decodeConfigJSON decodes JSON into generic values, keeping numbers exactly as
+// they're written.
+func decodeConfigJSON(configJSON []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(configJSON))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// configFormat returns the format of a configuration file, which is decided by
+// its extension or, if the extension isn't recognised, by its content.
+func configFormat(path string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".jsonc":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return "json"
+	}
+
+	// A configuration is always a mapping, so a first line that starts a table
+	// or assigns a key with = can only be TOML.
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") || tomlAssignment.MatchString(line) {
+			return "toml"
+		}
+		break
+	}
+	return "yaml"
+}
+
+var tomlAssignment = regexp.MustCompile(`^[A-Za-z0-9_.\-"' ]+=`)
+
+// configToJSON converts a configuration file in any supported format to JSON,
+// so that every format is decoded the same way.
+func configToJSON(path string, data []byte) ([]byte, error) {
+	switch format := configFormat(path, data); format {
+	case "json":
+		return stripJSONC(data), nil
+	case "yaml":
+		value, err := parseYAML(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
+		return json.Marshal(value)
+	case "toml":
+		value, err := parseTOML(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse TOML: %w", err)
+		}
+		return json.Marshal(value)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+}
+
+// expandEnvValue replaces ${NAME} placeholders in every string in the
+// configuration with the value of the environment variable, so that secrets
+// don't need to be stored in the file. $${ is left as a literal ${.
+func expandEnvValue(value any) (any, error) {
+	switch value := value.(type) {
+	case string:
+		return expandEnvString(value)
+	case []any:
+		for i, item := range value {
+			expanded, err := expandEnvValue(item)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = expanded
+		}
+	case map[string]any:
+		for key, item := range value {
+			expanded, err := expandEnvValue(item)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = expanded
+		}
+	}
+	return value, nil
+}
+
+func expandEnvString(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var sb strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start == -1 {
+			sb.WriteString(s)
+			return sb.String(), nil
+		}
+		if start > 0 && s[start-1] == '$' {
+			sb.WriteString(s[:start-1])
+			sb.WriteString("${")
+			s = s[start+2:]
+			continue
+		}
+
+		end := strings.IndexByte(s[start:], '}')
+		if end == -1 {
+			return "", fmt.Errorf("unterminated placeholder in %q", s)
+		}
+		name := s[start+2 : start+end]
+		if name == "" {
+			return "", fmt.Errorf("empty placeholder in %q", s)
+		}
+		replacement, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not set", name)
+		}
+		sb.WriteString(s[:start])
+		sb.WriteString(replacement)
+		s = s[start+end+1:]
+	}
+}
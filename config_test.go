@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandEnvString(t *testing.T) {
+	t.Setenv("DDNS_TEST_TOKEN", "secret")
+	t.Setenv("DDNS_TEST_ZONE", "example.com")
+	t.Setenv("DDNS_TEST_EMPTY", "")
+	t.Setenv("DDNS_TEST_ESCAPE", "${DDNS_TEST_TOKEN}")
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{name: "no placeholders", input: "plain $text {braces}", want: "plain $text {braces}"},
+		{name: "whole value", input: "${DDNS_TEST_TOKEN}", want: "secret"},
+		{name: "several placeholders", input: "${DDNS_TEST_TOKEN}@${DDNS_TEST_ZONE}.", want: "secret@example.com."},
+		{name: "variable set to nothing", input: "a${DDNS_TEST_EMPTY}b", want: "ab"},
+		{name: "escaped placeholder", input: "$${DDNS_TEST_TOKEN}", want: "${DDNS_TEST_TOKEN}"},
+		{name: "escaped and expanded", input: "$${a} ${DDNS_TEST_ZONE}", want: "${a} example.com"},
+		{name: "escaped without a closing brace", input: "cost: $${", want: "cost: ${"},
+		{name: "replacement isn't expanded again", input: "${DDNS_TEST_ESCAPE}", want: "${DDNS_TEST_TOKEN}"},
+		{name: "unterminated placeholder", input: "a ${DDNS_TEST_TOKEN", wantErr: `unterminated placeholder in "a ${DDNS_TEST_TOKEN"`},
+		{name: "empty placeholder", input: "a ${} b", wantErr: `empty placeholder in "a ${} b"`},
+		{name: "variable that isn't set", input: "${DDNS_TEST_MISSING}", wantErr: "environment variable DDNS_TEST_MISSING not set"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := expandEnvString(test.input)
+			checkError(t, err, test.wantErr)
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandEnvValue(t *testing.T) {
+	t.Setenv("DDNS_TEST_TOKEN", "secret")
+
+	input := map[string]any{
+		"api_token": "${DDNS_TEST_TOKEN}",
+		"ttl":       int64(60),
+		"records": []any{
+			map[string]any{"name": "home", "proxied": true, "token": "${DDNS_TEST_TOKEN}"},
+		},
+	}
+	want := map[string]any{
+		"api_token": "secret",
+		"ttl":       int64(60),
+		"records": []any{
+			map[string]any{"name": "home", "proxied": true, "token": "secret"},
+		},
+	}
+	got, err := expandEnvValue(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
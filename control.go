@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// serveControl accepts commands on a Unix domain socket at path until the
+// context is done, so that scripts can trigger a sync without sending a
+// signal. Each connection sends one command on a line, and gets "ok" or an
+// error back.
+func (d *daemon) serveControl(ctx context.Context, path string) error {
+	// A socket left behind by a process that didn't exit cleanly would
+	// otherwise prevent listening.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove existing control socket: %w", err)
+	}
+	// Connecting to a socket needs permission to write to it, so only the
+	// user that clouddns runs as can send it commands.
+	listener, err := listenPrivate(path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+
+	logger := d.logger.With("component", "control", "path", path)
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go func() {
+		logger.Info("Listening for commands")
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("Failed to accept control connection", "error", err)
+				}
+				return
+			}
+			go d.handleControl(logger, conn)
+		}
+	}()
+	return nil
+}
+
+// handleControl reads a command from the connection and runs it.
+func (d *daemon) handleControl(logger *slog.Logger, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	switch command := strings.TrimSpace(line); command {
+	case "sync":
+		logger.Info("Sync requested")
+		d.trigger("control")
+		fmt.Fprintln(conn, "ok")
+	case "reload":
+		// Reloading is quick, so it's done before replying, and the sync it
+		// triggers runs afterwards.
+		d.reload("control")
+		fmt.Fprintln(conn, "ok")
+	default:
+		logger.Warn("Rejected unknown command", "command", command)
+		fmt.Fprintf(conn, "error: unknown command %q\n", command)
+	}
+}
+
+// triggerCommand handles "clouddns trigger", which asks a running daemon to
+// sync, or to reload its configuration, over its control socket.
+func triggerCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("trigger", flag.ExitOnError)
+	socket := flags.String("socket", os.Getenv("DDNS_CONTROL_SOCKET"), "path of the daemon's control socket")
+	reload := flags.Bool("reload", false, "reload the configuration before syncing")
+	flags.Parse(args)
+
+	if *socket == "" {
+		return errors.New("DDNS_CONTROL_SOCKET or -socket must be set")
+	}
+	command := "sync"
+	if *reload {
+		command = "reload"
+	}
+
+	conn, err := net.DialTimeout("unix", *socket, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to control socket: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := fmt.Fprintln(conn, command); err != nil {
+		return fmt.Errorf("failed to send command: %w", err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read reply: %w", err)
+	}
+	if reply = strings.TrimSpace(reply); reply != "ok" {
+		return fmt.Errorf("daemon replied %q", reply)
+	}
+	logger.Info("Daemon will sync", "command", command)
+	return nil
+}
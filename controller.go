@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// ddnsRecordsPath is the API path of the DDNSRecord custom resource.
+const ddnsRecordsPath = "/apis/clouddns.clo4.github.io/v1alpha1"
+
+// DDNSRecordResource is a DDNSRecord custom resource
+type DDNSRecordResource struct {
+	Metadata struct {
+		Name       string `json:"name"`
+		Namespace  string `json:"namespace"`
+		Generation int64  `json:"generation"`
+	} `json:"metadata"`
+	Spec   DDNSRecordSpec   `json:"spec"`
+	Status DDNSRecordStatus `json:"status"`
+}
+
+// DDNSRecordSpec describes the Cloudflare record that a DDNSRecord keeps up to date
+type DDNSRecordSpec struct {
+	// Name is the fully qualified name of the record.
+	Name string `json:"name"`
+	// Type is either "A" or "AAAA".
+	Type     string `json:"type"`
+	ZoneID   string `json:"zoneID"`
+	RecordID string `json:"recordID"`
+	// APITokenSecretRef refers to a key in a Secret, in the same namespace as the
+	// DDNSRecord, that contains the Cloudflare API token.
+	APITokenSecretRef struct {
+		Name string `json:"name"`
+		Key  string `json:"key"`
+	} `json:"apiTokenSecretRef"`
+}
+
+// DDNSRecordStatus is written back to the DDNSRecord after every sync
+type DDNSRecordStatus struct {
+	// IP is the address that the record was last successfully updated to.
+	IP string `json:"ip,omitempty"`
+	// ObservedGeneration is the generation of the spec that IP was set for, so
+	// that a changed spec causes an update even if the IP is the same.
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	Ready              bool   `json:"ready"`
+	Message            string `json:"message,omitempty"`
+	LastSyncTime       string `json:"lastSyncTime,omitempty"`
+}
+
+// kubeClient makes requests to the Kubernetes API using the pod's service account.
+type kubeClient struct {
+	host      string
+	namespace string
+	client    *http.Client
+}
+
+func newInClusterClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+
+	caCert, err := os.ReadFile(serviceAccountPath + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificates found in service account CA")
+	}
+
+	namespace, err := os.ReadFile(serviceAccountPath + "/namespace")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account namespace: %w", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return &kubeClient{
+		host:      "https://" + net.JoinHostPort(host, port),
+		namespace: strings.TrimSpace(string(namespace)),
+		client:    &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}, nil
+}
+
+func (k *kubeClient) do(method, path, contentType string, body []byte, response any) error {
+	req, err := http.NewRequest(method, k.host+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// The token is read for every request because the kubelet rotates it.
+	token, err := os.ReadFile(serviceAccountPath + "/token")
+	if err != nil {
+		return fmt.Errorf("failed to read service account token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("Kubernetes API error: %d %s", resp.StatusCode, string(data))
+	}
+
+	if response != nil {
+		if err := json.Unmarshal(data, response); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+	}
+	return nil
+}
+
+// listDDNSRecords lists the DDNSRecords in the namespace, or every namespace if it's empty.
+func (k *kubeClient) listDDNSRecords(namespace string) ([]DDNSRecordResource, error) {
+	path := ddnsRecordsPath + "/ddnsrecords"
+	if namespace != "" {
+		path = ddnsRecordsPath + "/namespaces/" + namespace + "/ddnsrecords"
+	}
+
+	var list struct {
+		Items []DDNSRecordResource `json:"items"`
+	}
+	if err := k.do("GET", path, "", nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (k *kubeClient) readSecretKey(namespace, name, key string) (string, error) {
+	var secret struct {
+		// Secret data is base64 encoded, which encoding/json decodes for []byte.
+		Data map[string][]byte `json:"data"`
+	}
+	if err := k.do("GET", "/api/v1/namespaces/"+namespace+"/secrets/"+name, "", nil, &secret); err != nil {
+		return "", err
+	}
+
+	value, ok := secret.Data[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %q", name, key)
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+func (k *kubeClient) updateDDNSRecordStatus(resource *DDNSRecordResource, status DDNSRecordStatus) error {
+	patch, err := json.Marshal(map[string]any{"status": status})
+	if err != nil {
+		return fmt.Errorf("failed to marshal status: %w", err)
+	}
+
+	path := ddnsRecordsPath + "/namespaces/" + resource.Metadata.Namespace + "/ddnsrecords/" + resource.Metadata.Name + "/status"
+	return k.do("PATCH", path, "application/merge-patch+json", patch, nil)
+}
+
+// reconcileDDNSRecord updates the Cloudflare record for the resource if its
+// status shows that it isn't already up-to-date.
+func reconcileDDNSRecord(ctx context.Context, logger *slog.Logger, kube *kubeClient, client *http.Client, resource *DDNSRecordResource, currentIPs map[string]string) {
+	logger = logger.With(
+		"resource", resource.Metadata.Namespace+"/"+resource.Metadata.Name,
+		"record_name", resource.Spec.Name,
+		"record_type", resource.Spec.Type)
+
+	status := DDNSRecordStatus{
+		IP:                 resource.Status.IP,
+		ObservedGeneration: resource.Status.ObservedGeneration,
+		LastSyncTime:       time.Now().UTC().Format(time.RFC3339),
+	}
+
+	currentIP, ok := currentIPs[resource.Spec.Type]
+	switch {
+	case resource.Spec.Type != "A" && resource.Spec.Type != "AAAA":
+		status.Message = fmt.Sprintf("unsupported record type %q", resource.Spec.Type)
+	case !ok:
+		status.Message = "failed to get current IP address"
+	case resource.Status.IP == currentIP && resource.Status.ObservedGeneration == resource.Metadata.Generation:
+		logger.Info("IP address unchanged for record, skipping update", "ip", currentIP)
+		status.Ready = true
+	default:
+		token, err := kube.readSecretKey(resource.Metadata.Namespace, resource.Spec.APITokenSecretRef.Name, resource.Spec.APITokenSecretRef.Key)
+		if err != nil {
+			status.Message = fmt.Sprintf("failed to read API token: %s", err)
+			break
+		}
+
+		record := DNSRecord{
+			Name:     resource.Spec.Name,
+			APIToken: token,
+			ZoneID:   resource.Spec.ZoneID,
+			RecordID: resource.Spec.RecordID,
+		}
+		logger.Info("Updating DNS record", "old_ip", resource.Status.IP, "new_ip", currentIP)
+		if err := updateCloudflareRecord(ctx, client, &record, resource.Spec.Type, currentIP); err != nil {
+			status.Message = fmt.Sprintf("failed to update DNS record: %s", err)
+			break
+		}
+
+		logger.Info("Successfully updated DNS record", "ip", currentIP)
+		status.IP = currentIP
+		status.ObservedGeneration = resource.Metadata.Generation
+		status.Ready = true
+	}
+
+	if status.Message != "" {
+		logger.Error("Failed to sync DDNSRecord", "error", status.Message)
+	}
+
+	if err := kube.updateDDNSRecordStatus(resource, status); err != nil {
+		logger.Error("Failed to update DDNSRecord status", "error", err)
+	}
+}
+
+// reconcileDDNSRecords syncs every DDNSRecord in the namespace to the cluster's egress IP.
+func reconcileDDNSRecords(ctx context.Context, logger *slog.Logger, kube *kubeClient, client *http.Client, namespace string) {
+	resources, err := kube.listDDNSRecords(namespace)
+	if err != nil {
+		logger.Error("Failed to list DDNSRecords", "error", err)
+		return
+	}
+	logger.Info("Beginning update for DDNSRecords", "count", len(resources))
+
+	// Only the address families that are actually used are looked up.
+	ipSources := map[string]IPSourceList{}
+	for _, resource := range resources {
+		switch resource.Spec.Type {
+		case "A", "AAAA":
+			ipSources[resource.Spec.Type] = defaultIPSources(resource.Spec.Type)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	currentIPs := map[string]string{}
+	for recordType, sources := range ipSources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ip, err := sources.currentIP(ctx, logger, client, recordType, false, nil)
+			if err != nil {
+				logger.Error("Failed to get current IP address", "record_type", recordType, "error", err)
+				return
+			}
+			mu.Lock()
+			currentIPs[recordType] = ip
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for i := range resources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reconcileDDNSRecord(ctx, logger, kube, client, &resources[i], currentIPs)
+		}()
+	}
+	wg.Wait()
+}
+
+// controllerCommand handles "clouddns controller", which runs inside a
+// Kubernetes cluster and keeps the records described by DDNSRecord resources
+// pointed at the cluster's egress IP.
+func controllerCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("controller", flag.ExitOnError)
+	interval := flags.Duration("interval", 5*time.Minute, "time between syncs")
+	namespace := flags.String("namespace", "", "namespace to watch (defaults to the controller's namespace)")
+	allNamespaces := flags.Bool("all-namespaces", false, "watch DDNSRecords in every namespace")
+	flags.Parse(args)
+
+	kube, err := newInClusterClient()
+	if err != nil {
+		return err
+	}
+
+	if *namespace == "" && !*allNamespaces {
+		*namespace = kube.namespace
+	}
+
+	client, err := newHTTPClient(nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP client: %w", err)
+	}
+
+	ctx, stop := stopContext(logger)
+	defer stop()
+
+	logger = logger.With("component", "controller")
+	logger.Info("Starting controller", "namespace", *namespace, "interval", interval.String())
+
+	for {
+		// Stopping waits for the reconcile in progress to finish, rather than
+		// interrupting its requests.
+		reconcileDDNSRecords(context.WithoutCancel(ctx), logger, kube, client, *namespace)
+
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopping controller")
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
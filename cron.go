@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression. Each field is a set of the values
+// it matches, with bit n set if n matches.
+type cronSchedule struct {
+	seconds, minutes, hours, days, months, weekdays uint64
+	// anyDay and anyWeekday record whether the day of the month and the day of
+	// the week were "*", because if both are restricted, a time only has to
+	// match one of them, like in crontab.
+	anyDay, anyWeekday bool
+}
+
+// cronMacros are the shorthands that crontab accepts for common schedules.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonthNames   = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronWeekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// parseCron parses a cron expression with five fields, like crontab, or six
+// fields, where the first one is the second.
+func parseCron(expression string) (*cronSchedule, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(expression)]; ok {
+		expression = macro
+	}
+	fields := strings.Fields(expression)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron expression %q must have 5 or 6 fields", expression)
+	}
+
+	var schedule cronSchedule
+	var err error
+	for _, field := range []struct {
+		name     string
+		value    string
+		min, max int
+		names    []string
+		set      *uint64
+	}{
+		{"second", fields[0], 0, 59, nil, &schedule.seconds},
+		{"minute", fields[1], 0, 59, nil, &schedule.minutes},
+		{"hour", fields[2], 0, 23, nil, &schedule.hours},
+		{"day of the month", fields[3], 1, 31, nil, &schedule.days},
+		{"month", fields[4], 1, 12, cronMonthNames, &schedule.months},
+		{"day of the week", fields[5], 0, 7, cronWeekdayNames, &schedule.weekdays},
+	} {
+		if *field.set, err = parseCronField(field.value, field.min, field.max, field.names); err != nil {
+			return nil, fmt.Errorf("invalid %s in cron expression %q: %w", field.name, expression, err)
+		}
+	}
+	// Sunday can be written as 0 or 7.
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays |= 1
+	}
+	schedule.anyDay = fields[3] == "*" || fields[3] == "?"
+	schedule.anyWeekday = fields[5] == "*" || fields[5] == "?"
+	return &schedule, nil
+}
+
+// parseCronField returns the set of values that a comma-separated list of
+// values, ranges, and steps matches. Names are numbered from min.
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return min + i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q isn't between %d and %d", s, min, max)
+		}
+		return n, nil
+	}
+
+	var set uint64
+	for part := range strings.SplitSeq(field, ",") {
+		span, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("step %q isn't a positive number", stepText)
+			}
+		}
+
+		start, end := min, max
+		switch {
+		case span == "*" || span == "?":
+		case strings.Contains(span, "-"):
+			first, last, _ := strings.Cut(span, "-")
+			var err error
+			if start, err = value(first); err != nil {
+				return 0, err
+			}
+			if end, err = value(last); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("range %q is backwards", span)
+			}
+		default:
+			var err error
+			if start, err = value(span); err != nil {
+				return 0, err
+			}
+			// A single value with a step, like 5/15, runs from the value to
+			// the end of the range.
+			end = start
+			if hasStep {
+				end = max
+			}
+		}
+
+		for n := start; n <= end; n += step {
+			set |= 1 << n
+		}
+	}
+	return set, nil
+}
+
+// matches reports whether the schedule fires at the time, to the second.
+func (s *cronSchedule) matches(t time.Time) bool {
+	if s.seconds&(1<<t.Second()) == 0 || s.minutes&(1<<t.Minute()) == 0 ||
+		s.hours&(1<<t.Hour()) == 0 || s.months&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	day := s.days&(1<<t.Day()) != 0
+	weekday := s.weekdays&(1<<int(t.Weekday())) != 0
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
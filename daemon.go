@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// daemon keeps the client running, syncing the records whenever it's triggered.
+type daemon struct {
+	logger *slog.Logger
+	// interval is the time between syncs. If it's zero, the records are only
+	// synced when the daemon is triggered.
+	interval time.Duration
+	// schedule is when to sync instead of the interval, if it's set.
+	schedule *cronSchedule
+	triggers chan string
+	// scheduled receives the group whose schedule is due, or an empty string
+	// when the daemon's own schedule is.
+	scheduled chan string
+
+	mu          sync.Mutex
+	syncing     bool
+	syncStarted time.Time
+	// progress is when the current sync, or the last one, last got anywhere.
+	progress   *syncProgress
+	lastStatus *RunStatus
+	lastError  error
+	// lastSync is when the most recent sync finished, and lastSuccess is when
+	// the most recent one without any errors did.
+	lastSync    time.Time
+	lastSuccess time.Time
+	// records has the most recent status of every record, by recordKey.
+	records map[string]RecordStatus
+	// backoff has the records that failed the last time they were synced, by
+	// recordKey.
+	backoff map[string]*recordBackoff
+	// configuration is the configuration that was most recently loaded
+	// successfully, or nil if it hasn't been loaded yet.
+	configuration *DNSConfiguration
+
+	// events is nil unless the daemon was configured to publish events.
+	events  *eventServer
+	lastIPs map[string]string
+}
+
+func newDaemon(logger *slog.Logger, interval time.Duration) *daemon {
+	return &daemon{
+		logger:    logger,
+		interval:  interval,
+		triggers:  make(chan string, 1),
+		scheduled: make(chan string),
+		lastIPs:   map[string]string{},
+		records:   map[string]RecordStatus{},
+		backoff:   map[string]*recordBackoff{},
+	}
+}
+
+// trigger requests a sync as soon as possible. Triggers that arrive while a
+// sync is already pending are combined into it.
+func (d *daemon) trigger(reason string) {
+	select {
+	case d.triggers <- reason:
+	default:
+	}
+}
+
+// serveEvents publishes events for every sync on the Unix domain socket at path
+// until the context is done. It must be called before run.
+func (d *daemon) serveEvents(ctx context.Context, path string) error {
+	events, err := newEventServer(d.logger, path)
+	if err != nil {
+		return err
+	}
+	d.events = events
+	go events.serve(ctx)
+	return nil
+}
+
+// run syncs the records immediately, and then again whenever the daemon is
+// triggered or the interval passes, until the context is done. Records in
+// groups with their own schedule are synced on that schedule instead of the
+// interval, but are still synced when the daemon is triggered.
+func (d *daemon) run(ctx context.Context) {
+	reason := "startup"
+	// scheduled is whether the sync was started by the interval or a schedule,
+	// in which case only the records for group are synced.
+	scheduled, group := false, ""
+	var nextInterval time.Time
+	for {
+		attrs := []any{"reason", reason}
+		if group != "" {
+			attrs = append(attrs, "group", group)
+		}
+		d.logger.Info("Starting sync", attrs...)
+		d.mu.Lock()
+		d.syncing = true
+		d.syncStarted = time.Now()
+		d.progress = &syncProgress{last: d.syncStarted}
+		progress := d.progress
+		d.mu.Unlock()
+
+		configuration, err := d.currentConfiguration()
+		if err == nil && scheduled {
+			// Records that keep failing are only skipped by the interval and
+			// schedules, so triggering a sync still tries every record.
+			configuration = d.withoutBackedOff(configuration.scheduledRecords(group))
+		}
+		var status *RunStatus
+		if err == nil && len(configuration.A) == 0 && len(configuration.AAAA) == 0 {
+			d.logger.Info("No records to sync", attrs...)
+		} else if err == nil {
+			// A sync that has started stops starting records when the daemon
+			// is told to stop, and gives the ones it has started time to finish.
+			status, err = runConfiguration(withSyncProgress(ctx, progress), d.logger, configuration)
+		}
+		if err != nil {
+			d.logger.Error("Run failed", "error", err)
+		}
+
+		d.mu.Lock()
+		d.syncing = false
+		d.lastError = err
+		d.lastSync = time.Now()
+		if status != nil {
+			d.lastStatus = status
+			for _, record := range status.Records {
+				d.records[recordKey(record)] = record
+			}
+			d.updateBackoff(status)
+		}
+		if err == nil && (status == nil || status.failedRecords() == 0) {
+			d.lastSuccess = d.lastSync
+		}
+		d.mu.Unlock()
+
+		if d.events != nil && status != nil {
+			d.events.broadcast(runEvents(status, d.lastIPs))
+		}
+		if status != nil {
+			notifySystemd(fmt.Sprintf("STATUS=Last sync at %s: %d records, %d failed",
+				status.FinishTime.Format(time.TimeOnly), len(status.Records), status.failedRecords()))
+		}
+
+		// The schedules of groups don't restart the interval, so that a group
+		// that's synced often doesn't stop the other records from being synced.
+		var interval <-chan time.Time
+		if d.interval > 0 {
+			if group == "" {
+				nextInterval = time.Now().Add(d.interval)
+			}
+			interval = time.After(time.Until(nextInterval))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case reason = <-d.triggers:
+			scheduled, group = false, ""
+		case <-interval:
+			reason = "interval"
+			scheduled, group = true, ""
+		case group = <-d.scheduled:
+			reason = "schedule"
+			scheduled = true
+		}
+	}
+}
+
+// watchSchedules requests a sync whenever the daemon's schedule or the
+// schedule of a group of records is due, until the context is done. The
+// schedules of groups are those of the most recently loaded configuration.
+func (d *daemon) watchSchedules(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := time.Now().Truncate(time.Second)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.mu.Lock()
+		configuration := d.configuration
+		d.mu.Unlock()
+
+		// Every second since the last check is checked, because ticks can
+		// be late, but each schedule is only run once even if a sync took long
+		// enough for it to be due more than once.
+		now := time.Now().Truncate(time.Second)
+		var due []string
+		for t := last.Add(time.Second); !t.After(now); t = t.Add(time.Second) {
+			if d.schedule != nil && d.schedule.matches(t) && !slices.Contains(due, "") {
+				due = append(due, "")
+			}
+			if configuration == nil {
+				continue
+			}
+			for _, group := range slices.Sorted(maps.Keys(configuration.schedules)) {
+				if configuration.schedules[group].matches(t) && !slices.Contains(due, group) {
+					due = append(due, group)
+				}
+			}
+		}
+		last = now
+
+		for _, group := range due {
+			select {
+			case <-ctx.Done():
+				return
+			case d.scheduled <- group:
+			}
+		}
+	}
+}
+
+// currentConfiguration returns the configuration to sync, loading it if it
+// hasn't been loaded successfully yet. A configuration from a URL is fetched
+// again before every sync, because there's no way to be notified when it
+// changes, and the previous one is used if that fails.
+func (d *daemon) currentConfiguration() (*DNSConfiguration, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.configuration == nil || isConfigURL(os.Getenv("DDNS_CONFIG_PATH")) {
+		configuration, err := loadDNSConfiguration(d.logger)
+		if err != nil {
+			if d.configuration != nil {
+				d.logger.Error("Failed to reload configuration, using the previous one", "error", err)
+				return d.configuration, nil
+			}
+			return nil, fmt.Errorf("failed to load configuration: %w", err)
+		}
+		d.logger.Info("Loaded configuration")
+		d.configuration = &configuration
+	}
+	d.configuration.refreshSecretFiles(d.logger)
+	return d.configuration, nil
+}
+
+// reload loads the configuration again and triggers a sync, so that added
+// records are created without waiting for the next one. If the configuration
+// can't be loaded, the daemon keeps using the previous one, so a mistake while
+// editing the file doesn't stop the records from being synced. A sync that's
+// already running finishes with the configuration it started with.
+func (d *daemon) reload(reason string) {
+	configuration, err := loadDNSConfiguration(d.logger)
+	if err != nil {
+		d.logger.Error("Failed to reload configuration", "reason", reason, "error", err)
+		return
+	}
+
+	d.mu.Lock()
+	d.configuration = &configuration
+	d.mu.Unlock()
+
+	d.logger.Info("Reloaded configuration", "reason", reason)
+	d.trigger("reload")
+}
+
+// watchConfiguration reloads the configuration whenever the file, or any file
+// in the directory, changes, until the context is done.
+func (d *daemon) watchConfiguration(ctx context.Context) {
+	path := os.Getenv("DDNS_CONFIG_PATH")
+	if path == "" || path == "-" || isConfigURL(path) {
+		return
+	}
+	watchFileTouches(ctx, path, func() { d.reload("config_changed") })
+}
+
+// watchSourceFiles syncs whenever a file that a file IP source reads from
+// changes, until the context is done. The files are those of the most recently
+// loaded configuration, so they follow it when it's reloaded.
+func (d *daemon) watchSourceFiles(ctx context.Context) {
+	logger := d.logger.With("component", "file_watch")
+	logger.Info("Watching IP source files for changes")
+	lastModified := map[string]time.Time{}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.mu.Lock()
+		configuration := d.configuration
+		d.mu.Unlock()
+		if configuration == nil {
+			continue
+		}
+
+		changed := false
+		for _, path := range configuration.sourceFiles() {
+			modified, err := latestModTime(path)
+			if err != nil {
+				// A file that's removed and written again counts as changed.
+				modified = time.Time{}
+			}
+			previous, seen := lastModified[path]
+			if seen && !modified.Equal(previous) {
+				logger.Info("IP source file changed", "path", path)
+				changed = true
+			}
+			lastModified[path] = modified
+		}
+		if changed {
+			d.trigger("ip_file_changed")
+		}
+	}
+}
+
+// logStatus logs the outcome of the most recent sync for every record, along
+// with some information about the state of the process.
+func (d *daemon) logStatus() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	attrs := []any{
+		"syncing", d.syncing,
+		"goroutines", runtime.NumGoroutine(),
+	}
+	if d.lastError != nil {
+		attrs = append(attrs, "last_error", d.lastError.Error())
+	}
+	if d.lastStatus != nil {
+		attrs = append(attrs,
+			"last_sync", d.lastStatus.FinishTime,
+			"records", d.lastStatus.Records)
+	}
+	d.logger.Info("Daemon status", attrs...)
+}
+
+// recordKey identifies a record across syncs.
+func recordKey(record RecordStatus) string {
+	return record.Type + "/" + record.Name + "/" + record.RecordID
+}
+
+// shouldRunDaemon reports whether the environment enables a way of triggering
+// syncs, in which case clouddns keeps running instead of exiting after one sync.
+func shouldRunDaemon() bool {
+	return os.Getenv("DDNS_TRIGGER_PATH") != "" || os.Getenv("DDNS_CONTROL_SOCKET") != "" || shouldWatchNetwork() || shouldWatchAddresses() || shouldWatchSourceFiles() ||
+		shouldLoop() || os.Getenv("DDNS_INTERVAL") != "" || os.Getenv("DDNS_SCHEDULE") != ""
+}
+
+// shouldLoop reports whether DDNS_DAEMON asks for clouddns to keep running and
+// sync on an interval.
+func shouldLoop() bool {
+	value := os.Getenv("DDNS_DAEMON")
+	return value == "1" || value == "true"
+}
+
+// defaultDaemonInterval is the time between syncs when clouddns is asked to
+// keep running without an interval.
+const defaultDaemonInterval = 5 * time.Minute
+
+// daemonInterval returns the time between syncs from DDNS_INTERVAL. Without it,
+// a daemon that was started with DDNS_DAEMON uses the default interval, and one
+// that was only started for its triggers doesn't sync on an interval at all.
+func daemonInterval() (time.Duration, error) {
+	value := os.Getenv("DDNS_INTERVAL")
+	if os.Getenv("DDNS_SCHEDULE") != "" {
+		if value != "" {
+			return 0, fmt.Errorf("DDNS_INTERVAL and DDNS_SCHEDULE can't be used together")
+		}
+		return 0, nil
+	}
+	if value == "" {
+		if shouldLoop() {
+			return defaultDaemonInterval, nil
+		}
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid DDNS_INTERVAL %q: %w", value, err)
+	}
+	if interval < time.Minute {
+		return 0, fmt.Errorf("DDNS_INTERVAL must be at least one minute")
+	}
+	return interval, nil
+}
+
+func shouldWatchNetwork() bool {
+	value := os.Getenv("DDNS_WATCH_NETWORK")
+	return value == "1" || value == "true"
+}
+
+func shouldWatchAddresses() bool {
+	value := os.Getenv("DDNS_WATCH_ADDRESSES")
+	return value == "1" || value == "true"
+}
+
+func shouldWatchSourceFiles() bool {
+	value := os.Getenv("DDNS_WATCH_FILES")
+	return value == "1" || value == "true"
+}
+
+// runDaemon syncs the records, and then keeps running and syncs them again
+// on the interval and whenever one of the triggers in the environment fires,
+// until it's interrupted.
+func runDaemon(logger *slog.Logger) error {
+	interval, err := daemonInterval()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := stopContext(logger)
+	defer stop()
+
+	if interval > 0 {
+		logger.Info("Syncing on an interval", "interval", interval.String())
+	}
+	d := newDaemon(logger, interval)
+	if expression := os.Getenv("DDNS_SCHEDULE"); expression != "" {
+		if d.schedule, err = parseCron(expression); err != nil {
+			return fmt.Errorf("invalid DDNS_SCHEDULE: %w", err)
+		}
+		logger.Info("Syncing on a schedule", "schedule", expression)
+	}
+	if path := os.Getenv("DDNS_EVENTS_SOCKET"); path != "" {
+		if err := d.serveEvents(ctx, path); err != nil {
+			logger.Error("Failed to publish events", "error", err)
+		}
+	}
+	if path := os.Getenv("DDNS_CONTROL_SOCKET"); path != "" {
+		if err := d.serveControl(ctx, path); err != nil {
+			return err
+		}
+	}
+	if address := os.Getenv("DDNS_HEALTH_LISTEN"); address != "" {
+		if err := d.serveHealth(ctx, address); err != nil {
+			return err
+		}
+	}
+	if path := os.Getenv("DDNS_TRIGGER_PATH"); path != "" {
+		go watchTriggerPath(ctx, logger, path, d.trigger)
+	}
+	if shouldWatchNetwork() {
+		go watchNetwork(ctx, logger, d.trigger)
+	}
+	if shouldWatchAddresses() {
+		go watchAddresses(ctx, logger, d.trigger)
+	}
+	if shouldWatchSourceFiles() {
+		go d.watchSourceFiles(ctx)
+	}
+	go d.watchConfiguration(ctx)
+	go d.watchSchedules(ctx)
+	go handleDaemonSignals(ctx, d)
+	if timeout := watchdogInterval(); timeout > 0 {
+		go d.feedWatchdog(ctx, timeout)
+	}
+
+	if err := notifySystemd("READY=1"); err != nil {
+		logger.Warn("Failed to notify systemd that clouddns is ready", "error", err)
+	}
+	// systemd is told as soon as clouddns starts stopping, even though a sync
+	// that's running is allowed to finish.
+	context.AfterFunc(ctx, func() { notifySystemd("STOPPING=1") })
+	d.run(ctx)
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// pendingIP is a new address that hasn't been seen for long enough to update
+// the record with. It's kept in the cache directory so that it's remembered
+// between runs.
+type pendingIP struct {
+	IP        string    `json:"ip"`
+	FirstSeen time.Time `json:"first_seen"`
+	Checks    int       `json:"checks"`
+}
+
+func generatePendingFilename(record *DNSRecord, recordType string) string {
+	return "pending_ip_" + cacheKey(record, recordType) + ".json"
+}
+
+// confirmedIP returns the address that the record should point at. A new
+// address is only returned once it's been found by the record's confirm_checks
+// checks in a row and for at least confirm_time seconds, and until then the
+// cached address is returned, so the record is left as it is.
+func confirmedIP(logger *slog.Logger, record *DNSRecord, recordType string, baseCachePath string, address string) string {
+	if record.ConfirmChecks <= 1 && record.ConfirmTime <= 0 {
+		return address
+	}
+	logger = logger.With("record_id", record.RecordID, "record_name", record.Name)
+	if baseCachePath == "" {
+		logger.Warn("Not confirming new IP address because there is no DDNS_CACHE_PATH set", "ip", address)
+		return address
+	}
+
+	pendingFileName := generatePendingFilename(record, recordType)
+	cachedIP, err := readCachedIP(baseCachePath, generateCacheFilename(record, recordType))
+	if err != nil {
+		logger.Warn("Failed to read cached IP for record", "error", err)
+	}
+	// The first address is used straight away, because there's nothing to
+	// keep the record at. Once the record has the new address, or the old
+	// one comes back, there's nothing pending any more.
+	if cachedIP == "" || cachedIP == address {
+		if err := os.Remove(filepath.Join(baseCachePath, pendingFileName)); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to remove pending IP for record", "error", err)
+		}
+		return address
+	}
+
+	var pending pendingIP
+	if data, err := readCachedIP(baseCachePath, pendingFileName); err != nil {
+		logger.Warn("Failed to read pending IP for record", "error", err)
+	} else if data != "" {
+		if err := json.Unmarshal([]byte(data), &pending); err != nil {
+			logger.Warn("Failed to parse pending IP for record", "error", err)
+		}
+	}
+	now := time.Now()
+	if pending.IP != address {
+		pending = pendingIP{IP: address, FirstSeen: now}
+	}
+	pending.Checks++
+
+	// The pending address is kept after it's confirmed, so that it doesn't
+	// have to be confirmed again if the update fails.
+	data, _ := json.Marshal(pending)
+	if err := writeCachedIP(baseCachePath, pendingFileName, string(data)); err != nil {
+		logger.Warn("Failed to save pending IP for record", "error", err)
+	}
+
+	seen := now.Sub(pending.FirstSeen)
+	if pending.Checks >= record.ConfirmChecks && seen >= time.Duration(record.ConfirmTime)*time.Second {
+		logger.Info("New IP address confirmed for record", "ip", address, "checks", pending.Checks)
+		return address
+	}
+	logger.Info("Waiting to confirm new IP address for record",
+		"ip", cachedIP,
+		"new_ip", address,
+		"checks", pending.Checks,
+		"seen_for", seen.Round(time.Second).String())
+	return cachedIP
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoveryFileName is the name of the file in the cache directory that the
+// zone and record IDs that were looked up are kept in between runs.
+const discoveryFileName = "discovered_ids.json"
+
+// discoveryTTL is how long a discovered ID is used for before it's looked up
+// again. IDs only change when a zone or record is deleted and created again,
+// which is rare, and an update that fails because the ID is stale forgets it
+// straight away.
+const discoveryTTL = 24 * time.Hour
+
+// discoveredID is an ID that was looked up with the Cloudflare API.
+type discoveredID struct {
+	ID      string    `json:"id"`
+	Expires time.Time `json:"expires"`
+}
+
+// discoveryCache remembers the zone and record IDs that were looked up, so
+// that records without them don't cost extra API requests on every run.
+type discoveryCache struct {
+	mu      sync.Mutex
+	IDs     map[string]discoveredID `json:"ids"`
+	changed bool
+	// zones are the zones that each token can access, which are only listed
+	// once in a run, however many records need them.
+	zones map[string][]CloudflareZone
+}
+
+// zoneDiscoveryKey identifies the lookup of the zone that a record name is in.
+// Tokens can see different zones, so a hash of the token is part of the key,
+// without putting the token itself in the cache.
+func zoneDiscoveryKey(token string, name string) string {
+	hash := sha256.Sum256([]byte(token))
+	return "zone " + hex.EncodeToString(hash[:8]) + " " + strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// recordDiscoveryKey identifies the lookup of a record by zone, type, and name.
+func recordDiscoveryKey(zoneID string, recordType string, name string) string {
+	return "record " + zoneID + " " + recordType + " " + strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// loadDiscoveryCache reads the discovered IDs from the cache directory.
+// Without a cache directory, or before the file is first written, the cache
+// starts empty and only lasts for the run.
+func loadDiscoveryCache(basePath string) (*discoveryCache, error) {
+	cache := &discoveryCache{IDs: map[string]discoveredID{}}
+	if basePath == "" {
+		return cache, nil
+	}
+	data, err := os.ReadFile(filepath.Join(basePath, discoveryFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return cache, fmt.Errorf("failed to read discovered IDs: %w", err)
+	}
+	if err := json.Unmarshal(data, cache); err != nil {
+		return &discoveryCache{IDs: map[string]discoveredID{}}, fmt.Errorf("failed to parse discovered IDs: %w", err)
+	}
+	if cache.IDs == nil {
+		cache.IDs = map[string]discoveredID{}
+	}
+	return cache, nil
+}
+
+// lookup returns the ID for the key from the cache, or calls find to look it
+// up and remembers it if it hasn't been looked up in the last discoveryTTL.
+func (c *discoveryCache) lookup(key string, find func() (string, error)) (id string, cached bool, err error) {
+	if c == nil {
+		id, err = find()
+		return id, false, err
+	}
+	c.mu.Lock()
+	entry, ok := c.IDs[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.Expires) {
+		return entry.ID, true, nil
+	}
+
+	id, err = find()
+	if err != nil {
+		return "", false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.IDs[key] = discoveredID{ID: id, Expires: time.Now().Add(discoveryTTL)}
+	c.changed = true
+	return id, false, nil
+}
+
+// forget removes the ID for the key, after it turned out to be wrong.
+func (c *discoveryCache) forget(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.IDs[key]; ok {
+		delete(c.IDs, key)
+		c.changed = true
+	}
+}
+
+// save writes the discovered IDs to the cache directory if any were looked up
+// or forgotten, leaving out the ones that have expired.
+func (c *discoveryCache) save(basePath string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.changed {
+		return nil
+	}
+	now := time.Now()
+	maps.DeleteFunc(c.IDs, func(_ string, entry discoveredID) bool {
+		return !now.Before(entry.Expires)
+	})
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal discovered IDs: %w", err)
+	}
+	if err := writeFileAtomically(filepath.Join(basePath, discoveryFileName), data, 0644); err != nil {
+		return fmt.Errorf("failed to write discovered IDs: %w", err)
+	}
+	c.changed = false
+	return nil
+}
+
+type discoveryKey struct{}
+
+// withDiscovery returns a context that carries the cache of discovered IDs.
+func withDiscovery(ctx context.Context, c *discoveryCache) context.Context {
+	return context.WithValue(ctx, discoveryKey{}, c)
+}
+
+// discoveryFrom returns the cache of discovered IDs in the context, or nil if
+// there isn't one, which looks every ID up again.
+func discoveryFrom(ctx context.Context) *discoveryCache {
+	c, _ := ctx.Value(discoveryKey{}).(*discoveryCache)
+	return c
+}
+
+// notFoundError is the error of an update to a record that doesn't exist,
+// which means that a discovered ID is out of date.
+type notFoundError struct {
+	err error
+}
+
+func (e *notFoundError) Error() string { return e.err.Error() }
+func (e *notFoundError) Unwrap() error { return e.err }
+
+// missingRecordError is the error of looking up a record that doesn't exist.
+type missingRecordError struct {
+	recordType string
+	name       string
+}
+
+func (e *missingRecordError) Error() string {
+	return fmt.Sprintf("there's no %s record named %s in the zone, so create it, set create_if_missing, or set record_id", e.recordType, e.name)
+}
+
+// createCloudflareRecord creates the record, pointing at the address, and
+// returns its ID. Unlike updates, creating a record isn't retried, because a
+// failure after Cloudflare created it would create a second one.
+func createCloudflareRecord(ctx context.Context, client *http.Client, record *DNSRecord, recordType string, address string) (string, error) {
+	jsonData, err := json.Marshal(CloudflareUpdateRequest{
+		Type:    recordType,
+		Name:    strings.TrimSuffix(strings.ToLower(record.Name), "."),
+		Content: address,
+		TTL:     max(record.TTL, 1),
+		Proxied: record.Proxied,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		"https://api.cloudflare.com/client/v4/zones/"+record.ZoneID+"/dns_records", bytes.NewReader(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withPurpose(req, "cloudflare_create")
+	req.Header.Set("Authorization", "Bearer "+record.APIToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var created struct {
+		CloudflareResponse
+		Result CloudflareDNSRecord `json:"result"`
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %w", err)
+	}
+	if err := json.Unmarshal(body, &created); err != nil {
+		return "", fmt.Errorf("API error: %d %s", resp.StatusCode, string(body))
+	}
+	if !created.Success || created.Result.ID == "" {
+		if len(created.Errors) > 0 {
+			return "", fmt.Errorf("API error: %s (code: %d)", created.Errors[0].Message, created.Errors[0].Code)
+		}
+		return "", fmt.Errorf("API error: %d %s", resp.StatusCode, string(body))
+	}
+	return created.Result.ID, nil
+}
+
+// findRecordID looks up the ID of the record by its name and type. There has
+// to be exactly one match, because updating the wrong one of several records
+// with the same name would be worse than not updating any.
+func findRecordID(ctx context.Context, client *http.Client, record *DNSRecord, recordType string) (string, error) {
+	name := strings.TrimSuffix(strings.ToLower(record.Name), ".")
+	records, err := findDNSRecords(ctx, client, "discovery", record.APIToken, record.ZoneID, name)
+	if err != nil {
+		return "", err
+	}
+	records = slices.DeleteFunc(records, func(r CloudflareDNSRecord) bool { return r.Type != recordType })
+	switch len(records) {
+	case 0:
+		return "", &missingRecordError{recordType: recordType, name: name}
+	case 1:
+		return records[0].ID, nil
+	default:
+		return "", fmt.Errorf("there are %d %s records named %s, so set record_id to choose which one to update", len(records), recordType, name)
+	}
+}
+
+// zonesFor returns the zones that the token can access, listing them the
+// first time.
+func (c *discoveryCache) zonesFor(ctx context.Context, client *http.Client, token string) ([]CloudflareZone, error) {
+	if c == nil {
+		return listZones(ctx, client, "discovery", token)
+	}
+	c.mu.Lock()
+	zones, ok := c.zones[token]
+	c.mu.Unlock()
+	if ok {
+		return zones, nil
+	}
+
+	zones, err := listZones(ctx, client, "discovery", token)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.zones == nil {
+		c.zones = map[string][]CloudflareZone{}
+	}
+	c.zones[token] = zones
+	return zones, nil
+}
+
+// findZoneIDFor looks up the ID of the zone that the name is in, which is the
+// zone with the longest name that the name is in, out of the ones the token
+// can access. That's the zone for a subdomain that has been delegated to a zone
+// of its own, rather than its parent.
+func (c *discoveryCache) findZoneIDFor(ctx context.Context, client *http.Client, token string, name string) (string, error) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	zones, err := c.zonesFor(ctx, client, token)
+	if err != nil {
+		return "", err
+	}
+	var best *CloudflareZone
+	for i, zone := range zones {
+		zoneName := strings.ToLower(zone.Name)
+		if name != zoneName && !strings.HasSuffix(name, "."+zoneName) {
+			continue
+		}
+		if best == nil || len(zoneName) > len(best.Name) {
+			best = &zones[i]
+		}
+	}
+	if best == nil {
+		return "", fmt.Errorf("%s isn't in any of the %d zones that the token can access, so set zone_id", name, len(zones))
+	}
+	return best.ID, nil
+}
+
+// discoverIDs returns a copy of the record with the IDs of its zone and its
+// recordType record filled in, from the cache in the context or looked up. It
+// also returns the keys in the cache of the IDs that were filled in, and
+// whether any of them came from the cache, for forgetting them if they turn
+// out to be out of date. If the record doesn't exist and has
+// create_if_missing set, it's created pointing at the address, and created is
+// true.
+func discoverIDs(ctx context.Context, client *http.Client, record *DNSRecord, recordType string, address string) (withIDs *DNSRecord, keys []string, cached bool, created bool, err error) {
+	cache := discoveryFrom(ctx)
+	withIDs = &DNSRecord{}
+	*withIDs = *record
+
+	if withIDs.ZoneID == "" {
+		key := zoneDiscoveryKey(record.APIToken, record.Name)
+		id, fromCache, err := cache.lookup(key, func() (string, error) {
+			return cache.findZoneIDFor(ctx, client, record.APIToken, record.Name)
+		})
+		if err != nil {
+			return nil, nil, false, false, fmt.Errorf("failed to discover zone ID: %w", err)
+		}
+		withIDs.ZoneID = id
+		keys = append(keys, key)
+		cached = fromCache
+	}
+
+	if withIDs.RecordID == "" {
+		key := recordDiscoveryKey(withIDs.ZoneID, recordType, record.Name)
+		id, fromCache, err := cache.lookup(key, func() (string, error) {
+			id, err := findRecordID(ctx, client, withIDs, recordType)
+			var missing *missingRecordError
+			if errors.As(err, &missing) && record.CreateIfMissing != nil && *record.CreateIfMissing {
+				if id, err = createCloudflareRecord(ctx, client, withIDs, recordType, address); err != nil {
+					return "", fmt.Errorf("failed to create record: %w", err)
+				}
+				created = true
+			}
+			return id, err
+		})
+		if err != nil {
+			if cached {
+				// The zone might be gone, so it's looked up again next time.
+				cache.forget(keys[0])
+			}
+			return nil, nil, false, false, fmt.Errorf("failed to discover record ID: %w", err)
+		}
+		withIDs.RecordID = id
+		keys = append(keys, key)
+		cached = cached || fromCache
+	}
+
+	return withIDs, keys, cached, created, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// DNSOMatic holds the settings for a record updated through DNS-O-Matic, which
+// passes each update on to every service configured in the account.
+type DNSOMatic struct {
+	// Username is the DNS-O-Matic account's username.
+	Username string `json:"username"`
+	// Password is the DNS-O-Matic account's password.
+	Password string `json:"password"`
+	// PasswordFile is the path to a file containing the password, which is read
+	// when the configuration is loaded if Password isn't set.
+	PasswordFile string `json:"password_file,omitempty"`
+	// Hostname is the hostname of one of the account's services to update. If
+	// it's empty, all of them are updated.
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// dynDNS2 returns the dyndns2 settings that DNS-O-Matic's update API uses.
+// The record's name isn't sent, because DNS-O-Matic identifies services by
+// the hostnames configured in the account.
+func (d *DNSOMatic) dynDNS2() *DynDNS2 {
+	hostname := d.Hostname
+	if hostname == "" {
+		hostname = "all.dnsomatic.com"
+	}
+	return &DynDNS2{
+		Server:   "https://updates.dnsomatic.com/nic/update",
+		Username: d.Username,
+		Password: d.Password,
+		Hostname: hostname,
+	}
+}
+
+// update sends the address to DNS-O-Matic. It responds with a line for each
+// service it updated, and the update only succeeds if all of them did.
+func (d *DNSOMatic) update(ctx context.Context, client *http.Client, record *DNSRecord, address string) error {
+	return d.dynDNS2().update(ctx, client, record, address)
+}
+
+// validate returns a description of each problem with the settings.
+func (d *DNSOMatic) validate() []string {
+	var problems []string
+	if strings.TrimSpace(d.Username) == "" {
+		problems = append(problems, "dnsomatic username is missing")
+	}
+	if d.Password == "" {
+		problems = append(problems, "dnsomatic password is missing")
+	}
+	return problems
+}
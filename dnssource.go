@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// DNSSource finds the public address by asking a DNS service what address the
+// query came from, which is faster than HTTPS and works where only DNS is
+// allowed out.
+type DNSSource struct {
+	// Service is "opendns" (the default), "cloudflare", or "google".
+	Service string `json:"service,omitempty"`
+	// Server overrides the address of the service's name server, optionally
+	// with a port.
+	Server string `json:"server,omitempty"`
+}
+
+// dnsWhoami is a query that a DNS service answers with the address it came
+// from, and the name servers that answer it.
+type dnsWhoami struct {
+	name   string
+	qtype  uint16
+	class  uint16
+	server map[string]string
+}
+
+const (
+	dnsTypeTXT      = 16
+	dnsClassCH      = 3
+	dnsQueryTimeout = 3 * time.Second
+)
+
+var dnsWhoamiServices = map[string]dnsWhoami{
+	// OpenDNS answers with an A or AAAA record, so the query type depends on
+	// the family of the address being looked up.
+	"opendns": {
+		name:  "myip.opendns.com",
+		class: dnsClassIN,
+		server: map[string]string{
+			"A":    "208.67.222.222",
+			"AAAA": "2620:119:35::35",
+		},
+	},
+	"cloudflare": {
+		name:  "whoami.cloudflare",
+		qtype: dnsTypeTXT,
+		class: dnsClassCH,
+		server: map[string]string{
+			"A":    "1.1.1.1",
+			"AAAA": "2606:4700:4700::1111",
+		},
+	},
+	"google": {
+		name:  "o-o.myaddr.l.google.com",
+		qtype: dnsTypeTXT,
+		class: dnsClassIN,
+		server: map[string]string{
+			"A":    "216.239.32.10",
+			"AAAA": "2001:4860:4802:32::a",
+		},
+	},
+}
+
+// whoami returns the query for the service.
+func (s *DNSSource) whoami() (dnsWhoami, error) {
+	service := s.Service
+	if service == "" {
+		service = "opendns"
+	}
+	query, ok := dnsWhoamiServices[service]
+	if !ok {
+		return dnsWhoami{}, fmt.Errorf("unknown DNS service %q", s.Service)
+	}
+	return query, nil
+}
+
+// currentIP asks the service for the address. The query is sent over the
+// address family of the record type, because the answer is the address that
+// the query came from.
+func (s *DNSSource) currentIP(ctx context.Context, recordType string) (string, error) {
+	query, err := s.whoami()
+	if err != nil {
+		return "", err
+	}
+	qtype := query.qtype
+	if qtype == 0 {
+		qtype = dnsTypeA
+		if recordType == "AAAA" {
+			qtype = dnsTypeAAAA
+		}
+	}
+
+	server := s.Server
+	if server == "" {
+		server = query.server[recordType]
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	network := "udp4"
+	if recordType == "AAAA" {
+		network = "udp6"
+	}
+
+	var id [2]byte
+	rand.Read(id[:])
+	name, err := dnsName(query.name)
+	if err != nil {
+		return "", err
+	}
+	message := append([]byte{}, id[:]...)
+	// Recursion desired, and one question.
+	message = append(message, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0)
+	message = append(message, name...)
+	message = binary.BigEndian.AppendUint16(message, qtype)
+	message = binary.BigEndian.AppendUint16(message, query.class)
+
+	conn, err := auditDial(ctx, "ip_lookup", network, server, dnsQueryTimeout)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to DNS server: %w", err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 65535)
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.Write(message); err != nil {
+			return "", fmt.Errorf("failed to send DNS query: %w", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(dnsQueryTimeout))
+		n, err := conn.Read(buf)
+		if err == nil {
+			if n < 2 || buf[0] != id[0] || buf[1] != id[1] {
+				// Anything that isn't the answer is ignored, and the query
+				// is sent again.
+				continue
+			}
+			return parseWhoamiAnswer(buf[:n], qtype)
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			return "", fmt.Errorf("failed to read DNS response: %w", err)
+		}
+	}
+	return "", errors.New("DNS server didn't respond")
+}
+
+// skipDNSName returns the offset just past the name at off, which may end in
+// a compression pointer.
+func skipDNSName(message []byte, off int) (int, error) {
+	for off < len(message) {
+		length := int(message[off])
+		switch {
+		case length == 0:
+			return off + 1, nil
+		case length&0xc0 == 0xc0:
+			return off + 2, nil
+		default:
+			off += 1 + length
+		}
+	}
+	return 0, errors.New("DNS response is truncated")
+}
+
+// dnsAnswer is a record in the answer section of a DNS response.
+type dnsAnswer struct {
+	rrType uint16
+	rdata  []byte
+}
+
+// parseDNSAnswers returns the records in the answer section of the response,
+// or an error if the server responded with one.
+func parseDNSAnswers(message []byte) ([]dnsAnswer, error) {
+	if len(message) < 12 {
+		return nil, errors.New("DNS response is truncated")
+	}
+	if rcode := message[3] & 0x0f; rcode != 0 {
+		return nil, fmt.Errorf("DNS server returned rcode %d", rcode)
+	}
+	questions := int(binary.BigEndian.Uint16(message[4:6]))
+	count := int(binary.BigEndian.Uint16(message[6:8]))
+
+	off := 12
+	var err error
+	for range questions {
+		if off, err = skipDNSName(message, off); err != nil {
+			return nil, err
+		}
+		off += 4
+	}
+
+	var answers []dnsAnswer
+	for range count {
+		if off, err = skipDNSName(message, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(message) {
+			return nil, errors.New("DNS response is truncated")
+		}
+		rrType := binary.BigEndian.Uint16(message[off:])
+		length := int(binary.BigEndian.Uint16(message[off+8:]))
+		off += 10
+		if off+length > len(message) {
+			return nil, errors.New("DNS response is truncated")
+		}
+		answers = append(answers, dnsAnswer{rrType: rrType, rdata: message[off : off+length]})
+		off += length
+	}
+	return answers, nil
+}
+
+// parseWhoamiAnswer returns the first address in the answers of the given type.
+// TXT answers contain the address as text.
+func parseWhoamiAnswer(message []byte, qtype uint16) (string, error) {
+	answers, err := parseDNSAnswers(message)
+	if err != nil {
+		return "", err
+	}
+	for _, answer := range answers {
+		rdata := answer.rdata
+		if answer.rrType != qtype {
+			continue
+		}
+
+		switch answer.rrType {
+		case dnsTypeA, dnsTypeAAAA:
+			if address, ok := netip.AddrFromSlice(rdata); ok {
+				return address.Unmap().String(), nil
+			}
+		case dnsTypeTXT:
+			for len(rdata) > 0 && int(rdata[0]) < len(rdata) {
+				text := string(rdata[1 : 1+int(rdata[0])])
+				rdata = rdata[1+int(rdata[0]):]
+				if address, err := netip.ParseAddr(text); err == nil {
+					return address.Unmap().String(), nil
+				}
+			}
+		}
+	}
+	return "", errors.New("DNS response doesn't contain an address")
+}
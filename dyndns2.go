@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DynDNS2 holds the settings for a record updated with the dyndns2 protocol,
+// which is supported by No-IP, Dyn, and many other dynamic DNS services.
+type DynDNS2 struct {
+	// Server is the URL of the service, such as https://dynupdate.no-ip.com.
+	// If it has no path, /nic/update is used.
+	Server string `json:"server"`
+	// Username is the account's username, or whatever the service uses in its
+	// place, such as a per-host username.
+	Username string `json:"username"`
+	// Password is the account's password or update key.
+	Password string `json:"password"`
+	// PasswordFile is the path to a file containing the password, which is read
+	// when the configuration is loaded if Password isn't set.
+	PasswordFile string `json:"password_file,omitempty"`
+	// Hostname is the name sent to the service, if it isn't the record's name.
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// updateURL returns the URL to request to point the host at the address.
+func (d *DynDNS2) updateURL(record *DNSRecord, address string) (string, error) {
+	parsed, err := url.Parse(d.Server)
+	if err != nil {
+		return "", fmt.Errorf("invalid dyndns2 server: %w", err)
+	}
+	if parsed.Path == "" || parsed.Path == "/" {
+		parsed.Path = "/nic/update"
+	}
+	hostname := d.Hostname
+	if hostname == "" {
+		hostname = record.Name
+	}
+	query := parsed.Query()
+	query.Set("hostname", hostname)
+	query.Set("myip", address)
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+// update points the host at the address. The service always responds with a
+// status of 200, and the first word of each line of the body says whether it
+// worked.
+func (d *DynDNS2) update(ctx context.Context, client *http.Client, record *DNSRecord, address string) error {
+	updateURL, err := d.updateURL(record, address)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", updateURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withPurpose(req, "dns_update")
+	req.SetBasicAuth(d.Username, d.Password)
+	// The protocol requires clients to identify themselves, and services
+	// block generic user agents.
+	req.Header.Set("User-Agent", "clouddns")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return &providerError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	return checkDynDNS2Response(string(body))
+}
+
+// dynDNS2Errors describes the results that mean the update was rejected.
+var dynDNS2Errors = map[string]string{
+	"badauth":  "the username or password is wrong",
+	"!donator": "the account doesn't have access to this feature",
+	"notfqdn":  "the hostname isn't a fully qualified domain name",
+	"nohost":   "the hostname doesn't exist in the account",
+	"numhost":  "too many hostnames were updated at once",
+	"abuse":    "the hostname is blocked for abuse",
+	"badagent": "the service blocked this client",
+	"dnserr":   "the service had a DNS error, try again later",
+	"911":      "the service is down, try again later",
+}
+
+// checkDynDNS2Response returns an error if any line of the response, one for
+// each hostname that was updated, doesn't start with "good" or "nochg".
+func checkDynDNS2Response(body string) error {
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return errors.New("dyndns2 server returned an empty response")
+	}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		code, _, _ := strings.Cut(line, " ")
+		if code == "good" || code == "nochg" {
+			continue
+		}
+		if description, ok := dynDNS2Errors[code]; ok {
+			return fmt.Errorf("dyndns2 server returned %q: %s", line, description)
+		}
+		return fmt.Errorf("dyndns2 server returned %q", line)
+	}
+	return nil
+}
+
+// validate returns a description of each problem with the settings.
+func (d *DynDNS2) validate() []string {
+	var problems []string
+	if err := validateEndpoint(&Endpoint{URL: d.Server}); err != nil {
+		problems = append(problems, fmt.Sprintf("dyndns2 server: %v", err))
+	}
+	if strings.TrimSpace(d.Username) == "" {
+		problems = append(problems, "dyndns2 username is missing")
+	}
+	if d.Password == "" {
+		problems = append(problems, "dyndns2 password is missing")
+	}
+	return problems
+}
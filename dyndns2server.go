@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dyndns2Server accepts updates from routers that speak the dyndns2 protocol
+// and applies them to the configured records, so the router doesn't need the
+// provider's credentials.
+type dyndns2Server struct {
+	logger   *slog.Logger
+	username string
+	password string
+
+	// mu is held while records are updated, so that two requests can't race
+	// on the cache files, and while the configuration is replaced.
+	mu            sync.Mutex
+	configuration *DNSConfiguration
+	client        *http.Client
+}
+
+// load loads the configuration and creates the client for it.
+func (s *dyndns2Server) load() error {
+	configuration, err := loadDNSConfiguration(s.logger)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	client, err := newHTTPClient(configuration.TLS, configuration.HTTP)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP client: %w", err)
+	}
+	if configuration.Bind != nil {
+		if client, err = withBinding(client, configuration.Bind); err != nil {
+			return fmt.Errorf("failed to create HTTP client: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configuration = &configuration
+	s.client = client
+	return nil
+}
+
+// ServeHTTP handles /nic/update requests. The response is a line for each
+// hostname, in the same format as the dyndns2 services that routers expect.
+func (s *dyndns2Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	username, password, ok := r.BasicAuth()
+	if !ok ||
+		subtle.ConstantTimeCompare([]byte(username), []byte(s.username)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) != 1 {
+		s.logger.Warn("Rejected dyndns2 update with bad credentials", "remote_addr", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Basic realm="clouddns"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "badauth")
+		return
+	}
+
+	query := r.URL.Query()
+	var addresses []netip.Addr
+	for _, value := range []string{query.Get("myip"), query.Get("myipv6")} {
+		for field := range strings.SplitSeq(value, ",") {
+			if field = strings.TrimSpace(field); field == "" {
+				continue
+			}
+			address, err := netip.ParseAddr(field)
+			if err != nil {
+				s.logger.Warn("Rejected dyndns2 update with an invalid address", "myip", field)
+				fmt.Fprintln(w, "911")
+				return
+			}
+			addresses = append(addresses, address.Unmap())
+		}
+	}
+	// Like the public services, the address the request came from is used if
+	// the client doesn't say which address to use.
+	if len(addresses) == 0 {
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			if address, err := netip.ParseAddr(host); err == nil {
+				addresses = append(addresses, address.Unmap())
+			}
+		}
+	}
+
+	hostnames := strings.Split(query.Get("hostname"), ",")
+	for _, hostname := range hostnames {
+		fmt.Fprintln(w, s.update(strings.TrimSpace(hostname), addresses))
+	}
+}
+
+// update points every record with the hostname at the address of its family,
+// and returns the dyndns2 response for the hostname.
+func (s *dyndns2Server) update(hostname string, addresses []netip.Addr) string {
+	if !isValidDNSName(hostname) {
+		return "notfqdn"
+	}
+	logger := s.logger.With("hostname", hostname)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configuration.refreshSecretFiles(s.logger)
+
+	var statuses []RecordStatus
+	found := false
+	for _, group := range []struct {
+		recordType string
+		records    []DNSRecord
+	}{{"A", s.configuration.A}, {"AAAA", s.configuration.AAAA}} {
+		for i := range group.records {
+			record := &group.records[i]
+			// Records with a static address are never changed by updates.
+			if !strings.EqualFold(strings.TrimSuffix(record.Name, "."), strings.TrimSuffix(hostname, ".")) || record.IP != "" {
+				continue
+			}
+			found = true
+
+			for _, address := range addresses {
+				if address.Is4() != (group.recordType == "A") {
+					continue
+				}
+				statuses = append(statuses, s.updateRecord(logger, record, group.recordType, address))
+			}
+		}
+	}
+
+	if !found {
+		logger.Warn("Rejected dyndns2 update for a hostname that isn't configured")
+		return "nohost"
+	}
+	if len(statuses) == 0 {
+		logger.Warn("Rejected dyndns2 update without an address for any of the hostname's records")
+		return "911"
+	}
+
+	result := "nochg"
+	var updated []string
+	for _, status := range statuses {
+		switch status.Result {
+		case recordFailed:
+			return "911"
+		case recordUpdated:
+			result = "good"
+		}
+		updated = append(updated, status.IP)
+	}
+	return result + " " + strings.Join(updated, ",")
+}
+
+// updateRecord syncs one record to the address, applying the same checks as an
+// address from an IP source.
+func (s *dyndns2Server) updateRecord(logger *slog.Logger, record *DNSRecord, recordType string, address netip.Addr) RecordStatus {
+	fail := func(err error) RecordStatus {
+		logger.Error("Failed to apply dyndns2 update", "record_type", recordType, "error", err)
+		return RecordStatus{Name: record.Name, Type: recordType, RecordID: record.RecordID, Result: recordFailed, Error: err.Error()}
+	}
+
+	ip := address.String()
+	if record.AllowPrivate == nil || !*record.AllowPrivate {
+		if err := checkPublic(ip); err != nil {
+			return fail(fmt.Errorf("the update %w", err))
+		}
+	}
+	if record.IPv6Suffix != "" {
+		var err error
+		if ip, err = withIPv6Suffix(ip, record.IPv6Suffix, record.PrefixLength); err != nil {
+			return fail(err)
+		}
+	}
+	return syncRecord(context.Background(), logger, s.client, record, recordType, getCachePath(), ip)
+}
+
+// dyndns2ServerCommand handles "clouddns dyndns2-server", which listens for
+// dyndns2 updates and applies them to the configured records.
+func dyndns2ServerCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("dyndns2-server", flag.ExitOnError)
+	listen := flags.String("listen", ":8245", "address to listen on")
+	certFile := flags.String("tls-cert", "", "path to a PEM certificate, to serve HTTPS")
+	keyFile := flags.String("tls-key", "", "path to the PEM private key for -tls-cert")
+	flags.Parse(args)
+
+	server := &dyndns2Server{
+		logger:   logger.With("component", "dyndns2_server"),
+		username: os.Getenv("DDNS_DYNDNS2_USERNAME"),
+		password: os.Getenv("DDNS_DYNDNS2_PASSWORD"),
+	}
+	if path := os.Getenv("DDNS_DYNDNS2_PASSWORD_FILE"); server.password == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read DDNS_DYNDNS2_PASSWORD_FILE: %w", err)
+		}
+		server.password = strings.TrimSpace(string(data))
+	}
+	if server.username == "" || server.password == "" {
+		return errors.New("DDNS_DYNDNS2_USERNAME and DDNS_DYNDNS2_PASSWORD must be set")
+	}
+	if (*certFile == "") != (*keyFile == "") {
+		return errors.New("-tls-cert and -tls-key must be used together")
+	}
+	if err := server.load(); err != nil {
+		return err
+	}
+
+	ctx, stop := stopContext(server.logger)
+	defer stop()
+
+	// The configuration is reloaded when it changes, like in the daemon.
+	if path := os.Getenv("DDNS_CONFIG_PATH"); path != "" && path != "-" && !isConfigURL(path) {
+		go watchFileTouches(ctx, path, func() {
+			if err := server.load(); err != nil {
+				server.logger.Error("Failed to reload configuration", "error", err)
+				return
+			}
+			server.logger.Info("Reloaded configuration")
+		})
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/nic/update", server)
+	// Some clients use the path of the newer version of the protocol.
+	mux.Handle("/v3/update", server)
+	httpServer := &http.Server{
+		Addr:              *listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	server.logger.Info("Listening for dyndns2 updates", "address", *listen)
+	var err error
+	if *certFile != "" {
+		err = httpServer.ListenAndServeTLS(*certFile, *keyFile)
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
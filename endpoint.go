@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Endpoint is a URL that requests are made to, along with any settings that
+// only apply to it. In the configuration file, an endpoint can be written as
+// either a plain URL string or an object.
+type Endpoint struct {
+	URL string `json:"url"`
+	// TLS overrides the top-level TLS configuration for requests to this endpoint.
+	// Fields that aren't set are inherited from the top-level configuration.
+	TLS *TLSConfig `json:"tls,omitempty"`
+	// FollowRedirects allows redirects to other hosts to be followed. By default,
+	// only redirects to the same host are followed.
+	FollowRedirects bool `json:"follow_redirects,omitempty"`
+	// Headers are added to every request to the endpoint, for services that
+	// need an API key.
+	Headers map[string]string `json:"headers,omitempty"`
+	// BasicAuth is sent with every request to the endpoint.
+	BasicAuth *BasicAuth `json:"basic_auth,omitempty"`
+	// Bind overrides the interface or address that requests to this endpoint
+	// are made from.
+	Bind *BindConfig `json:"bind,omitempty"`
+}
+
+// BasicAuth is a username and password for HTTP basic authentication.
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+	// PasswordFile is the path to a file containing the password, which is read
+	// when the configuration is loaded if Password isn't set.
+	PasswordFile string `json:"password_file,omitempty"`
+}
+
+// authorize adds the endpoint's headers and credentials to the request.
+func (e *Endpoint) authorize(req *http.Request) {
+	for name, value := range e.Headers {
+		req.Header.Set(name, value)
+	}
+	if e.BasicAuth != nil {
+		req.SetBasicAuth(e.BasicAuth.Username, e.BasicAuth.Password)
+	}
+}
+
+func (e *Endpoint) UnmarshalJSON(data []byte) error {
+	var url string
+	if err := json.Unmarshal(data, &url); err == nil {
+		*e = Endpoint{URL: url}
+		return nil
+	}
+
+	// The alias type doesn't have this method, which avoids infinite recursion.
+	type endpoint Endpoint
+	var decoded endpoint
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*e = Endpoint(decoded)
+	return nil
+}
+
+// httpClient returns the client that should be used for requests to the endpoint.
+// Endpoints without any settings of their own use the default client.
+func (e *Endpoint) httpClient(defaultClient *http.Client) (*http.Client, error) {
+	client := defaultClient
+	if e.TLS != nil {
+		var err error
+		if client, err = withTLS(client, e.TLS); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.Bind != nil {
+		var err error
+		if client, err = withBinding(client, e.Bind); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.FollowRedirects {
+		// Copy the client so the default client's policy isn't changed.
+		withRedirects := *client
+		withRedirects.CheckRedirect = nil
+		client = &withRedirects
+	}
+
+	return client, nil
+}
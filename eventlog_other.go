@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+func withEventLog(handler slog.Handler) (slog.Handler, error) {
+	return nil, fmt.Errorf("the Windows Event Log is only available on Windows")
+}
+
+func eventLogCommand(logger *slog.Logger, args []string) error {
+	return fmt.Errorf("the eventlog command is only supported on Windows")
+}
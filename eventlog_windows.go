@@ -0,0 +1,189 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// eventLogSource is the name that events are reported under in the Application log.
+const eventLogSource = "clouddns"
+
+// eventLogSourceKey is the registry key that registers the event source.
+const eventLogSourceKey = `SYSTEM\CurrentControlSet\Services\EventLog\Application\` + eventLogSource
+
+// eventLogMessageFile is used because it passes the text of each event through
+// unchanged, so clouddns doesn't need its own message resources.
+const eventLogMessageFile = `%SystemRoot%\System32\EventCreate.exe`
+
+// eventLogEventID is the ID of every event. EventCreate.exe only accepts IDs
+// between 1 and 1000.
+const eventLogEventID = 1
+
+const (
+	eventLogErrorType       = 0x0001
+	eventLogWarningType     = 0x0002
+	eventLogInformationType = 0x0004
+)
+
+var (
+	procRegisterEventSourceW  = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+	procReportEventW          = advapi32.NewProc("ReportEventW")
+)
+
+// registerEventSource adds the registry entries that let Event Viewer display
+// the events reported by clouddns.
+func registerEventSource() error {
+	key, err := regCreateKey(syscall.HKEY_LOCAL_MACHINE, eventLogSourceKey)
+	if err != nil {
+		return fmt.Errorf("failed to create event source registry key: %w", err)
+	}
+	defer syscall.RegCloseKey(key)
+
+	if err := regSetString(key, "EventMessageFile", syscall.REG_EXPAND_SZ, eventLogMessageFile); err != nil {
+		return fmt.Errorf("failed to set event message file: %w", err)
+	}
+	supportedTypes := uint32(eventLogErrorType | eventLogWarningType | eventLogInformationType)
+	if err := regSetDWORD(key, "TypesSupported", supportedTypes); err != nil {
+		return fmt.Errorf("failed to set supported event types: %w", err)
+	}
+	return nil
+}
+
+func unregisterEventSource() error {
+	return regDeleteKey(syscall.HKEY_LOCAL_MACHINE, eventLogSourceKey)
+}
+
+// eventLogWriter reports everything written to it as a single event, with the
+// type set by the handler immediately before writing.
+type eventLogWriter struct {
+	handle    uintptr
+	eventType uint16
+}
+
+func (w *eventLogWriter) Write(p []byte) (int, error) {
+	message, err := syscall.UTF16PtrFromString(string(bytes.TrimSpace(p)))
+	if err != nil {
+		return 0, err
+	}
+	strings := []*uint16{message}
+	r, _, err := procReportEventW.Call(
+		w.handle,
+		uintptr(w.eventType),
+		0,
+		uintptr(eventLogEventID),
+		0,
+		uintptr(len(strings)),
+		0,
+		uintptr(unsafe.Pointer(&strings[0])),
+		0)
+	if r == 0 {
+		return 0, fmt.Errorf("failed to report event: %w", err)
+	}
+	return len(p), nil
+}
+
+// eventLogHandler is a slog.Handler that formats records as JSON, the same as
+// the default output, and reports them to the Windows Event Log.
+type eventLogHandler struct {
+	inner  slog.Handler
+	writer *eventLogWriter
+	mu     *sync.Mutex
+}
+
+// newEventLogHandler opens the event log. The handler only reports records at
+// or above the given level.
+func newEventLogHandler(level slog.Leveler) (*eventLogHandler, error) {
+	source, err := syscall.UTF16PtrFromString(eventLogSource)
+	if err != nil {
+		return nil, err
+	}
+	handle, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(source)))
+	if handle == 0 {
+		return nil, fmt.Errorf("failed to register event source: %w", err)
+	}
+
+	writer := &eventLogWriter{handle: handle}
+	return &eventLogHandler{
+		inner:  slog.NewJSONHandler(writer, &slog.HandlerOptions{Level: level}),
+		writer: writer,
+		mu:     &sync.Mutex{},
+	}, nil
+}
+
+func (h *eventLogHandler) Close() error {
+	r, _, err := procDeregisterEventSource.Call(h.writer.handle)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func (h *eventLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *eventLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case r.Level >= slog.LevelError:
+		h.writer.eventType = eventLogErrorType
+	case r.Level >= slog.LevelWarn:
+		h.writer.eventType = eventLogWarningType
+	default:
+		h.writer.eventType = eventLogInformationType
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *eventLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &eventLogHandler{inner: h.inner.WithAttrs(attrs), writer: h.writer, mu: h.mu}
+}
+
+func (h *eventLogHandler) WithGroup(name string) slog.Handler {
+	return &eventLogHandler{inner: h.inner.WithGroup(name), writer: h.writer, mu: h.mu}
+}
+
+// withEventLog returns a handler that passes records to the given handler and
+// also reports warnings and errors to the Windows Event Log.
+func withEventLog(handler slog.Handler) (slog.Handler, error) {
+	eventLog, err := newEventLogHandler(slog.LevelWarn)
+	if err != nil {
+		return nil, err
+	}
+	return multiHandler{handler, eventLog}, nil
+}
+
+// eventLogCommand handles "clouddns eventlog <register|unregister>", which
+// registers the event source without installing the service, for when clouddns
+// is run some other way, such as from Task Scheduler.
+func eventLogCommand(logger *slog.Logger, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: clouddns eventlog <register|unregister>")
+	}
+
+	switch args[0] {
+	case "register":
+		if err := registerEventSource(); err != nil {
+			return err
+		}
+		logger.Info("Registered event source", "source", eventLogSource)
+	case "unregister":
+		if err := unregisterEventSource(); err != nil {
+			return fmt.Errorf("failed to remove event source: %w", err)
+		}
+		logger.Info("Unregistered event source", "source", eventLogSource)
+	default:
+		return fmt.Errorf("unknown eventlog command %q", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	eventIPChanged     = "ip_changed"
+	eventRecordUpdated = "record_updated"
+	eventRecordFailed  = "record_failed"
+)
+
+// Event is sent to every subscriber of the events socket as a line of JSON
+type Event struct {
+	Time time.Time `json:"time"`
+	// Event is one of "ip_changed", "record_updated", or "record_failed".
+	Event      string `json:"event"`
+	RecordName string `json:"record_name,omitempty"`
+	RecordType string `json:"record_type"`
+	IP         string `json:"ip,omitempty"`
+	OldIP      string `json:"old_ip,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// eventServer accepts connections on a Unix domain socket and streams events
+// to them. Subscribers don't send anything, and a subscriber that can't keep
+// up is disconnected rather than slowing down the sync.
+type eventServer struct {
+	logger      *slog.Logger
+	listener    net.Listener
+	mu          sync.Mutex
+	subscribers map[net.Conn]struct{}
+}
+
+func newEventServer(logger *slog.Logger, path string) (*eventServer, error) {
+	// A socket left behind by a process that didn't exit cleanly would
+	// otherwise prevent listening.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove existing events socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on events socket: %w", err)
+	}
+
+	return &eventServer{
+		logger:      logger.With("component", "events", "path", path),
+		listener:    listener,
+		subscribers: map[net.Conn]struct{}{},
+	}, nil
+}
+
+// serve accepts subscribers until the context is done, then closes the socket
+// and disconnects every subscriber.
+func (s *eventServer) serve(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		s.listener.Close()
+	}()
+
+	s.logger.Info("Listening for event subscribers")
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Error("Failed to accept event subscriber", "error", err)
+			}
+			break
+		}
+
+		s.mu.Lock()
+		s.subscribers[conn] = struct{}{}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.subscribers {
+		conn.Close()
+		delete(s.subscribers, conn)
+	}
+}
+
+func (s *eventServer) broadcast(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	var data []byte
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			s.logger.Error("Failed to marshal event", "error", err)
+			return
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.subscribers {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write(data); err != nil {
+			s.logger.Info("Disconnected event subscriber", "error", err)
+			conn.Close()
+			delete(s.subscribers, conn)
+		}
+	}
+}
+
+// runEvents returns the events for a completed run. lastIPs holds the address
+// last seen for each record type, and is updated so that "ip_changed" is only
+// sent when the address is different from the previous run.
+func runEvents(status *RunStatus, lastIPs map[string]string) []Event {
+	var events []Event
+
+	currentIPs := map[string]string{}
+	for _, record := range status.Records {
+		if record.IP != "" {
+			currentIPs[record.Type] = record.IP
+		}
+	}
+	for recordType, ip := range currentIPs {
+		oldIP, known := lastIPs[recordType]
+		lastIPs[recordType] = ip
+		// There's nothing to compare against for the first run.
+		if known && oldIP != ip {
+			events = append(events, Event{
+				Time:       status.FinishTime,
+				Event:      eventIPChanged,
+				RecordType: recordType,
+				IP:         ip,
+				OldIP:      oldIP,
+			})
+		}
+	}
+
+	for _, record := range status.Records {
+		event := Event{
+			Time:       status.FinishTime,
+			RecordName: record.Name,
+			RecordType: record.Type,
+			IP:         record.IP,
+			Error:      record.Error,
+		}
+		switch record.Result {
+		case recordUpdated:
+			event.Event = eventRecordUpdated
+		case recordFailed:
+			event.Event = eventRecordFailed
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExecProvider holds the settings for a record that's updated by running a
+// command, for DNS providers that clouddns doesn't support.
+type ExecProvider struct {
+	// Command is the program to run and its arguments. The record's name and
+	// type and the address are added as the last three arguments.
+	Command []string `json:"command"`
+	// Timeout is how many seconds the command can run before it's killed. The
+	// default is 60.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// update runs the command, which succeeded if it exits with status 0. The
+// details are also passed in the environment, so that scripts can ignore the
+// arguments.
+func (e *ExecProvider) update(ctx context.Context, record *DNSRecord, recordType string, address string) error {
+	command := append(e.Command[:len(e.Command):len(e.Command)], record.Name, recordType, address)
+	_, err := runCommand(ctx, "dns_update", command, e.Timeout, []string{
+		"DDNS_RECORD_NAME=" + record.Name,
+		"DDNS_RECORD_TYPE=" + recordType,
+		"DDNS_IP_ADDRESS=" + address,
+		"DDNS_TTL=" + strconv.Itoa(record.TTL),
+	})
+	return err
+}
+
+// runCommand runs the command with the extra environment variables and returns
+// what it wrote to standard output. The timeout is in seconds, and defaults to
+// a minute. If the command fails, the error includes its output. The command
+// could talk to anything, so it's recorded in the audit log in the context
+// with the purpose.
+func runCommand(ctx context.Context, purpose string, command []string, timeoutSeconds int, env []string) (output string, err error) {
+	timeout := time.Duration(timeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	defer func() {
+		entry := AuditEntry{Purpose: purpose, Method: "exec", Host: command[0]}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		auditLogFrom(ctx).add(entry)
+	}()
+	// The run's deadline and shutdown grace period kill the command too.
+	errTimedOut := fmt.Errorf("command timed out after %s", timeout)
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, errTimedOut)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		if cause := context.Cause(ctx); cause != errTimedOut {
+			return "", fmt.Errorf("command was stopped: %w", cause)
+		}
+		return "", errTimedOut
+	}
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = strings.TrimSpace(stdout.String())
+		}
+		if message != "" {
+			return "", fmt.Errorf("command failed: %w: %s", err, message)
+		}
+		return "", fmt.Errorf("command failed: %w", err)
+	}
+	return stdout.String(), nil
+}
+
+// checkCommand returns a description of the problem if the command can't be
+// run, or an empty string if it can.
+func checkCommand(command []string) string {
+	if len(command) == 0 || strings.TrimSpace(command[0]) == "" {
+		return "command is missing"
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		var execErr *exec.Error
+		if errors.As(err, &execErr) {
+			err = execErr.Err
+		}
+		return fmt.Sprintf("command %s can't be run: %v", command[0], err)
+	}
+	return ""
+}
+
+// validate returns a description of each problem with the settings.
+func (e *ExecProvider) validate() []string {
+	if problem := checkCommand(e.Command); problem != "" {
+		return []string{"exec " + problem}
+	}
+	return nil
+}
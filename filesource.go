@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+// FileSource reads the address from a file that something else keeps up to
+// date, like a pppd ip-up script or a DHCPv6 client hook that saves the
+// delegated prefix.
+type FileSource struct {
+	// Path is the file to read.
+	Path string `json:"path"`
+	// Key is the name of the variable to read, for files of shell variables
+	// like KEY=value. If it's empty, the first line of the file is used.
+	Key string `json:"key,omitempty"`
+}
+
+// currentIP returns the address in the file. If the file has a prefix instead,
+// like 2001:db8:1::/56, the prefix's first address is returned, which is meant
+// to be combined with a record's ipv6_suffix.
+func (s *FileSource) currentIP() (string, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read address file: %w", err)
+	}
+
+	value, err := s.value(string(data))
+	if err != nil {
+		return "", err
+	}
+	// DHCPv6 clients write prefixes with their lifetimes after them, like
+	// "2001:db8:1::/56,7200,3600", and separate several with spaces, so only
+	// the first one is used.
+	value, _, _ = strings.Cut(strings.Fields(value)[0], ",")
+
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return "", fmt.Errorf("address file has %q, which isn't a prefix", value)
+		}
+		return prefix.Masked().Addr().String(), nil
+	}
+	return value, nil
+}
+
+// value returns the part of the file that has the address.
+func (s *FileSource) value(data string) (string, error) {
+	for line := range strings.SplitSeq(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if s.Key == "" {
+			return line, nil
+		}
+
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok || strings.TrimSpace(key) != s.Key {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		if strings.TrimSpace(value) == "" {
+			return "", fmt.Errorf("%s is empty in the address file", s.Key)
+		}
+		return value, nil
+	}
+
+	if s.Key != "" {
+		return "", fmt.Errorf("address file doesn't set %s", s.Key)
+	}
+	return "", errors.New("address file is empty")
+}
+
+// sourceFiles returns the path of every file that a file IP source reads from,
+// including the sources of records and uplinks.
+func (c *DNSConfiguration) sourceFiles() []string {
+	var paths []string
+	add := func(sources IPSourceList) {
+		for _, source := range sources {
+			if source.Type == "file" && source.File != nil && source.File.Path != "" && !slices.Contains(paths, source.File.Path) {
+				paths = append(paths, source.File.Path)
+			}
+		}
+	}
+	add(c.IPSources.A)
+	add(c.IPSources.AAAA)
+	for _, records := range [][]DNSRecord{c.A, c.AAAA} {
+		for _, record := range records {
+			add(record.IPSources)
+		}
+	}
+	return paths
+}
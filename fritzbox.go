@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// FritzBoxSource asks an AVM Fritz!Box for its WAN address over the UPnP
+// interface that it also uses for TR-064, which answers instantly and knows
+// both the IPv4 address and the delegated IPv6 prefix.
+type FritzBoxSource struct {
+	// Address is the router's host, optionally with a port. The default is
+	// fritz.box, and the default port is 49000.
+	Address string `json:"address,omitempty"`
+	// IPv6 is "address" (the default) to use the router's own WAN address for
+	// AAAA records, or "prefix" to use the prefix delegated to the LAN.
+	IPv6 string `json:"ipv6,omitempty"`
+}
+
+// fritzBoxService is the WAN connection service on the Fritz!Box's IGD
+// interface, which doesn't need a password. It's the same for DSL, cable, and
+// fibre connections.
+const (
+	fritzBoxService     = "urn:schemas-upnp-org:service:WANIPConnection:1"
+	fritzBoxControlPath = "/igdupnp/control/WANIPConn1"
+)
+
+// currentIP returns the router's WAN address for the record type. A prefix is
+// returned as its first address, which is meant to be combined with a record's
+// ipv6_suffix.
+func (s *FritzBoxSource) currentIP(client *http.Client, recordType string) (string, error) {
+	host := s.Address
+	if host == "" {
+		host = "fritz.box"
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "49000")
+	}
+	controlURL := "http://" + host + fritzBoxControlPath
+
+	action, element := "GetExternalIPAddress", "NewExternalIPAddress"
+	if recordType == "AAAA" {
+		switch s.IPv6 {
+		case "", "address":
+			action, element = "X_AVM_DE_GetExternalIPv6Address", "NewExternalIPv6Address"
+		case "prefix":
+			action, element = "X_AVM_DE_GetIPv6Prefix", "NewIPv6Prefix"
+		default:
+			return "", fmt.Errorf("unknown fritzbox ipv6 setting %q", s.IPv6)
+		}
+	}
+
+	value, err := upnpAction(client, controlURL, fritzBoxService, action, element)
+	if err != nil {
+		return "", fmt.Errorf("fritzbox: %w", err)
+	}
+	value = strings.TrimSpace(value)
+	if address, err := netip.ParseAddr(value); err != nil || address.IsUnspecified() {
+		// The router reports an empty or unspecified address while it isn't
+		// connected.
+		return "", fmt.Errorf("fritzbox has no WAN address for %s records, it might not be connected", recordType)
+	}
+	return value, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GandiLiveDNS holds the settings for a record in a domain on Gandi LiveDNS.
+type GandiLiveDNS struct {
+	// APIToken is a Gandi personal access token with the "Manage domain name
+	// technical configurations" permission.
+	APIToken string `json:"api_token"`
+	// APITokenFile is the path to a file containing the API token, which is
+	// read when the configuration is loaded if APIToken isn't set.
+	APITokenFile string `json:"api_token_file,omitempty"`
+	// Domain is the domain the record is in, such as example.com. The record's
+	// name must be the domain or a name under it.
+	Domain string `json:"domain"`
+}
+
+// gandiRecordUpdate is the body of a request to replace a record set.
+type gandiRecordUpdate struct {
+	Values []string `json:"rrset_values"`
+	TTL    int      `json:"rrset_ttl,omitempty"`
+}
+
+// update replaces the record set with the address, creating it if it doesn't
+// exist. Gandi's minimum TTL is 300 seconds, and it uses 10800 if none is set.
+func (g *GandiLiveDNS) update(ctx context.Context, client *http.Client, record *DNSRecord, recordType string, address string) error {
+	name, err := relativeName(record.Name, g.Domain)
+	if err != nil {
+		return err
+	}
+	update := gandiRecordUpdate{Values: []string{address}}
+	if record.TTL > 1 {
+		update.TTL = max(record.TTL, 300)
+	}
+
+	req, err := newJSONRequest(ctx, "PUT", "https://api.gandi.net/v5/livedns/domains/"+url.PathEscape(g.Domain)+
+		"/records/"+url.PathEscape(name)+"/"+recordType, update)
+	if err != nil {
+		return err
+	}
+	req = withPurpose(req, "dns_update")
+	req.Header.Set("Authorization", "Bearer "+g.APIToken)
+	return doJSON(client, req, nil)
+}
+
+// validate returns a description of each problem with the settings for the
+// record.
+func (g *GandiLiveDNS) validate(record *DNSRecord) []string {
+	var problems []string
+	if strings.TrimSpace(g.APIToken) == "" {
+		problems = append(problems, "gandi api_token is missing")
+	}
+	if strings.TrimSpace(g.Domain) == "" {
+		problems = append(problems, "gandi domain is missing")
+	} else if _, err := relativeName(record.Name, g.Domain); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GatewaySource asks the local router for its WAN address with NAT-PMP or UPnP,
+// without depending on any external service. Only IPv4 addresses can be found
+// this way.
+type GatewaySource struct {
+	// Protocol is "natpmp", "upnp", or empty to try NAT-PMP and then UPnP.
+	Protocol string `json:"protocol,omitempty"`
+	// Address is the router's address for NAT-PMP. The default gateway is used
+	// if it's empty, which is only found automatically on Linux.
+	Address string `json:"address,omitempty"`
+	// Location is the URL of the router's UPnP device description, which skips
+	// discovery. It's in the LOCATION header of the router's SSDP responses.
+	Location string `json:"location,omitempty"`
+}
+
+// currentIP returns the router's WAN address. If that's a private or shared
+// address, the router is behind another NAT, and its address isn't the public
+// one, so an error is returned instead.
+func (s *GatewaySource) currentIP(ctx context.Context, client *http.Client, recordType string) (string, error) {
+	if recordType != "A" {
+		return "", errors.New("gateway IP sources only support A records")
+	}
+
+	var address netip.Addr
+	var err error
+	switch s.Protocol {
+	case "natpmp":
+		address, err = s.natPMP(ctx)
+	case "upnp":
+		address, err = s.upnp(ctx, client)
+	case "":
+		address, err = s.natPMP(ctx)
+		if err != nil {
+			natPMPErr := err
+			if address, err = s.upnp(ctx, client); err != nil {
+				err = errors.Join(natPMPErr, err)
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown gateway protocol %q", s.Protocol)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if address.IsPrivate() || cgnatPrefix.Contains(address) || address.IsUnspecified() {
+		return "", fmt.Errorf("the router's WAN address %s isn't public, so it's behind another NAT", address)
+	}
+	return address.String(), nil
+}
+
+// natPMP requests the external address from the gateway, as described in
+// RFC 6886.
+func (s *GatewaySource) natPMP(ctx context.Context) (netip.Addr, error) {
+	gateway := s.Address
+	if gateway == "" {
+		found, err := defaultGateway()
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("NAT-PMP: %w", err)
+		}
+		gateway = found.String()
+	}
+
+	conn, err := auditDial(ctx, "ip_lookup", "udp4", net.JoinHostPort(gateway, "5351"), 5*time.Second)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("NAT-PMP: failed to connect to gateway: %w", err)
+	}
+	defer conn.Close()
+
+	// Version 0, opcode 0 is an external address request.
+	buf := make([]byte, 16)
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.Write([]byte{0, 0}); err != nil {
+			return netip.Addr{}, fmt.Errorf("NAT-PMP: failed to send request: %w", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Duration(250<<attempt) * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err == nil {
+			if n < 12 || buf[0] != 0 || buf[1] != 128 {
+				return netip.Addr{}, errors.New("NAT-PMP: gateway returned an invalid response")
+			}
+			if result := binary.BigEndian.Uint16(buf[2:4]); result != 0 {
+				return netip.Addr{}, fmt.Errorf("NAT-PMP: gateway returned result code %d", result)
+			}
+			return netip.AddrFrom4([4]byte(buf[8:12])), nil
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			return netip.Addr{}, fmt.Errorf("NAT-PMP: failed to read response: %w", err)
+		}
+	}
+	return netip.Addr{}, errors.New("NAT-PMP: gateway didn't respond")
+}
+
+// upnpServiceTypes are the services that can report the WAN address.
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// upnp calls GetExternalIPAddress on the router's WAN connection service.
+func (s *GatewaySource) upnp(ctx context.Context, client *http.Client) (netip.Addr, error) {
+	location := s.Location
+	if location == "" {
+		var err error
+		if location, err = discoverUPnPGateway(ctx); err != nil {
+			return netip.Addr{}, fmt.Errorf("UPnP: %w", err)
+		}
+	}
+
+	serviceType, controlURL, err := findUPnPService(client, location)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("UPnP: %w", err)
+	}
+
+	value, err := upnpAction(client, controlURL, serviceType, "GetExternalIPAddress", "NewExternalIPAddress")
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("UPnP: %w", err)
+	}
+	address, err := netip.ParseAddr(strings.TrimSpace(value))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("UPnP: router returned %q, which isn't an IP address", value)
+	}
+	return address.Unmap(), nil
+}
+
+// upnpAction calls an action without arguments on a UPnP service and returns
+// the text of the named element in the response.
+func upnpAction(client *http.Client, controlURL string, serviceType string, action string, element string) (string, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + serviceType + `"></u:` + action + `></s:Body></s:Envelope>`
+	req, err := http.NewRequest("POST", controlURL, strings.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withPurpose(req, "ip_lookup")
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+serviceType+`#`+action+`"`)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("router returned status code %d", resp.StatusCode)
+	}
+	return findXMLElement(resp.Body, element)
+}
+
+// discoverUPnPGateway finds a router with SSDP and returns the location of its
+// device description.
+func discoverUPnPGateway(ctx context.Context) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", fmt.Errorf("failed to listen for SSDP responses: %w", err)
+	}
+	defer conn.Close()
+
+	multicast := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	// The search is multicast rather than a connection, so it's recorded in the
+	// audit log by itself.
+	entry := AuditEntry{Purpose: "ip_lookup", Method: "udp4", Host: multicast.IP.String(), Port: strconv.Itoa(multicast.Port)}
+	defer func() { auditLogFrom(ctx).add(entry) }()
+	for _, serviceType := range upnpServiceTypes {
+		search := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: 239.255.255.250:1900\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 2\r\n" +
+			"ST: " + serviceType + "\r\n\r\n"
+		n, err := conn.WriteTo([]byte(search), multicast)
+		entry.BytesSent += int64(n)
+		if err != nil {
+			entry.Error = err.Error()
+			return "", fmt.Errorf("failed to send SSDP search: %w", err)
+		}
+	}
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", errors.New("no UPnP router responded")
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if location := resp.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
+
+// findUPnPService reads the device description and returns the type and the
+// absolute control URL of the first WAN connection service in it.
+func findUPnPService(client *http.Client, location string) (string, string, error) {
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withPurpose(req, "ip_lookup")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read device description: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("device description returned status code %d", resp.StatusCode)
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid location %q: %w", location, err)
+	}
+
+	// Services are nested inside devices at different depths depending on the
+	// router, so every service element is checked.
+	decoder := xml.NewDecoder(resp.Body)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return "", "", errors.New("router doesn't have a WAN connection service")
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("failed to parse device description: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "URLBase":
+			var urlBase string
+			if err := decoder.DecodeElement(&urlBase, &start); err == nil && urlBase != "" {
+				if parsed, err := url.Parse(strings.TrimSpace(urlBase)); err == nil {
+					base = parsed
+				}
+			}
+		case "service":
+			var service struct {
+				ServiceType string `xml:"serviceType"`
+				ControlURL  string `xml:"controlURL"`
+			}
+			if err := decoder.DecodeElement(&service, &start); err != nil {
+				continue
+			}
+			for _, serviceType := range upnpServiceTypes {
+				if service.ServiceType == serviceType {
+					control, err := base.Parse(strings.TrimSpace(service.ControlURL))
+					if err != nil {
+						return "", "", fmt.Errorf("invalid control URL %q: %w", service.ControlURL, err)
+					}
+					return serviceType, control.String(), nil
+				}
+			}
+		}
+	}
+}
+
+// findXMLElement returns the text of the first element with the local name.
+func findXMLElement(r io.Reader, name string) (string, error) {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return "", fmt.Errorf("response doesn't contain %s", name)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse response: %w", err)
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == name {
+			var value string
+			if err := decoder.DecodeElement(&value, &start); err != nil {
+				return "", fmt.Errorf("failed to parse response: %w", err)
+			}
+			return value, nil
+		}
+	}
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultGateway returns the gateway of the default IPv4 route.
+func defaultGateway() (netip.Addr, error) {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer file.Close()
+
+	// Each line is the interface, destination, gateway, and flags, followed by
+	// other columns. Addresses are hexadecimal in host byte order.
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[1] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 16)
+		// RTF_GATEWAY
+		if err != nil || flags&0x2 == 0 {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		var gateway [4]byte
+		binary.NativeEndian.PutUint32(gateway[:], binary.BigEndian.Uint32(raw))
+		return netip.AddrFrom4(gateway), nil
+	}
+	return netip.Addr{}, errors.New("there's no default route")
+}
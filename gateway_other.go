@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// defaultGateway returns an error, because the default gateway is only found
+// automatically on Linux.
+func defaultGateway() (netip.Addr, error) {
+	return netip.Addr{}, errors.New("the gateway's address must be set on this platform")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GoDaddyDNS holds the settings for a record in a domain hosted by GoDaddy.
+type GoDaddyDNS struct {
+	// APIKey is the key of a production GoDaddy API key.
+	APIKey string `json:"api_key"`
+	// APISecret is the secret that goes with APIKey.
+	APISecret string `json:"api_secret"`
+	// APISecretFile is the path to a file containing the secret, which is read
+	// when the configuration is loaded if APISecret isn't set.
+	APISecretFile string `json:"api_secret_file,omitempty"`
+	// Domain is the domain the record is in, such as example.com. The record's
+	// name must be the domain or a name under it.
+	Domain string `json:"domain"`
+}
+
+// goDaddyRecord is a record in the body of a request to replace the records
+// with a name and type.
+type goDaddyRecord struct {
+	Data string `json:"data"`
+	TTL  int    `json:"ttl,omitempty"`
+}
+
+// update replaces the records with the record's name and type with a single
+// record for the address. GoDaddy's minimum TTL is 600 seconds, and it uses
+// 3600 if none is set.
+func (g *GoDaddyDNS) update(ctx context.Context, client *http.Client, record *DNSRecord, recordType string, address string) error {
+	name, err := relativeName(record.Name, g.Domain)
+	if err != nil {
+		return err
+	}
+	update := goDaddyRecord{Data: address}
+	if record.TTL > 1 {
+		update.TTL = max(record.TTL, 600)
+	}
+
+	req, err := newJSONRequest(ctx, "PUT", "https://api.godaddy.com/v1/domains/"+url.PathEscape(g.Domain)+
+		"/records/"+recordType+"/"+url.PathEscape(name), []goDaddyRecord{update})
+	if err != nil {
+		return err
+	}
+	req = withPurpose(req, "dns_update")
+	req.Header.Set("Authorization", "sso-key "+g.APIKey+":"+g.APISecret)
+	return doJSON(client, req, nil)
+}
+
+// validate returns a description of each problem with the settings for the
+// record.
+func (g *GoDaddyDNS) validate(record *DNSRecord) []string {
+	var problems []string
+	for _, field := range []struct{ name, value string }{
+		{"api_key", g.APIKey},
+		{"api_secret", g.APISecret},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			problems = append(problems, fmt.Sprintf("godaddy %s is missing", field.name))
+		}
+	}
+	if strings.TrimSpace(g.Domain) == "" {
+		problems = append(problems, "godaddy domain is missing")
+	} else if _, err := relativeName(record.Name, g.Domain); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// googleDNSScope is the OAuth scope needed to change records in Cloud DNS.
+const googleDNSScope = "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+
+// googleMetadataURL is the metadata server that provides credentials for the
+// service account attached to a Compute Engine VM, or the account that a GKE
+// workload identity is bound to.
+const googleMetadataURL = "http://metadata.google.internal/computeMetadata/v1"
+
+// GoogleCloudDNS holds the settings for a record in a Google Cloud DNS managed
+// zone.
+type GoogleCloudDNS struct {
+	// Project is the ID of the project that owns the managed zone. If it's
+	// empty, the project of the service account is used.
+	Project string `json:"project,omitempty"`
+	// ManagedZone is the name of the managed zone, not its DNS name.
+	ManagedZone string `json:"managed_zone"`
+	// CredentialsFile is the path to a service account key in JSON format. If
+	// it's empty, GOOGLE_APPLICATION_CREDENTIALS is used, and if that isn't set
+	// either, credentials are fetched from the metadata server.
+	CredentialsFile string `json:"credentials_file,omitempty"`
+}
+
+// googleServiceAccount is the part of a service account key that's needed to
+// get an access token.
+type googleServiceAccount struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+	PrivateKeyID string `json:"private_key_id"`
+}
+
+// googleToken is an access token and the project it belongs to.
+type googleToken struct {
+	accessToken string
+	project     string
+	expiry      time.Time
+}
+
+// googleTokens caches access tokens by credentials file until shortly before
+// they expire, so that a token isn't requested for every record. The metadata
+// server's token is stored under "".
+var googleTokens = struct {
+	sync.Mutex
+	tokens map[string]googleToken
+}{tokens: map[string]googleToken{}}
+
+func (g *GoogleCloudDNS) credentialsFile() string {
+	if g.CredentialsFile != "" {
+		return g.CredentialsFile
+	}
+	return os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+}
+
+// token returns an access token for Cloud DNS and the project of the account
+// it was issued to.
+func (g *GoogleCloudDNS) token(ctx context.Context, client *http.Client) (googleToken, error) {
+	path := g.credentialsFile()
+
+	googleTokens.Lock()
+	defer googleTokens.Unlock()
+
+	if token, ok := googleTokens.tokens[path]; ok && time.Until(token.expiry) > time.Minute {
+		return token, nil
+	}
+
+	var token googleToken
+	var err error
+	if path != "" {
+		token, err = serviceAccountToken(ctx, client, path)
+	} else {
+		token, err = metadataToken(ctx, client)
+	}
+	if err != nil {
+		return googleToken{}, err
+	}
+	googleTokens.tokens[path] = token
+	return token, nil
+}
+
+// googleTokenResponse is the response from both the OAuth token endpoint and
+// the metadata server's token endpoint.
+type googleTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// serviceAccountToken exchanges a JWT signed with the service account's key
+// for an access token.
+func serviceAccountToken(ctx context.Context, client *http.Client, path string) (googleToken, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return googleToken{}, fmt.Errorf("failed to read Google credentials: %w", err)
+	}
+	var account googleServiceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return googleToken{}, fmt.Errorf("failed to parse Google credentials: %w", err)
+	}
+	if account.Type != "service_account" {
+		return googleToken{}, fmt.Errorf("google credentials of type %q aren't supported, use a service account key", account.Type)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	assertion, err := signGoogleJWT(&account, time.Now())
+	if err != nil {
+		return googleToken{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", account.TokenURI, strings.NewReader(url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}.Encode()))
+	if err != nil {
+		return googleToken{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withPurpose(req, "provider_auth")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var response googleTokenResponse
+	if err := doJSON(client, req, &response); err != nil {
+		return googleToken{}, fmt.Errorf("failed to get Google access token: %w", err)
+	}
+	return googleToken{
+		accessToken: response.AccessToken,
+		project:     account.ProjectID,
+		expiry:      time.Now().Add(time.Duration(response.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// signGoogleJWT creates the assertion that a service account uses to request
+// an access token.
+func signGoogleJWT(account *googleServiceAccount, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return "", errors.New("google credentials have no PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to parse Google private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("google private key isn't an RSA key")
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": account.PrivateKeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iss":   account.ClientEmail,
+		"scope": googleDNSScope,
+		"aud":   account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign Google JWT: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// metadataToken gets an access token for the attached service account from
+// the metadata server.
+func metadataToken(ctx context.Context, client *http.Client) (googleToken, error) {
+	get := func(path string, response any) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", googleMetadataURL+path, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		req = withPurpose(req, "provider_auth")
+		req.Header.Set("Metadata-Flavor", "Google")
+		return doJSON(client, req, response)
+	}
+
+	var response googleTokenResponse
+	if err := get("/instance/service-accounts/default/token?scopes="+url.QueryEscape(googleDNSScope), &response); err != nil {
+		return googleToken{}, fmt.Errorf("failed to get access token from the metadata server: %w", err)
+	}
+	// The project ID is plain text unless alt=json asks for it as a JSON string.
+	var project string
+	if err := get("/project/project-id?alt=json", &project); err != nil {
+		return googleToken{}, fmt.Errorf("failed to get project ID from the metadata server: %w", err)
+	}
+	return googleToken{
+		accessToken: response.AccessToken,
+		project:     project,
+		expiry:      time.Now().Add(time.Duration(response.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// googleRRSet is a resource record set in Cloud DNS.
+type googleRRSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl"`
+	RRDatas []string `json:"rrdatas"`
+}
+
+// update replaces the record set with the given name and type, or creates it if
+// it doesn't exist yet.
+func (g *GoogleCloudDNS) update(ctx context.Context, client *http.Client, record *DNSRecord, recordType string, address string) error {
+	token, err := g.token(ctx, client)
+	if err != nil {
+		return err
+	}
+	project := g.Project
+	if project == "" {
+		project = token.project
+	}
+	if project == "" {
+		return errors.New("google project isn't set and couldn't be found from the credentials")
+	}
+
+	// Cloud DNS names are absolute, and it has no automatic TTL.
+	name := strings.TrimSuffix(record.Name, ".") + "."
+	ttl := record.TTL
+	if ttl <= 1 {
+		ttl = 300
+	}
+	rrset := googleRRSet{Name: name, Type: recordType, TTL: ttl, RRDatas: []string{address}}
+
+	base := "https://dns.googleapis.com/dns/v1/projects/" + url.PathEscape(project) +
+		"/managedZones/" + url.PathEscape(g.ManagedZone) + "/rrsets"
+	send := func(method string, url string) error {
+		req, err := newJSONRequest(ctx, method, url, rrset)
+		if err != nil {
+			return err
+		}
+		req = withPurpose(req, "dns_update")
+		req.Header.Set("Authorization", "Bearer "+token.accessToken)
+		return doJSON(client, req, nil)
+	}
+
+	err = send("PATCH", base+"/"+url.PathEscape(name)+"/"+recordType)
+	var apiErr *providerError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		err = send("POST", base)
+	}
+	return err
+}
+
+// validate returns a description of each problem with the settings.
+func (g *GoogleCloudDNS) validate() []string {
+	var problems []string
+	if strings.TrimSpace(g.ManagedZone) == "" {
+		problems = append(problems, "google managed_zone is missing")
+	}
+	if path := g.credentialsFile(); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			problems = append(problems, fmt.Sprintf("google credentials can't be read: %v", err))
+		}
+	}
+	return problems
+}
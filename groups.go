@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// selectedGroups returns the groups of records to sync, from DDNS_GROUPS or the
+// -group flag. If it's empty, every record is synced.
+func selectedGroups() []string {
+	var groups []string
+	for _, group := range strings.Split(os.Getenv("DDNS_GROUPS"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// selectGroups removes every record that isn't in at least one of the groups.
+func (c *DNSConfiguration) selectGroups(groups []string) {
+	notSelected := func(record DNSRecord) bool {
+		return !slices.ContainsFunc(record.Groups, func(group string) bool {
+			return slices.Contains(groups, group)
+		})
+	}
+	c.A = slices.DeleteFunc(c.A, notSelected)
+	c.AAAA = slices.DeleteFunc(c.AAAA, notSelected)
+}
+
+// parseSchedules parses the cron expression of every group's schedule.
+func (c *DNSConfiguration) parseSchedules() error {
+	c.schedules = map[string]*cronSchedule{}
+	for _, group := range slices.Sorted(maps.Keys(c.Schedules)) {
+		schedule, err := parseCron(c.Schedules[group])
+		if err != nil {
+			return fmt.Errorf("invalid schedule for the group %s: %w", group, err)
+		}
+		c.schedules[group] = schedule
+	}
+	return nil
+}
+
+// scheduledRecords returns a copy of the configuration with only the records
+// that are synced on the group's schedule. If group is empty, it has the
+// records that aren't in any group with a schedule of its own, which are
+// synced on the daemon's interval instead.
+func (c *DNSConfiguration) scheduledRecords(group string) *DNSConfiguration {
+	excluded := func(record DNSRecord) bool {
+		if group != "" {
+			return !slices.Contains(record.Groups, group)
+		}
+		return slices.ContainsFunc(record.Groups, func(group string) bool {
+			return c.schedules[group] != nil
+		})
+	}
+	scheduled := *c
+	scheduled.A = slices.DeleteFunc(slices.Clone(c.A), excluded)
+	scheduled.AAAA = slices.DeleteFunc(slices.Clone(c.AAAA), excluded)
+	return &scheduled
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// healthSyncTimeout is how long a sync can run before the daemon is reported
+// as unhealthy, because it's probably stuck.
+const healthSyncTimeout = 10 * time.Minute
+
+// daemonHealth is the response of the health endpoints.
+type daemonHealth struct {
+	// Status is "ok", or "unavailable" when the endpoint's check fails.
+	Status      string     `json:"status"`
+	Syncing     bool       `json:"syncing"`
+	LastSync    *time.Time `json:"last_sync,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	// Records has the result of the most recent sync of every record, which
+	// might be from different syncs when groups have their own schedules.
+	Records []RecordStatus `json:"records"`
+}
+
+// health returns the daemon's health, and whether it's alive and ready. It's
+// alive unless a sync has been running for longer than healthSyncTimeout, and
+// ready once a sync has finished and the most recent one succeeded for every
+// record.
+func (d *daemon) health() (health daemonHealth, alive bool, ready bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	health.Syncing = d.syncing
+	if !d.lastSync.IsZero() {
+		health.LastSync = &d.lastSync
+	}
+	if !d.lastSuccess.IsZero() {
+		health.LastSuccess = &d.lastSuccess
+	}
+	if d.lastError != nil {
+		health.LastError = d.lastError.Error()
+	}
+	health.Records = []RecordStatus{}
+	for _, record := range d.records {
+		health.Records = append(health.Records, record)
+	}
+	slices.SortFunc(health.Records, func(a, b RecordStatus) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.Type, b.Type), cmp.Compare(a.RecordID, b.RecordID))
+	})
+
+	alive = !d.syncing || time.Since(d.syncStarted) <= healthSyncTimeout
+	ready = !d.lastSync.IsZero() && d.lastSuccess.Equal(d.lastSync)
+	return health, alive, ready
+}
+
+// healthAccess is who can reach the health endpoints, and who can see the
+// details of the daemon's health, which include the names and addresses of
+// the records and the errors of failed syncs.
+type healthAccess struct {
+	// token is the bearer token, or the password for basic auth with any
+	// username, that requests need to see the details. If it's empty, only
+	// requests from the same machine can.
+	token string
+	// allowed are the networks that requests can come from, or nil to allow
+	// requests from anywhere.
+	allowed []netip.Prefix
+}
+
+// parseHealthAllow parses a comma-separated list of networks and addresses,
+// like "192.168.1.0/24, 10.0.0.5".
+func parseHealthAllow(value string) ([]netip.Prefix, error) {
+	var allowed []netip.Prefix
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			address, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("%q isn't a network like 192.168.1.0/24 or an address", entry)
+			}
+			prefix = netip.PrefixFrom(address, address.BitLen())
+		}
+		allowed = append(allowed, prefix.Masked())
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("there are no networks in %q", value)
+	}
+	return allowed, nil
+}
+
+// remoteAddress returns the address that the request came from.
+func remoteAddress(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	address, err := netip.ParseAddr(host)
+	return address.Unmap(), err == nil
+}
+
+// allows returns whether the request can reach the endpoints at all.
+func (a healthAccess) allows(r *http.Request) bool {
+	if a.allowed == nil {
+		return true
+	}
+	address, ok := remoteAddress(r)
+	return ok && slices.ContainsFunc(a.allowed, func(prefix netip.Prefix) bool {
+		return prefix.Contains(address)
+	})
+}
+
+// detailed returns whether the request can see the details.
+func (a healthAccess) detailed(r *http.Request) bool {
+	if a.token != "" {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			_, given, ok = r.BasicAuth()
+		}
+		// The comparison takes the same time however much of the token is
+		// right, so it can't be guessed a character at a time.
+		return ok && subtle.ConstantTimeCompare([]byte(given), []byte(a.token)) == 1
+	}
+
+	address, ok := remoteAddress(r)
+	return ok && address.IsLoopback()
+}
+
+// healthHandler responds with the daemon's health, and a 503 status if check
+// reports that it's unavailable. Requests that can't see the details only get
+// the status.
+func (d *daemon) healthHandler(access healthAccess, check func(alive, ready bool) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health, alive, ready := d.health()
+		health.Status = "ok"
+		status := http.StatusOK
+		if !check(alive, ready) {
+			health.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		if !access.detailed(r) {
+			json.NewEncoder(w).Encode(map[string]string{"status": health.Status})
+			return
+		}
+		json.NewEncoder(w).Encode(health)
+	}
+}
+
+// serveHealth serves /healthz and /readyz on the address until the context is
+// done, for container orchestrators and uptime monitors. The details are only
+// shown to requests with DDNS_HEALTH_TOKEN, if it's set, and requests from
+// outside the networks in DDNS_HEALTH_ALLOW, if it's set, are refused.
+func (d *daemon) serveHealth(ctx context.Context, address string) error {
+	access := healthAccess{token: os.Getenv("DDNS_HEALTH_TOKEN")}
+	if value := os.Getenv("DDNS_HEALTH_ALLOW"); value != "" {
+		allowed, err := parseHealthAllow(value)
+		if err != nil {
+			return fmt.Errorf("invalid DDNS_HEALTH_ALLOW: %w", err)
+		}
+		access.allowed = allowed
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen for health checks: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", d.healthHandler(access, func(alive, _ bool) bool { return alive }))
+	mux.Handle("/readyz", d.healthHandler(access, func(alive, ready bool) bool { return alive && ready }))
+	logger := d.logger.With("component", "health")
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !access.allows(r) {
+				logger.Debug("Refused health check from outside DDNS_HEALTH_ALLOW", "remote_address", r.RemoteAddr)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			mux.ServeHTTP(w, r)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	logger.Info("Listening for health checks", "address", listener.Addr().String())
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Failed to serve health checks", "error", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// InterfaceSource reads the address from a local network interface, for
+// machines that have their public address assigned directly.
+type InterfaceSource struct {
+	// Name is the name of the interface, such as eth0.
+	Name string `json:"name"`
+	// AllowPrivate allows private addresses, like 192.168.0.0/16 and unique
+	// local IPv6 addresses, to be used. By default only global addresses are.
+	AllowPrivate bool `json:"allow_private,omitempty"`
+}
+
+// Flags of IPv6 addresses that are reported by the kernel.
+const (
+	ipv6FlagTemporary  = 0x01
+	ipv6FlagDADFailed  = 0x08
+	ipv6FlagDeprecated = 0x20
+	ipv6FlagTentative  = 0x40
+)
+
+// cgnatPrefix is the shared address space used by carrier-grade NAT, which is
+// never a public address.
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// currentIP returns the interface's first usable address for the record type.
+// Link-local, deprecated, and tentative addresses are never used, and stable
+// IPv6 addresses are preferred over temporary privacy addresses, which change
+// every day or so.
+func (s *InterfaceSource) currentIP(recordType string) (string, error) {
+	iface, err := net.InterfaceByName(s.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to find interface: %w", err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to read addresses of %s: %w", s.Name, err)
+	}
+	flags := ipv6AddressFlags(s.Name)
+
+	var temporary netip.Addr
+	var skipped []string
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err != nil {
+			continue
+		}
+		ip := prefix.Addr().Unmap()
+		if recordType == "A" != ip.Is4() {
+			continue
+		}
+
+		switch {
+		case !ip.IsGlobalUnicast():
+		case !s.AllowPrivate && (ip.IsPrivate() || cgnatPrefix.Contains(ip)):
+		case flags[ip]&(ipv6FlagDADFailed|ipv6FlagDeprecated|ipv6FlagTentative) != 0:
+		case flags[ip]&ipv6FlagTemporary != 0:
+			if !temporary.IsValid() {
+				temporary = ip
+			}
+		default:
+			return ip.String(), nil
+		}
+		skipped = append(skipped, ip.String())
+	}
+
+	if temporary.IsValid() {
+		return temporary.String(), nil
+	}
+	if len(skipped) > 0 {
+		return "", fmt.Errorf("%s has no usable %s address, only %s", s.Name, recordType, strings.Join(skipped, ", "))
+	}
+	return "", fmt.Errorf("%s has no %s address", s.Name, recordType)
+}
@@ -0,0 +1,47 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ipv6AddressFlags returns the flags of each IPv6 address on the interface,
+// which aren't available from the net package.
+func ipv6AddressFlags(name string) map[netip.Addr]uint64 {
+	file, err := os.Open("/proc/net/if_inet6")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	// Each line is the address, interface index, prefix length, scope, flags,
+	// and interface name.
+	flags := map[netip.Addr]uint64{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 6 || fields[5] != name {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[0])
+		if err != nil {
+			continue
+		}
+		address, ok := netip.AddrFromSlice(raw)
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			continue
+		}
+		flags[address] = value
+	}
+	return flags
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "net/netip"
+
+// ipv6AddressFlags returns nil, because the flags of addresses are only read
+// on Linux. Temporary addresses can't be told apart from stable ones.
+func ipv6AddressFlags(name string) map[netip.Addr]uint64 {
+	return nil
+}
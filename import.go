@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// importedHosts are hostnames in one Cloudflare zone that another DDNS client
+// was configured to update.
+type importedHosts struct {
+	zone     string
+	token    string
+	hosts    []string
+	ttl      int
+	proxied  *bool
+	withIPv6 bool
+}
+
+// parseDDClientConfig reads the Cloudflare entries from a ddclient.conf. Each
+// statement is a list of key=value options followed by the hosts they apply
+// to, and options in a statement without any hosts are inherited by the
+// statements after it.
+func parseDDClientConfig(logger *slog.Logger, data []byte) ([]importedHosts, error) {
+	var statements []string
+	var current strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		continued := strings.HasSuffix(line, `\`)
+		current.WriteString(strings.TrimSuffix(line, `\`))
+		current.WriteString(" ")
+		if !continued {
+			statements = append(statements, current.String())
+			current.Reset()
+		}
+	}
+	statements = append(statements, current.String())
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	globals := map[string]string{}
+	var imported []importedHosts
+	for _, statement := range statements {
+		options := map[string]string{}
+		var hosts []string
+		for _, word := range strings.FieldsFunc(statement, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			if key, value, ok := strings.Cut(word, "="); ok {
+				options[strings.ReplaceAll(strings.ToLower(key), "_", "-")] = strings.Trim(value, `'"`)
+			} else {
+				hosts = append(hosts, word)
+			}
+		}
+
+		if len(hosts) == 0 {
+			for key, value := range options {
+				globals[key] = value
+			}
+			continue
+		}
+
+		option := func(key string) string {
+			if value, ok := options[key]; ok {
+				return value
+			}
+			return globals[key]
+		}
+
+		if protocol := option("protocol"); protocol != "cloudflare" {
+			logger.Warn("Skipping hosts that don't use Cloudflare", "protocol", protocol, "hosts", hosts)
+			continue
+		}
+		if login := option("login"); login != "token" {
+			logger.Warn("Skipping hosts that use a global API key instead of an API token", "hosts", hosts)
+			continue
+		}
+
+		entry := importedHosts{
+			zone:     option("zone"),
+			token:    option("password"),
+			hosts:    hosts,
+			withIPv6: option("usev6") != "" || option("ipv6") == "yes",
+		}
+		entry.ttl, _ = strconv.Atoi(option("ttl"))
+		if proxied := option("proxied"); proxied != "" {
+			value := proxied == "yes" || proxied == "true" || proxied == "1"
+			entry.proxied = &value
+		}
+		imported = append(imported, entry)
+	}
+	return imported, nil
+}
+
+// parseInadynConfig reads the Cloudflare providers from an inadyn.conf. Each
+// provider is a block of key = value settings, where the username is the zone
+// and the password is the API token.
+func parseInadynConfig(logger *slog.Logger, data []byte) ([]importedHosts, error) {
+	var imported []importedHosts
+	withIPv6 := false
+
+	var entry *importedHosts
+	skip := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "":
+		case strings.HasSuffix(line, "{") && entry == nil:
+			fields := strings.Fields(strings.TrimSuffix(line, "{"))
+			if len(fields) != 2 || (fields[0] != "provider" && fields[0] != "custom") {
+				return nil, fmt.Errorf("line %d: unexpected block %q", lineNumber, line)
+			}
+			// Providers are written like "default@cloudflare.com:1", where the
+			// prefix and suffix only make the name unique.
+			name := fields[1]
+			if _, after, ok := strings.Cut(name, "@"); ok {
+				name = after
+			}
+			name, _, _ = strings.Cut(name, ":")
+			entry = &importedHosts{withIPv6: withIPv6}
+			skip = name != "cloudflare.com"
+			if skip {
+				logger.Warn("Skipping provider that isn't Cloudflare", "line", lineNumber, "provider", fields[1])
+			}
+		case line == "}" && entry != nil:
+			if !skip {
+				imported = append(imported, *entry)
+			}
+			entry = nil
+		default:
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key = value", lineNumber)
+			}
+			key = strings.TrimSpace(key)
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+
+			if entry == nil {
+				if key == "allow-ipv6" {
+					withIPv6 = value == "true"
+				}
+				continue
+			}
+			switch key {
+			case "username":
+				entry.zone = value
+			case "password":
+				entry.token = value
+			case "hostname":
+				value = strings.Trim(value, "{} ")
+				for _, host := range strings.Split(value, ",") {
+					if host = strings.Trim(strings.TrimSpace(host), `"`); host != "" {
+						entry.hosts = append(entry.hosts, host)
+					}
+				}
+			case "ttl":
+				entry.ttl, _ = strconv.Atoi(value)
+			case "proxied":
+				proxied := value == "true"
+				entry.proxied = &proxied
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		return nil, fmt.Errorf("unterminated provider block")
+	}
+	return imported, nil
+}
+
+// importedConfig is the configuration written by the import command.
+type importedConfig struct {
+	A    []importedRecord `json:"a,omitempty"`
+	AAAA []importedRecord `json:"aaaa,omitempty"`
+}
+
+type importedRecord struct {
+	Name     string `json:"name"`
+	APIToken string `json:"api_token"`
+	ZoneID   string `json:"zone_id"`
+	RecordID string `json:"record_id"`
+	TTL      int    `json:"ttl,omitempty"`
+	Proxied  *bool  `json:"proxied,omitempty"`
+}
+
+// buildImportedConfig looks up the zone and record IDs for the imported hosts.
+// If client is nil, or a lookup fails, placeholders are written instead, so the
+// configuration can be filled in by hand.
+func buildImportedConfig(logger *slog.Logger, client *http.Client, imported []importedHosts) importedConfig {
+	var config importedConfig
+	for _, entry := range imported {
+		lookupClient := client
+		zoneID := "ZONE_ID_FOR_" + entry.zone
+		if lookupClient != nil {
+			if id, err := findZoneID(context.Background(), lookupClient, "import", entry.token, entry.zone); err != nil {
+				logger.Warn("Failed to look up zone ID", "zone", entry.zone, "error", err)
+				lookupClient = nil
+			} else {
+				zoneID = id
+			}
+		}
+
+		for _, host := range entry.hosts {
+			record := importedRecord{
+				Name:     host,
+				APIToken: entry.token,
+				ZoneID:   zoneID,
+				TTL:      entry.ttl,
+				Proxied:  entry.proxied,
+			}
+			placeholder := func(recordType string) importedRecord {
+				record := record
+				record.RecordID = recordType + "_RECORD_ID_FOR_" + host
+				return record
+			}
+
+			var records []CloudflareDNSRecord
+			var err error
+			if lookupClient != nil {
+				records, err = findDNSRecords(context.Background(), lookupClient, "import", entry.token, zoneID, host)
+				if err != nil {
+					logger.Warn("Failed to look up record IDs", "host", host, "error", err)
+				}
+			}
+			if lookupClient == nil || err != nil {
+				config.A = append(config.A, placeholder("A"))
+				if entry.withIPv6 {
+					config.AAAA = append(config.AAAA, placeholder("AAAA"))
+				}
+				continue
+			}
+
+			found := false
+			for _, existing := range records {
+				record.RecordID = existing.ID
+				switch existing.Type {
+				case "A":
+					config.A = append(config.A, record)
+				case "AAAA":
+					config.AAAA = append(config.AAAA, record)
+				default:
+					continue
+				}
+				found = true
+			}
+			if !found {
+				logger.Warn("Host has no A or AAAA record in Cloudflare", "host", host)
+			}
+		}
+	}
+	return config
+}
+
+// importCommand handles "clouddns import", which converts a ddclient or inadyn
+// configuration file into a clouddns configuration and prints it.
+func importCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", `format of the file, "ddclient" or "inadyn" (default: detected from the file name)`)
+	offline := flags.Bool("offline", false, "don't look up zone and record IDs with the Cloudflare API")
+	output := flags.String("output", "", "path to write the configuration to (default: standard output)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: clouddns import [flags] <ddclient.conf or inadyn.conf>")
+	}
+	path := flags.Arg(0)
+
+	if *format == "" {
+		switch {
+		case strings.Contains(path, "ddclient"):
+			*format = "ddclient"
+		case strings.Contains(path, "inadyn"):
+			*format = "inadyn"
+		default:
+			return fmt.Errorf("can't tell the format of %s, use -format", path)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var imported []importedHosts
+	switch *format {
+	case "ddclient":
+		imported, err = parseDDClientConfig(logger, data)
+	case "inadyn":
+		imported, err = parseInadynConfig(logger, data)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(imported) == 0 {
+		return fmt.Errorf("no Cloudflare hosts found in %s", path)
+	}
+
+	var client *http.Client
+	if !*offline {
+		client, err = newHTTPClient(nil, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create HTTP client: %w", err)
+		}
+	}
+
+	data, err = json.MarshalIndent(buildImportedConfig(logger, client, imported), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal configuration: %w", err)
+	}
+	data = append(data, '\n')
+
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	// The file contains the tokens, so only the owner can read it.
+	if err := os.WriteFile(*output, data, 0600); err != nil {
+		return fmt.Errorf("failed to write configuration: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// initConfig is the starter configuration written by the init command. Every
+// record shares one token and zone, so they're written once as defaults.
+type initConfig struct {
+	Defaults RecordDefaults `json:"defaults"`
+	A        []initRecord   `json:"a,omitempty"`
+	AAAA     []initRecord   `json:"aaaa,omitempty"`
+}
+
+type initRecord struct {
+	Name     string `json:"name"`
+	RecordID string `json:"record_id"`
+}
+
+// prompter asks questions on standard output and reads the answers from
+// standard input.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask asks the question until it gets a non-empty answer.
+func (p *prompter) ask(question string) (string, error) {
+	for {
+		fmt.Fprint(p.out, question+": ")
+		line, err := p.in.ReadString('\n')
+		if answer := strings.TrimSpace(line); answer != "" {
+			return answer, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read answer: %w", err)
+		}
+	}
+}
+
+// qualifyHostname turns a hostname as it was typed into a fully qualified name
+// in the zone. "@" is the zone itself, and names outside the zone are treated
+// as relative to it.
+func qualifyHostname(hostname string, zone string) string {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	if hostname == "@" || hostname == zone {
+		return zone
+	}
+	if strings.HasSuffix(hostname, "."+zone) {
+		return hostname
+	}
+	return hostname + "." + zone
+}
+
+// initCommand handles "clouddns init", which asks for a token, a zone, and the
+// hostnames to keep updated, looks up the zone and record IDs with the
+// Cloudflare API, and writes a configuration file for them.
+func initCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	output := flags.String("output", os.Getenv("DDNS_CONFIG_PATH"), "path to write the configuration to (default $DDNS_CONFIG_PATH, or clouddns.json)")
+	force := flags.Bool("force", false, "overwrite the file if it already exists")
+	flags.Parse(args)
+
+	if *output == "" {
+		*output = "clouddns.json"
+	}
+	if _, err := os.Stat(*output); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use -force to overwrite it", *output)
+	}
+
+	client, err := newHTTPClient(nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP client: %w", err)
+	}
+
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	fmt.Fprintln(p.out, "This creates a configuration for records in one Cloudflare zone.")
+	fmt.Fprintln(p.out, "The records need to exist already, and the token needs the Zone → DNS → Edit permission.")
+	fmt.Fprintln(p.out)
+
+	token, err := p.ask("Cloudflare API token")
+	if err != nil {
+		return err
+	}
+	if _, err := verifyToken(context.Background(), client, token); err != nil {
+		return fmt.Errorf("failed to verify token: %w", err)
+	}
+
+	zone, err := p.ask("Zone (e.g. example.com)")
+	if err != nil {
+		return err
+	}
+	zone = strings.TrimSuffix(strings.ToLower(zone), ".")
+	zoneID, err := findZoneID(context.Background(), client, "init", token, zone)
+	if err != nil {
+		return err
+	}
+
+	answer, err := p.ask("Hostnames to update, separated by commas (e.g. @, home)")
+	if err != nil {
+		return err
+	}
+
+	config := initConfig{Defaults: RecordDefaults{APIToken: token, ZoneID: zoneID}}
+	for _, hostname := range strings.Split(answer, ",") {
+		hostname = strings.TrimSpace(hostname)
+		if hostname == "" {
+			continue
+		}
+		name := qualifyHostname(hostname, zone)
+
+		records, err := findDNSRecords(context.Background(), client, "init", token, zoneID, name)
+		if err != nil {
+			return err
+		}
+
+		found := false
+		for _, record := range records {
+			switch record.Type {
+			case "A":
+				config.A = append(config.A, initRecord{Name: name, RecordID: record.ID})
+			case "AAAA":
+				config.AAAA = append(config.AAAA, initRecord{Name: name, RecordID: record.ID})
+			default:
+				continue
+			}
+			found = true
+			fmt.Fprintf(p.out, "Found %s record for %s (currently %s)\n", record.Type, name, record.Content)
+		}
+		if !found {
+			fmt.Fprintf(p.out, "No A or AAAA record exists for %s, so it was skipped. Create it in the Cloudflare dashboard and run init again.\n", name)
+		}
+	}
+
+	if len(config.A) == 0 && len(config.AAAA) == 0 {
+		return fmt.Errorf("none of the hostnames have A or AAAA records")
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal configuration: %w", err)
+	}
+	// The file contains the token, so only the owner can read it.
+	if err := os.WriteFile(*output, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write configuration: %w", err)
+	}
+
+	fmt.Fprintf(p.out, "\nWrote %s with %d A and %d AAAA records. Run clouddns with DDNS_CONFIG_PATH=%s to start updating them.\n",
+		*output, len(config.A), len(config.AAAA), *output)
+	return nil
+}
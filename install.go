@@ -0,0 +1,488 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// installEnvironment returns the clouddns environment variables from the current
+// process, so that an installed service runs with the same configuration as the
+// shell it was installed from. Paths are made absolute, because services don't
+// run in the same working directory.
+func installEnvironment() ([]string, error) {
+	switch os.Getenv("DDNS_CONFIG_PATH") {
+	case "":
+		return nil, fmt.Errorf("DDNS_CONFIG_PATH or -config must be set when installing a service")
+	case "-":
+		return nil, fmt.Errorf("services can't read their configuration from stdin")
+	}
+
+	var environment []string
+	for _, variable := range os.Environ() {
+		key, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(key, "DDNS_") {
+			continue
+		}
+		if (key == "DDNS_CONFIG_PATH" || key == "DDNS_CACHE_PATH") && value != "" && !isConfigURL(value) {
+			abs, err := filepath.Abs(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve %s: %w", key, err)
+			}
+			value = abs
+		}
+		environment = append(environment, key+"="+value)
+	}
+	return environment, nil
+}
+
+// isSecretVariable reports whether the environment variable holds a secret, like
+// DDNS_CONFIG_TOKEN, which mustn't be written to a file every user can read.
+func isSecretVariable(key string) bool {
+	for _, suffix := range []string{"_TOKEN", "_PASSWORD", "_SECRET"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSecrets separates the variables that hold secrets from the rest.
+func splitSecrets(environment []string) (public []string, secrets []string) {
+	for _, variable := range environment {
+		key, _, _ := strings.Cut(variable, "=")
+		if isSecretVariable(key) {
+			secrets = append(secrets, variable)
+		} else {
+			public = append(public, variable)
+		}
+	}
+	return public, secrets
+}
+
+// systemdEnvironmentFile is where the systemd service's secret variables are
+// written, readable only by root. systemd reads it before starting the service
+// as its dynamic user.
+const systemdEnvironmentFile = "/etc/clouddns/clouddns.env"
+
+// installFile is a file written by the install command.
+type installFile struct {
+	path     string
+	mode     os.FileMode
+	template *template.Template
+}
+
+// installData is the data that install templates are executed with.
+type installData struct {
+	Executable  string
+	Environment []string
+	// Secrets are the variables that hold secrets, which the systemd service
+	// reads from systemdEnvironmentFile.
+	Secrets  []string
+	Interval time.Duration
+	// Daemon is whether clouddns keeps running instead of being started on
+	// the interval.
+	Daemon bool
+	// Credentials are the files that systemd passes to the service, which it
+	// couldn't read itself as a dynamic user.
+	Credentials []installCredential
+	// ConfigCredential is the name of the credential with the configuration
+	// file, if it's passed as one.
+	ConfigCredential string
+}
+
+// EnvironmentFile is the path of the file with the secret variables, for the
+// systemd service template.
+func (installData) EnvironmentFile() string {
+	return systemdEnvironmentFile
+}
+
+// installCredential is a file loaded with LoadCredential=, which the service
+// reads from /run/credentials/clouddns.service/<Name>.
+type installCredential struct {
+	Name string
+	Path string
+}
+
+// systemdEnvironment adjusts the environment for the sandbox of the systemd
+// service. The configuration file is passed as a credential, and the cache is
+// kept in the directory that systemd creates for the service, because a
+// dynamic user can't write anywhere else. Both are set by the template, so
+// they're left out of the environment that's returned.
+func systemdEnvironment(logger *slog.Logger, environment []string) ([]string, *installCredential, error) {
+	var adjusted []string
+	var config *installCredential
+	for _, variable := range environment {
+		key, value, _ := strings.Cut(variable, "=")
+		switch key {
+		case "DDNS_CONFIG_PATH":
+			if isConfigURL(value) {
+				break
+			}
+			info, err := os.Stat(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read DDNS_CONFIG_PATH: %w", err)
+			}
+			if info.IsDir() {
+				logger.Warn("The configuration is a directory, so it must be readable by every user for the service to read it", "path", value)
+				break
+			}
+			// The extension is kept so that the format is still detected.
+			config = &installCredential{Name: "config" + strings.ToLower(filepath.Ext(value)), Path: value}
+			continue
+		case "DDNS_CACHE_PATH":
+			if value != "/var/cache/clouddns" {
+				logger.Info("The systemd service uses /var/cache/clouddns as its cache directory", "path", value)
+			}
+			continue
+		}
+		adjusted = append(adjusted, key+"="+value)
+	}
+	return adjusted, config, nil
+}
+
+var installFuncs = template.FuncMap{
+	// systemdQuote quotes a value for an Environment= or ExecStart= line.
+	// envFileQuote quotes a value for a line of an EnvironmentFile=, which
+	// doesn't expand specifiers like % the way unit files do.
+	"envFileQuote": func(s string) string {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+		s = strings.ReplaceAll(s, `"`, `\"`)
+		return `"` + s + `"`
+	},
+	"systemdQuote": func(s string) string {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+		s = strings.ReplaceAll(s, `"`, `\"`)
+		s = strings.ReplaceAll(s, `%`, `%%`)
+		return `"` + s + `"`
+	},
+	"shellQuote": func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	},
+	"xml": func(s string) (string, error) {
+		var sb strings.Builder
+		err := xml.EscapeText(&sb, []byte(s))
+		return sb.String(), err
+	},
+	"envKey": func(s string) string {
+		key, _, _ := strings.Cut(s, "=")
+		return key
+	},
+	"envValue": func(s string) string {
+		_, value, _ := strings.Cut(s, "=")
+		return value
+	},
+	"seconds": func(d time.Duration) int64 {
+		return int64(d.Seconds())
+	},
+}
+
+// The service runs as a dynamic user in a sandbox that only allows what
+// clouddns needs: making network requests, writing to its cache directory,
+// and, as a daemon, listening for address changes and on its sockets in
+// /run/clouddns.
+var systemdServiceTemplate = template.Must(template.New("clouddns.service").Funcs(installFuncs).Parse(`[Unit]
+Description=Cloudflare DDNS Client
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+{{- if .Daemon}}
+Type=notify
+ExecStart={{systemdQuote .Executable}} -daemon -interval {{.Interval}}
+Restart=on-failure
+RestartSec=30s
+WatchdogSec=5min
+RuntimeDirectory=clouddns
+{{- else}}
+Type=oneshot
+ExecStart={{systemdQuote .Executable}}
+{{- end}}
+{{- range .Environment}}
+Environment={{systemdQuote .}}
+{{- end}}
+{{- if .Secrets}}
+EnvironmentFile={{.EnvironmentFile}}
+{{- end}}
+{{- if .ConfigCredential}}
+Environment=DDNS_CONFIG_PATH=%d/{{.ConfigCredential}}
+{{- end}}
+Environment=DDNS_CACHE_PATH=%C/clouddns
+{{- range .Credentials}}
+LoadCredential={{.Name}}:{{.Path}}
+{{- end}}
+
+DynamicUser=yes
+CacheDirectory=clouddns
+UMask=0077
+CapabilityBoundingSet=
+NoNewPrivileges=yes
+ProtectSystem=strict
+ProtectHome=yes
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectClock=yes
+ProtectHostname=yes
+ProtectKernelLogs=yes
+ProtectKernelModules=yes
+ProtectKernelTunables=yes
+ProtectControlGroups=yes
+ProtectProc=invisible
+ProcSubset=pid
+RestrictAddressFamilies=AF_INET AF_INET6 AF_UNIX AF_NETLINK
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+SystemCallArchitectures=native
+SystemCallFilter=@system-service
+SystemCallFilter=~@privileged
+{{- if .Daemon}}
+
+[Install]
+WantedBy=multi-user.target
+{{- end}}
+`))
+
+var systemdEnvironmentTemplate = template.Must(template.New("clouddns.env").Funcs(installFuncs).Parse(`{{range .Secrets -}}
+{{envKey .}}={{envFileQuote (envValue .)}}
+{{end}}`))
+
+var systemdTimerTemplate = template.Must(template.New("clouddns.timer").Funcs(installFuncs).Parse(`[Unit]
+Description=Run clouddns every {{.Interval}}
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec={{seconds .Interval}}s
+AccuracySec=1s
+
+[Install]
+WantedBy=timers.target
+`))
+
+var launchdTemplate = template.Must(template.New("clouddns.plist").Funcs(installFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>com.github.clo4.clouddns</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{xml .Executable}}</string>
+	</array>
+	<key>EnvironmentVariables</key>
+	<dict>
+{{- range .Environment}}
+		<key>{{xml (envKey .)}}</key>
+		<string>{{xml (envValue .)}}</string>
+{{- end}}
+	</dict>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>StartInterval</key>
+	<integer>{{seconds .Interval}}</integer>
+	<key>StandardErrorPath</key>
+	<string>/var/log/clouddns.log</string>
+</dict>
+</plist>
+`))
+
+// OpenRC doesn't have timers, so the OpenRC variant is a script for the periodic
+// directories that crond runs on OpenRC distributions such as Alpine. It's only
+// readable by root, which crond runs it as, because it can hold secrets.
+var openrcPeriodicTemplate = template.Must(template.New("clouddns").Funcs(installFuncs).Parse(`#!/bin/sh
+{{- range .Environment}}
+export {{envKey .}}={{shellQuote (envValue .)}}
+{{- end}}
+{{shellQuote .Executable}} 2>&1 | logger -t clouddns
+`))
+
+// openrcPeriods maps the supported intervals to their periodic directory.
+var openrcPeriods = map[time.Duration]string{
+	15 * time.Minute: "15min",
+	time.Hour:        "hourly",
+	24 * time.Hour:   "daily",
+}
+
+func installFiles(system string, interval time.Duration, daemon bool) ([]installFile, string, error) {
+	if daemon && system != "systemd" {
+		return nil, "", fmt.Errorf("-daemon is only supported with -systemd")
+	}
+
+	switch system {
+	case "systemd":
+		if daemon {
+			return []installFile{
+					{path: "/etc/systemd/system/clouddns.service", mode: 0644, template: systemdServiceTemplate},
+				},
+				"systemctl daemon-reload && systemctl enable --now clouddns.service",
+				nil
+		}
+		return []installFile{
+				{path: "/etc/systemd/system/clouddns.service", mode: 0644, template: systemdServiceTemplate},
+				{path: "/etc/systemd/system/clouddns.timer", mode: 0644, template: systemdTimerTemplate},
+			},
+			"systemctl daemon-reload && systemctl enable --now clouddns.timer",
+			nil
+	case "launchd":
+		return []installFile{
+				{path: "/Library/LaunchDaemons/com.github.clo4.clouddns.plist", mode: 0644, template: launchdTemplate},
+			},
+			"launchctl load -w /Library/LaunchDaemons/com.github.clo4.clouddns.plist",
+			nil
+	case "openrc":
+		period, ok := openrcPeriods[interval]
+		if !ok {
+			return nil, "", fmt.Errorf("the OpenRC variant only supports intervals of 15m, 1h, or 24h")
+		}
+		return []installFile{
+				{path: "/etc/periodic/" + period + "/clouddns", mode: 0700, template: openrcPeriodicTemplate},
+			},
+			"rc-update add crond && rc-service crond start",
+			nil
+	default:
+		return nil, "", fmt.Errorf("unknown service manager %q", system)
+	}
+}
+
+// installCommand handles "clouddns install", which writes the files needed to
+// run clouddns on a schedule using the system's service manager.
+func installCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("install", flag.ExitOnError)
+	systemd := flags.Bool("systemd", false, "install a systemd service and timer")
+	launchd := flags.Bool("launchd", false, "install a launchd daemon")
+	openrc := flags.Bool("openrc", false, "install a periodic script for crond on OpenRC systems")
+	interval := flags.Duration("interval", 15*time.Minute, "time between runs")
+	daemon := flags.Bool("daemon", false, "install a service that keeps running instead of a timer (systemd only)")
+	var credentials []installCredential
+	flags.Func("credential", "`name=path` of a file to pass to the systemd service as a credential (repeatable)", func(value string) error {
+		name, path, ok := strings.Cut(value, "=")
+		if !ok || name == "" || strings.ContainsAny(name, "/:") || path == "" {
+			return fmt.Errorf("must be name=path")
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		credentials = append(credentials, installCredential{Name: name, Path: abs})
+		return nil
+	})
+	enable := flags.Bool("enable", false, "enable and start the service once the files are written")
+	dryRun := flags.Bool("dry-run", false, "print the files instead of writing them")
+	flags.Parse(args)
+
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("use \"clouddns service install\" on Windows")
+	}
+
+	var systems []string
+	for name, selected := range map[string]bool{"systemd": *systemd, "launchd": *launchd, "openrc": *openrc} {
+		if selected {
+			systems = append(systems, name)
+		}
+	}
+	if len(systems) != 1 {
+		return fmt.Errorf("exactly one of -systemd, -launchd, or -openrc must be given")
+	}
+
+	if *interval < time.Minute {
+		return fmt.Errorf("interval must be at least one minute")
+	}
+
+	environment, err := installEnvironment()
+	if err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable path: %w", err)
+	}
+
+	files, nextStep, err := installFiles(systems[0], *interval, *daemon)
+	if err != nil {
+		return err
+	}
+
+	data := installData{
+		Executable:  executable,
+		Environment: environment,
+		Interval:    *interval,
+		Daemon:      *daemon,
+	}
+	if systems[0] == "systemd" {
+		var config *installCredential
+		data.Environment, config, err = systemdEnvironment(logger, environment)
+		if err != nil {
+			return err
+		}
+		if config != nil {
+			data.ConfigCredential = config.Name
+			data.Credentials = append(data.Credentials, *config)
+		}
+		data.Credentials = append(data.Credentials, credentials...)
+		if data.Environment, data.Secrets = splitSecrets(data.Environment); len(data.Secrets) > 0 {
+			files = append(files, installFile{path: systemdEnvironmentFile, mode: 0600, template: systemdEnvironmentTemplate})
+		}
+	} else if len(credentials) > 0 {
+		return fmt.Errorf("-credential is only supported with -systemd")
+	}
+	if systems[0] == "launchd" {
+		// Property lists can be read by every user, and launchd has no other
+		// way to set variables, so secrets are left out.
+		var secrets []string
+		data.Environment, secrets = splitSecrets(data.Environment)
+		for _, secret := range secrets {
+			key, _, _ := strings.Cut(secret, "=")
+			logger.Warn("Leaving a secret out of the launchd property list, which every user can read", "variable", key)
+		}
+	}
+
+	for _, file := range files {
+		var sb strings.Builder
+		if err := file.template.Execute(&sb, data); err != nil {
+			return fmt.Errorf("failed to generate %s: %w", file.path, err)
+		}
+
+		if *dryRun {
+			fmt.Printf("# %s\n%s\n", file.path, sb.String())
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(file.path), 0755); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.path, err)
+		}
+		if err := os.WriteFile(file.path, []byte(sb.String()), file.mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.path, err)
+		}
+		// The mode is only used when the file is created, so an existing one
+		// is changed to match.
+		if err := os.Chmod(file.path, file.mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.path, err)
+		}
+		logger.Info("Wrote service file", "path", file.path)
+	}
+
+	switch {
+	case *dryRun:
+	case *enable:
+		cmd := exec.Command("sh", "-c", nextStep)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to enable clouddns with %q: %w", nextStep, err)
+		}
+		logger.Info("Installed and enabled clouddns")
+	default:
+		logger.Info("Installed clouddns, run this command to enable it", "command", nextStep)
+	}
+
+	return nil
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPSources configures where the current public IP addresses are fetched from.
+type IPSources struct {
+	A    IPSourceList `json:"a,omitempty"`
+	AAAA IPSourceList `json:"aaaa,omitempty"`
+	// Consensus queries every source at once and only uses an address that
+	// most of them agree on, instead of trying them in order.
+	Consensus bool `json:"consensus,omitempty"`
+	// Adaptive tries the sources that have answered fastest first, and the
+	// ones that have failed recently last, instead of the configured order.
+	Adaptive bool `json:"adaptive,omitempty"`
+}
+
+// IPSourceList is a list of sources that are tried in order until one of them
+// returns a valid address. In the configuration file, a single source can be
+// written without the list around it.
+type IPSourceList []IPSource
+
+func (l *IPSourceList) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var sources []IPSource
+		if err := json.Unmarshal(data, &sources); err != nil {
+			return err
+		}
+		*l = sources
+		return nil
+	}
+
+	var source IPSource
+	if err := json.Unmarshal(data, &source); err != nil {
+		return err
+	}
+	*l = IPSourceList{source}
+	return nil
+}
+
+// defaultIPSources returns the sources used for the record type when none are
+// configured.
+func defaultIPSources(recordType string) IPSourceList {
+	if recordType == "AAAA" {
+		return IPSourceList{
+			{Endpoint: Endpoint{URL: "https://api6.ipify.org"}},
+			{Endpoint: Endpoint{URL: "https://ipv6.icanhazip.com"}},
+		}
+	}
+	return IPSourceList{
+		{Endpoint: Endpoint{URL: "https://api.ipify.org"}},
+		{Endpoint: Endpoint{URL: "https://ipv4.icanhazip.com"}},
+	}
+}
+
+// currentIP returns the address from the first source that returns a valid
+// address for the record type. Private addresses are only valid if
+// allowPrivate is set. If none of them do, the error describes why each one
+// failed. If health is set, the sources are tried in order of how well they've
+// worked before instead of the configured order.
+func (l IPSourceList) currentIP(ctx context.Context, logger *slog.Logger, client *http.Client, recordType string, allowPrivate bool, health *sourceHealth) (string, error) {
+	if health != nil && len(l) > 1 {
+		l = health.order(l, recordType)
+		logger.Debug("Trying IP sources in order of health", "first", l[0].describe())
+	}
+
+	var errs []error
+	for i := range l {
+		source := &l[i]
+		address, err := source.trackedIP(ctx, client, recordType, allowPrivate, health)
+		if err == nil {
+			return address, nil
+		}
+
+		err = fmt.Errorf("%s: %w", source.describe(), err)
+		if i < len(l)-1 {
+			logger.Warn("IP source failed, trying the next one", "error", err)
+		}
+		errs = append(errs, err)
+	}
+	return "", errors.Join(errs...)
+}
+
+// consensusIP queries every source concurrently and returns the address that
+// more than half of them returned. Sources that fail count against every
+// address, so a single source that returns the wrong address, like a proxy's,
+// can't change the records on its own. If health is set, sources that fail or
+// disagree with the majority are recorded as failing.
+func (l IPSourceList) consensusIP(ctx context.Context, logger *slog.Logger, client *http.Client, recordType string, allowPrivate bool, health *sourceHealth) (string, error) {
+	addresses := make([]string, len(l))
+	errs := make([]error, len(l))
+	var wg sync.WaitGroup
+	for i := range l {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			address, err := l[i].trackedIP(ctx, client, recordType, allowPrivate, health)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", l[i].describe(), err)
+				logger.Warn("IP source failed", "error", errs[i])
+				return
+			}
+			addresses[i] = address
+		}()
+	}
+	wg.Wait()
+
+	votes := map[string][]string{}
+	for i, address := range addresses {
+		if address != "" {
+			votes[address] = append(votes[address], l[i].describe())
+		}
+	}
+	for address, sources := range votes {
+		if len(sources) > len(l)/2 {
+			if health != nil {
+				for i := range l {
+					if addresses[i] != "" && addresses[i] != address {
+						health.failed(&l[i], recordType)
+					}
+				}
+			}
+			return address, nil
+		}
+	}
+
+	if len(votes) > 1 {
+		logger.Error("IP sources disagree about the current address", "addresses", votes)
+	}
+	return "", fmt.Errorf("no address was returned by a majority of the %d IP sources: %w", len(l), errors.Join(errs...))
+}
+
+// checkAddress returns the address in its canonical form, or an error if it
+// isn't an address of the right family for the record type. This stops a
+// source that returns an error page or a different family from being used.
+func checkAddress(address string, recordType string) (string, error) {
+	ip, err := netip.ParseAddr(address)
+	if err != nil {
+		if strings.HasPrefix(address, "<") {
+			return "", errors.New("returned an HTML page instead of an IP address")
+		}
+		if len(address) > 64 {
+			address = address[:64] + "..."
+		}
+		return "", fmt.Errorf("returned %q, which isn't an IP address", address)
+	}
+	ip = ip.Unmap()
+	if recordType == "A" && !ip.Is4() || recordType == "AAAA" && !ip.Is6() {
+		return "", fmt.Errorf("returned %s, which is the wrong type of address for %s records", ip, recordType)
+	}
+	return ip.String(), nil
+}
+
+// reservedPrefixes are ranges that IsGlobalUnicast allows but that are never
+// routed on the internet, like the ranges reserved for documentation.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	cgnatPrefix,
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("3fff::/20"),
+}
+
+// globalUnicastIPv6 is the range that every public IPv6 address is in.
+var globalUnicastIPv6 = netip.MustParsePrefix("2000::/3")
+
+// checkPublic returns an error if the address can't be reached from the
+// internet, like a private, loopback, or documentation address. A source that
+// returns one is probably looking at the wrong network.
+func checkPublic(address string) error {
+	ip, err := netip.ParseAddr(address)
+	if err != nil {
+		return fmt.Errorf("returned %q, which isn't an IP address", address)
+	}
+	ip = ip.Unmap()
+	if ip.IsPrivate() {
+		return fmt.Errorf("returned %s, which is a private address", ip)
+	}
+	public := ip.IsGlobalUnicast() && (ip.Is4() || globalUnicastIPv6.Contains(ip))
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(ip) {
+			public = false
+		}
+	}
+	if !public {
+		return fmt.Errorf("returned %s, which isn't a public address", ip)
+	}
+	return nil
+}
+
+// withIPv6Suffix returns the address made from the first prefixLength bits of
+// address, which defaults to 64, and the remaining bits of suffix.
+func withIPv6Suffix(address string, suffix string, prefixLength int) (string, error) {
+	if prefixLength == 0 {
+		prefixLength = 64
+	}
+	prefix, err := netip.ParseAddr(address)
+	if err != nil || !prefix.Is6() || prefix.Is4In6() {
+		return "", fmt.Errorf("%q isn't an IPv6 address", address)
+	}
+	host, err := netip.ParseAddr(suffix)
+	if err != nil || !host.Is6() {
+		return "", fmt.Errorf("ipv6_suffix %q isn't an IPv6 address", suffix)
+	}
+	if prefixLength < 1 || prefixLength > 127 {
+		return "", fmt.Errorf("prefix_length %d must be between 1 and 127", prefixLength)
+	}
+
+	combined := prefix.As16()
+	bits := host.As16()
+	for i := range combined {
+		// The mask has a 1 for each bit that comes from the prefix.
+		var mask byte
+		switch kept := prefixLength - i*8; {
+		case kept >= 8:
+			mask = 0xff
+		case kept > 0:
+			mask = byte(0xff << (8 - kept))
+		}
+		combined[i] = combined[i]&mask | bits[i]&^mask
+	}
+	return netip.AddrFrom16(combined).String(), nil
+}
+
+// IPSource is somewhere the current IP address can be found. By default, it's
+// an HTTP endpoint that responds with a plain string containing only the IP
+// address, so it can be written as a URL string in the configuration file.
+type IPSource struct {
+	// Type is the kind of source, which is "http" (the default), "snmp",
+	// "dns", "stun", "interface", "gateway", "fritzbox", "tailscale",
+	// "metadata", "file", or "exec".
+	Type string `json:"type,omitempty"`
+	// Endpoint is used by "http" sources.
+	Endpoint
+	// SNMP is used by "snmp" sources.
+	SNMP *SNMPSource `json:"snmp,omitempty"`
+	// DNS is used by "dns" sources. It can be left out to use OpenDNS.
+	DNS *DNSSource `json:"dns,omitempty"`
+	// STUN is used by "stun" sources. It can be left out to use Google's
+	// public STUN server.
+	STUN *STUNSource `json:"stun,omitempty"`
+	// Interface is used by "interface" sources.
+	Interface *InterfaceSource `json:"interface,omitempty"`
+	// Gateway is used by "gateway" sources. It can be left out to find the
+	// router automatically.
+	Gateway *GatewaySource `json:"gateway,omitempty"`
+	// FritzBox is used by "fritzbox" sources. It can be left out to use the
+	// router at fritz.box.
+	FritzBox *FritzBoxSource `json:"fritzbox,omitempty"`
+	// Tailscale is used by "tailscale" sources. It can be left out to use the
+	// default socket.
+	Tailscale *TailscaleSource `json:"tailscale,omitempty"`
+	// Metadata is used by "metadata" sources.
+	Metadata *MetadataSource `json:"metadata,omitempty"`
+	// File is used by "file" sources.
+	File *FileSource `json:"file,omitempty"`
+	// Exec is used by "exec" sources.
+	Exec *CommandSource `json:"exec,omitempty"`
+}
+
+func (s *IPSource) UnmarshalJSON(data []byte) error {
+	// The endpoint decodes both the string and object forms, but because it has
+	// its own UnmarshalJSON method, the other fields need decoding separately.
+	if err := json.Unmarshal(data, &s.Endpoint); err != nil {
+		return err
+	}
+	// The string form is only the URL, so there's nothing else to decode.
+	var plain string
+	if json.Unmarshal(data, &plain) == nil {
+		return nil
+	}
+
+	var settings struct {
+		Type      string           `json:"type"`
+		SNMP      *SNMPSource      `json:"snmp"`
+		DNS       *DNSSource       `json:"dns"`
+		STUN      *STUNSource      `json:"stun"`
+		Interface *InterfaceSource `json:"interface"`
+		Gateway   *GatewaySource   `json:"gateway"`
+		FritzBox  *FritzBoxSource  `json:"fritzbox"`
+		Tailscale *TailscaleSource `json:"tailscale"`
+		Metadata  *MetadataSource  `json:"metadata"`
+		File      *FileSource      `json:"file"`
+		Exec      *CommandSource   `json:"exec"`
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	s.Type = settings.Type
+	s.SNMP = settings.SNMP
+	s.DNS = settings.DNS
+	s.STUN = settings.STUN
+	s.Interface = settings.Interface
+	s.Gateway = settings.Gateway
+	s.FritzBox = settings.FritzBox
+	s.Tailscale = settings.Tailscale
+	s.Metadata = settings.Metadata
+	s.File = settings.File
+	s.Exec = settings.Exec
+	return nil
+}
+
+// describe returns a short description of the source for logs and errors.
+func (s *IPSource) describe() string {
+	switch s.Type {
+	case "snmp":
+		if s.SNMP != nil {
+			return "snmp " + s.SNMP.Address
+		}
+	case "dns":
+		if s.DNS != nil && s.DNS.Service != "" {
+			return "dns " + s.DNS.Service
+		}
+		return "dns opendns"
+	case "stun":
+		if s.STUN != nil && s.STUN.Server != "" {
+			return "stun " + s.STUN.Server
+		}
+		return "stun"
+	case "interface":
+		if s.Interface != nil {
+			return "interface " + s.Interface.Name
+		}
+	case "gateway":
+		return "gateway"
+	case "fritzbox":
+		if s.FritzBox != nil && s.FritzBox.Address != "" {
+			return "fritzbox " + s.FritzBox.Address
+		}
+		return "fritzbox"
+	case "tailscale":
+		return "tailscale"
+	case "metadata":
+		if s.Metadata != nil {
+			return "metadata " + s.Metadata.Provider
+		}
+	case "file":
+		if s.File != nil {
+			return "file " + s.File.Path
+		}
+	case "exec":
+		if s.Exec != nil && len(s.Exec.Command) > 0 {
+			return "exec " + s.Exec.Command[0]
+		}
+	}
+	// The URL's password, if it has one, is left out of logs.
+	if parsed, err := url.Parse(s.URL); err == nil {
+		return parsed.Redacted()
+	}
+	return s.URL
+}
+
+// describe returns the descriptions of the sources, which are safe to log
+// because they leave out their credentials.
+func (l IPSourceList) describe() []string {
+	descriptions := make([]string, len(l))
+	for i := range l {
+		descriptions[i] = l[i].describe()
+	}
+	return descriptions
+}
+
+// pinnedToFamily returns a copy of the client that only connects over the
+// address family of the record type. Otherwise, a dual-stack service could be
+// reached over IPv6 while looking up the A record, and return the wrong
+// address.
+func pinnedToFamily(client *http.Client, recordType string) *http.Client {
+	network := "tcp4"
+	if recordType == "AAAA" {
+		network = "tcp6"
+	}
+
+	return withTransport(client, "network "+network, "", func(transport *http.Transport) {
+		dial := transport.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+		}
+		transport.DialContext = func(ctx context.Context, _ string, address string) (net.Conn, error) {
+			return dial(ctx, network, address)
+		}
+	})
+}
+
+// checkedIP returns the current IP address from the source, or an error if it
+// isn't an address of the right family for the record type, or is private and
+// allowPrivate isn't set.
+func (s *IPSource) checkedIP(ctx context.Context, client *http.Client, recordType string, allowPrivate bool) (string, error) {
+	address, err := s.currentIP(ctx, client, recordType)
+	if err != nil {
+		return "", err
+	}
+	if address, err = checkAddress(address, recordType); err != nil {
+		return "", err
+	}
+	// Interface sources can be told to allow private addresses themselves, and
+	// Tailscale addresses are always in private ranges.
+	if allowPrivate || s.Type == "tailscale" || s.Type == "interface" && s.Interface != nil && s.Interface.AllowPrivate {
+		return address, nil
+	}
+	if err := checkPublic(address); err != nil {
+		return "", err
+	}
+	return address, nil
+}
+
+// trackedIP is checkedIP, but records how long the source took and whether it
+// failed if health is set.
+func (s *IPSource) trackedIP(ctx context.Context, client *http.Client, recordType string, allowPrivate bool, health *sourceHealth) (string, error) {
+	start := time.Now()
+	address, err := s.checkedIP(ctx, client, recordType, allowPrivate)
+	if health != nil {
+		if err != nil {
+			health.failed(s, recordType)
+		} else {
+			health.succeeded(s, recordType, time.Since(start))
+		}
+	}
+	return address, err
+}
+
+// currentIP returns the current IP address for the record type from the source.
+// Only HTTP and metadata sources stop when the context is done, because the
+// others have short timeouts of their own.
+func (s *IPSource) currentIP(ctx context.Context, client *http.Client, recordType string) (string, error) {
+	switch s.Type {
+	case "", "http":
+		ipClient, err := s.httpClient(client)
+		if err != nil {
+			return "", fmt.Errorf("failed to create IP source client: %w", err)
+		}
+		ipClient = pinnedToFamily(ipClient, recordType)
+		return getCurrentIP(ctx, ipClient, &s.Endpoint)
+	case "snmp":
+		if s.SNMP == nil {
+			return "", fmt.Errorf("snmp IP source has no snmp settings")
+		}
+		return s.SNMP.currentIP(ctx, recordType)
+	case "dns":
+		if s.DNS == nil {
+			return (&DNSSource{}).currentIP(ctx, recordType)
+		}
+		return s.DNS.currentIP(ctx, recordType)
+	case "stun":
+		if s.STUN == nil {
+			return (&STUNSource{}).currentIP(ctx, recordType)
+		}
+		return s.STUN.currentIP(ctx, recordType)
+	case "interface":
+		if s.Interface == nil {
+			return "", fmt.Errorf("interface IP source has no interface settings")
+		}
+		return s.Interface.currentIP(recordType)
+	case "gateway":
+		if s.Gateway == nil {
+			return (&GatewaySource{}).currentIP(ctx, client, recordType)
+		}
+		return s.Gateway.currentIP(ctx, client, recordType)
+	case "fritzbox":
+		if s.FritzBox == nil {
+			return (&FritzBoxSource{}).currentIP(client, recordType)
+		}
+		return s.FritzBox.currentIP(client, recordType)
+	case "tailscale":
+		if s.Tailscale == nil {
+			return (&TailscaleSource{}).currentIP(recordType)
+		}
+		return s.Tailscale.currentIP(recordType)
+	case "metadata":
+		if s.Metadata == nil {
+			return "", fmt.Errorf("metadata IP source has no metadata settings")
+		}
+		return s.Metadata.currentIP(ctx, client, recordType)
+	case "file":
+		if s.File == nil || s.File.Path == "" {
+			return "", fmt.Errorf("file IP source has no path")
+		}
+		return s.File.currentIP()
+	case "exec":
+		if s.Exec == nil || len(s.Exec.Command) == 0 {
+			return "", fmt.Errorf("exec IP source has no command")
+		}
+		return s.Exec.currentIP(ctx, recordType)
+	default:
+		return "", fmt.Errorf("unknown IP source type %q", s.Type)
+	}
+}
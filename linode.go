@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// LinodeDNS holds the settings for a record in a domain managed by Linode.
+type LinodeDNS struct {
+	// APIToken is a Linode personal access token with read/write access to
+	// Domains.
+	APIToken string `json:"api_token"`
+	// APITokenFile is the path to a file containing the API token, which is
+	// read when the configuration is loaded if APIToken isn't set.
+	APITokenFile string `json:"api_token_file,omitempty"`
+	// DomainID is the numeric ID of the domain.
+	DomainID string `json:"domain_id"`
+	// RecordID is the numeric ID of the record in the domain.
+	RecordID string `json:"record_id"`
+}
+
+// linodeRecordUpdate is the body of a request to update a domain record.
+type linodeRecordUpdate struct {
+	Target string `json:"target"`
+	TTL    int    `json:"ttl_sec,omitempty"`
+}
+
+// update points the record at the address. Linode rounds the TTL to the nearest
+// value it supports, and uses the domain's default TTL if it's 0.
+func (l *LinodeDNS) update(ctx context.Context, client *http.Client, record *DNSRecord, address string) error {
+	update := linodeRecordUpdate{Target: address}
+	if record.TTL > 1 {
+		update.TTL = record.TTL
+	}
+
+	req, err := newJSONRequest(ctx, "PUT", "https://api.linode.com/v4/domains/"+url.PathEscape(l.DomainID)+"/records/"+url.PathEscape(l.RecordID), update)
+	if err != nil {
+		return err
+	}
+	req = withPurpose(req, "dns_update")
+	req.Header.Set("Authorization", "Bearer "+l.APIToken)
+	return doJSON(client, req, nil)
+}
+
+// validate returns a description of each problem with the settings.
+func (l *LinodeDNS) validate() []string {
+	var problems []string
+	for _, field := range []struct{ name, value string }{
+		{"api_token", l.APIToken},
+		{"domain_id", l.DomainID},
+		{"record_id", l.RecordID},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			problems = append(problems, fmt.Sprintf("linode %s is missing", field.name))
+		}
+	}
+	return problems
+}
@@ -2,13 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -21,6 +28,9 @@ type DNSRecord struct {
 	// APIToken is the token used to make the request to the Cloudflare API.
 	// Specifying this per-record allows for different tokens to be used for different records.
 	APIToken string `json:"api_token"`
+	// APITokenFile is the path to a file containing the API token, which is
+	// read when the configuration is loaded if APIToken isn't set.
+	APITokenFile string `json:"api_token_file,omitempty"`
 	// ZoneID is the "zone ID", which is the ID for the configuration for a given domain name.
 	ZoneID string `json:"zone_id"`
 	// RecordID is the ID for the DNS record to update. This is only exposed through the API.
@@ -31,13 +41,368 @@ type DNSRecord struct {
 	// with the following structure: { "record_name": <string>, "record_type": <string>, "ip_address": <string> }
 	// If the webhook times out (5 seconds) or returns a non-OK status, the URL will be retried
 	// 2 more times. If it never succeeds, it will not be retried.
-	Webhooks []string `json:"webhooks,omitempty"`
+	Webhooks []Endpoint `json:"webhooks,omitempty"`
+	// PTR is a reverse DNS record to keep pointing at Name. If it can't be
+	// updated, the whole record is treated as failed so that it's retried.
+	PTR *PTRRecord `json:"ptr,omitempty"`
+	// TTL is the record's time to live in seconds. 0 or 1 lets Cloudflare
+	// choose it automatically.
+	TTL int `json:"ttl,omitempty"`
+	// Groups are names for sets of records, so that only some of them can be
+	// synced with DDNS_GROUPS or the -group flag.
+	Groups []string `json:"groups,omitempty"`
+	// Enabled can be set to false to stop syncing the record without removing
+	// it from the configuration.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Proxied sets whether the record is proxied through Cloudflare. If it's
+	// left out, the record stays as it is.
+	Proxied *bool `json:"proxied,omitempty"`
+	// IP is a fixed address for the record, which is used instead of the
+	// current address.
+	IP string `json:"ip,omitempty"`
+	// AllowPrivate allows the record to point at a private address, like
+	// 192.168.0.0/16, for records in internal zones. By default, addresses
+	// that can't be reached from the internet are rejected.
+	AllowPrivate *bool `json:"allow_private,omitempty"`
+	// CreateIfMissing creates the record when it doesn't have a record_id and
+	// there's no record with its name and type to discover, instead of
+	// failing.
+	CreateIfMissing *bool `json:"create_if_missing,omitempty"`
+	// IPv6Suffix is the interface identifier of another host on the network.
+	// The record is pointed at the current address's prefix with this suffix,
+	// so hosts behind a delegated prefix can be updated from one machine.
+	IPv6Suffix string `json:"ipv6_suffix,omitempty"`
+	// PrefixLength is how many bits of the current address are kept when
+	// IPv6Suffix is set. The default is 64.
+	PrefixLength int `json:"prefix_length,omitempty"`
+	// IPSources overrides where the current address is fetched from for this
+	// record, for machines with more than one uplink.
+	IPSources IPSourceList `json:"ip_sources,omitempty"`
+	// Uplink is the name of the uplink whose address the record is pointed
+	// at, instead of using ip_sources.
+	Uplink string `json:"uplink,omitempty"`
+	// ConfirmChecks is how many checks in a row must find a new address
+	// before the record is updated, so that an address that's only held for
+	// a moment is ignored. The default is 1.
+	ConfirmChecks int `json:"confirm_checks,omitempty"`
+	// ConfirmTime is how many seconds a new address must be seen for before
+	// the record is updated.
+	ConfirmTime int `json:"confirm_time,omitempty"`
+	// Provider is the DNS provider that hosts the record. It's "cloudflare" if
+	// it's empty, and other providers read their settings from the field with
+	// the provider's name instead of api_token, zone_id, and record_id.
+	Provider string `json:"provider,omitempty"`
+	// Google holds the settings for the "google" provider.
+	Google *GoogleCloudDNS `json:"google,omitempty"`
+	// Linode holds the settings for the "linode" provider.
+	Linode *LinodeDNS `json:"linode,omitempty"`
+	// Vultr holds the settings for the "vultr" provider.
+	Vultr *VultrDNS `json:"vultr,omitempty"`
+	// Gandi holds the settings for the "gandi" provider.
+	Gandi *GandiLiveDNS `json:"gandi,omitempty"`
+	// DynDNS2 holds the settings for the "dyndns2" provider.
+	DynDNS2 *DynDNS2 `json:"dyndns2,omitempty"`
+	// DNSOMatic holds the settings for the "dnsomatic" provider.
+	DNSOMatic *DNSOMatic `json:"dnsomatic,omitempty"`
+	// GoDaddy holds the settings for the "godaddy" provider.
+	GoDaddy *GoDaddyDNS `json:"godaddy,omitempty"`
+	// RFC2136 holds the settings for the "rfc2136" provider.
+	RFC2136 *RFC2136 `json:"rfc2136,omitempty"`
+	// PowerDNS holds the settings for the "powerdns" provider.
+	PowerDNS *PowerDNS `json:"powerdns,omitempty"`
+	// Exec holds the settings for the "exec" provider.
+	Exec *ExecProvider `json:"exec,omitempty"`
+}
+
+// RecordDefaults holds settings that every record inherits unless it sets them
+// itself.
+type RecordDefaults struct {
+	APIToken      string     `json:"api_token,omitempty"`
+	APITokenFile  string     `json:"api_token_file,omitempty"`
+	ZoneID        string     `json:"zone_id,omitempty"`
+	Webhooks      []Endpoint `json:"webhooks,omitempty"`
+	TTL           int        `json:"ttl,omitempty"`
+	AllowPrivate  bool       `json:"allow_private,omitempty"`
+	ConfirmChecks int        `json:"confirm_checks,omitempty"`
+	ConfirmTime   int        `json:"confirm_time,omitempty"`
+	// CreateIfMissing is inherited by records that don't set create_if_missing.
+	CreateIfMissing bool `json:"create_if_missing,omitempty"`
 }
 
 // DNSConfiguration holds separate lists of A and AAAA records
 type DNSConfiguration struct {
 	A    []DNSRecord `json:"a,omitempty"`
 	AAAA []DNSRecord `json:"aaaa,omitempty"`
+	// TLS is the TLS configuration used for all outbound HTTPS requests.
+	TLS *TLSConfig `json:"tls,omitempty"`
+	// Bind is the interface or address that all outbound HTTP requests are made
+	// from.
+	Bind *BindConfig `json:"bind,omitempty"`
+	// IPSources overrides where the current IP addresses are fetched from.
+	IPSources IPSources `json:"ip_sources,omitempty"`
+	// Uplinks are the machine's internet connections by name, for records
+	// that should be published with the address of a particular one.
+	Uplinks map[string]*Uplink `json:"uplinks,omitempty"`
+	// Schedules are cron expressions for groups of records that should be
+	// synced on their own schedule while running as a daemon, by group name.
+	Schedules map[string]string `json:"schedules,omitempty"`
+	// schedules are the parsed Schedules.
+	schedules map[string]*cronSchedule
+	// Defaults are inherited by every record that doesn't override them.
+	Defaults *RecordDefaults `json:"defaults,omitempty"`
+	// Ping is a healthchecks.io style URL that's told when each run starts and
+	// whether it succeeded.
+	Ping *Endpoint `json:"ping,omitempty"`
+	// HTTP tunes the connections of every outbound request.
+	HTTP *HTTPConfig `json:"http,omitempty"`
+	// Concurrency is how many records are updated at the same time, or
+	// defaultConcurrency if it's 0.
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// secretFiles are the settings that were read from files, for reading
+	// them again when the files change.
+	secretFiles []secretFile
+}
+
+// secretFile is a setting, like an API token, that was read from a file.
+type secretFile struct {
+	value *string
+	path  string
+	// size and modTime identify the version of the file that was read.
+	size    int64
+	modTime time.Time
+}
+
+// defaultConcurrency is how many records are updated at the same time unless
+// the configuration says otherwise. More than a handful of connections at once
+// gains little, and hundreds can trip Cloudflare's rate limits.
+const defaultConcurrency = 8
+
+// removeDisabled removes every record that has enabled set to false.
+func (c *DNSConfiguration) removeDisabled(logger *slog.Logger) {
+	disabled := func(recordType string) func(DNSRecord) bool {
+		return func(record DNSRecord) bool {
+			if record.Enabled != nil && !*record.Enabled {
+				logger.Info("Skipping disabled record", "record_type", recordType, "record_name", record.Name)
+				return true
+			}
+			return false
+		}
+	}
+	c.A = slices.DeleteFunc(c.A, disabled("A"))
+	c.AAAA = slices.DeleteFunc(c.AAAA, disabled("AAAA"))
+}
+
+// applyDefaults fills in the settings that each record doesn't set with the
+// configured defaults. A record that sets webhooks to an empty list doesn't
+// inherit the default webhooks.
+func (c *DNSConfiguration) applyDefaults() {
+	if c.Defaults == nil {
+		return
+	}
+
+	for _, records := range [][]DNSRecord{c.A, c.AAAA} {
+		for i := range records {
+			record := &records[i]
+			if record.APIToken == "" && record.APITokenFile == "" {
+				record.APIToken = c.Defaults.APIToken
+				record.APITokenFile = c.Defaults.APITokenFile
+			}
+			if record.ZoneID == "" {
+				record.ZoneID = c.Defaults.ZoneID
+			}
+			if record.Webhooks == nil {
+				// Each record gets its own copy, because the TLS settings of
+				// webhooks are changed in place when they're inherited.
+				record.Webhooks = slices.Clone(c.Defaults.Webhooks)
+			}
+			if record.TTL == 0 {
+				record.TTL = c.Defaults.TTL
+			}
+			if record.AllowPrivate == nil && c.Defaults.AllowPrivate {
+				record.AllowPrivate = &c.Defaults.AllowPrivate
+			}
+			if record.ConfirmChecks == 0 {
+				record.ConfirmChecks = c.Defaults.ConfirmChecks
+			}
+			if record.ConfirmTime == 0 {
+				record.ConfirmTime = c.Defaults.ConfirmTime
+			}
+			if record.CreateIfMissing == nil && c.Defaults.CreateIfMissing {
+				record.CreateIfMissing = &c.Defaults.CreateIfMissing
+			}
+		}
+	}
+}
+
+// readTokenFiles sets the API token of every record that has a token file but
+// no token to the contents of the file, without surrounding whitespace. The
+// files are remembered, so that refreshSecretFiles can read them again.
+func (c *DNSConfiguration) readTokenFiles() error {
+	read := func(token *string, path string) error {
+		if *token != "" || path == "" {
+			return nil
+		}
+		file := secretFile{value: token, path: path}
+		if err := file.read(); err != nil {
+			return err
+		}
+		c.secretFiles = append(c.secretFiles, file)
+		return nil
+	}
+
+	readBasicAuth := func(endpoint *Endpoint) error {
+		if endpoint.BasicAuth == nil {
+			return nil
+		}
+		return read(&endpoint.BasicAuth.Password, endpoint.BasicAuth.PasswordFile)
+	}
+	for _, sources := range []IPSourceList{c.IPSources.A, c.IPSources.AAAA} {
+		for i := range sources {
+			if err := readBasicAuth(&sources[i].Endpoint); err != nil {
+				return fmt.Errorf("failed to read the basic_auth password_file for %s: %w", sources[i].URL, err)
+			}
+		}
+	}
+	if c.Ping != nil {
+		if err := readBasicAuth(c.Ping); err != nil {
+			return fmt.Errorf("failed to read the basic_auth password_file for the ping URL: %w", err)
+		}
+	}
+
+	for _, records := range [][]DNSRecord{c.A, c.AAAA} {
+		for i := range records {
+			record := &records[i]
+			for j := range record.Webhooks {
+				if err := readBasicAuth(&record.Webhooks[j]); err != nil {
+					return fmt.Errorf("failed to read the basic_auth password_file for a webhook of %s: %w", record.Name, err)
+				}
+			}
+			for j := range record.IPSources {
+				if err := readBasicAuth(&record.IPSources[j].Endpoint); err != nil {
+					return fmt.Errorf("failed to read the basic_auth password_file for an IP source of %s: %w", record.Name, err)
+				}
+			}
+			if err := read(&record.APIToken, record.APITokenFile); err != nil {
+				return fmt.Errorf("failed to read api_token_file for %s: %w", record.Name, err)
+			}
+			if record.Linode != nil {
+				if err := read(&record.Linode.APIToken, record.Linode.APITokenFile); err != nil {
+					return fmt.Errorf("failed to read the linode api_token_file for %s: %w", record.Name, err)
+				}
+			}
+			if record.Vultr != nil {
+				if err := read(&record.Vultr.APIKey, record.Vultr.APIKeyFile); err != nil {
+					return fmt.Errorf("failed to read the vultr api_key_file for %s: %w", record.Name, err)
+				}
+			}
+			if record.Gandi != nil {
+				if err := read(&record.Gandi.APIToken, record.Gandi.APITokenFile); err != nil {
+					return fmt.Errorf("failed to read the gandi api_token_file for %s: %w", record.Name, err)
+				}
+			}
+			if record.DynDNS2 != nil {
+				if err := read(&record.DynDNS2.Password, record.DynDNS2.PasswordFile); err != nil {
+					return fmt.Errorf("failed to read the dyndns2 password_file for %s: %w", record.Name, err)
+				}
+			}
+			if record.DNSOMatic != nil {
+				if err := read(&record.DNSOMatic.Password, record.DNSOMatic.PasswordFile); err != nil {
+					return fmt.Errorf("failed to read the dnsomatic password_file for %s: %w", record.Name, err)
+				}
+			}
+			if record.GoDaddy != nil {
+				if err := read(&record.GoDaddy.APISecret, record.GoDaddy.APISecretFile); err != nil {
+					return fmt.Errorf("failed to read the godaddy api_secret_file for %s: %w", record.Name, err)
+				}
+			}
+			if record.RFC2136 != nil {
+				if err := read(&record.RFC2136.TSIGSecret, record.RFC2136.TSIGSecretFile); err != nil {
+					return fmt.Errorf("failed to read the rfc2136 tsig_secret_file for %s: %w", record.Name, err)
+				}
+			}
+			if record.PowerDNS != nil {
+				if err := read(&record.PowerDNS.APIKey, record.PowerDNS.APIKeyFile); err != nil {
+					return fmt.Errorf("failed to read the powerdns api_key_file for %s: %w", record.Name, err)
+				}
+			}
+			if record.PTR != nil {
+				if err := read(&record.PTR.APIToken, record.PTR.APITokenFile); err != nil {
+					return fmt.Errorf("failed to read api_token_file for the PTR record of %s: %w", record.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// read sets the setting to the contents of the file, without surrounding
+// whitespace.
+func (f *secretFile) read() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	*f.value = strings.TrimSpace(string(data))
+	f.size, f.modTime = info.Size(), info.ModTime()
+	return nil
+}
+
+// refreshSecretFiles reads the settings that came from files again if the
+// files have changed since, so that a token that's rotated by a secrets
+// manager is used from the next sync on, without reloading the configuration.
+// If a file can't be read, the setting keeps its previous value.
+func (c *DNSConfiguration) refreshSecretFiles(logger *slog.Logger) {
+	for i := range c.secretFiles {
+		file := &c.secretFiles[i]
+		info, err := os.Stat(file.path)
+		if err == nil && info.Size() == file.size && info.ModTime().Equal(file.modTime) {
+			continue
+		}
+		if err == nil {
+			err = file.read()
+		}
+		if err != nil {
+			logger.Warn("Failed to read secret file again, using the previous contents", "path", file.path, "error", err)
+			continue
+		}
+		logger.Info("Read secret file again because it changed", "path", file.path)
+	}
+}
+
+// inheritTLSConfig fills in the TLS settings of every endpoint that has its own
+// TLS configuration with the top-level settings that it doesn't override. Those
+// endpoints don't use the default client, so they also inherit its binding.
+func (c *DNSConfiguration) inheritTLSConfig() {
+	inherit := func(endpoint *Endpoint) {
+		if endpoint != nil && endpoint.TLS != nil {
+			endpoint.TLS = mergeTLSConfig(c.TLS, endpoint.TLS)
+			if endpoint.Bind == nil {
+				endpoint.Bind = c.Bind
+			}
+		}
+	}
+
+	for _, sources := range []IPSourceList{c.IPSources.A, c.IPSources.AAAA} {
+		for i := range sources {
+			inherit(&sources[i].Endpoint)
+		}
+	}
+	inherit(c.Ping)
+	for _, records := range [][]DNSRecord{c.A, c.AAAA} {
+		for i := range records {
+			for j := range records[i].Webhooks {
+				inherit(&records[i].Webhooks[j])
+			}
+			for j := range records[i].IPSources {
+				inherit(&records[i].IPSources[j].Endpoint)
+			}
+		}
+	}
 }
 
 // WebhookPayload represents the data sent to webhooks
@@ -52,20 +417,20 @@ type DiscordWebhookPayload struct {
 	Content string `json:"content"`
 }
 
-func loadDNSConfiguration() (DNSConfiguration, error) {
+func loadDNSConfiguration(logger *slog.Logger) (DNSConfiguration, error) {
 	var configuration DNSConfiguration
 
 	configPath := os.Getenv("DDNS_CONFIG_PATH")
 	if configPath == "" {
-		return configuration, fmt.Errorf("DDNS_CONFIG_PATH environment variable not set")
+		return configuration, fmt.Errorf("DDNS_CONFIG_PATH environment variable or -config flag not set")
 	}
 
-	configFile, err := os.ReadFile(configPath)
+	configJSON, err := readConfig(logger, configPath)
 	if err != nil {
-		return configuration, fmt.Errorf("failed to read config file: %w", err)
+		return configuration, err
 	}
 
-	err = json.Unmarshal(configFile, &configuration)
+	err = json.Unmarshal(configJSON, &configuration)
 	if err != nil {
 		return configuration, fmt.Errorf("failed to parse config file: %w", err)
 	}
@@ -74,6 +439,36 @@ func loadDNSConfiguration() (DNSConfiguration, error) {
 		return configuration, fmt.Errorf("no DNS records found in config file")
 	}
 
+	configuration.removeDisabled(logger)
+	if len(configuration.A) == 0 && len(configuration.AAAA) == 0 {
+		return configuration, fmt.Errorf("every DNS record in the config file is disabled")
+	}
+
+	if groups := selectedGroups(); len(groups) > 0 {
+		configuration.selectGroups(groups)
+		if len(configuration.A) == 0 && len(configuration.AAAA) == 0 {
+			return configuration, fmt.Errorf("no DNS records found in the groups %s", strings.Join(groups, ", "))
+		}
+	}
+
+	configuration.applyDefaults()
+	if err := configuration.parseSchedules(); err != nil {
+		return configuration, err
+	}
+	if err := configuration.applyUplinks(); err != nil {
+		return configuration, err
+	}
+	if err := configuration.readTokenFiles(); err != nil {
+		return configuration, err
+	}
+	configuration.inheritTLSConfig()
+
+	if shouldLoadStrictly() {
+		if err := checkStrictly(configJSON, &configuration); err != nil {
+			return configuration, fmt.Errorf("invalid configuration:\n%w", err)
+		}
+	}
+
 	return configuration, nil
 }
 
@@ -110,18 +505,37 @@ func sanitizeString(input string) string {
 	return sb.String()
 }
 
-func generateCacheFilename(record *DNSRecord, recordType string) string {
+// cacheKey identifies the record in the names of its files in the cache
+// directory. The same name can be hosted by more than one provider, like a
+// split-horizon setup on Cloudflare and an internal server, so every record
+// besides a Cloudflare one with an ID, which keeps the key it's always had,
+// includes its provider and a hash of the settings that identify it there.
+func cacheKey(record *DNSRecord, recordType string) string {
 	safeName := sanitizeString(record.Name)
-	safeKey := recordType + "_" + safeName + "_" + record.RecordID
-	return "cached_ip_" + safeKey + ".txt"
+	if record.usesCloudflare() && record.RecordID != "" {
+		return recordType + "_" + safeName + "_" + record.RecordID
+	}
+	provider := record.Provider
+	if provider == "" {
+		provider = "cloudflare"
+	}
+	sum := sha256.Sum256([]byte(strings.Join(record.providerIdentity(), "\x00")))
+	return recordType + "_" + safeName + "_" + provider + "_" + hex.EncodeToString(sum[:8])
+}
+
+func generateCacheFilename(record *DNSRecord, recordType string) string {
+	return "cached_ip_" + cacheKey(record, recordType) + ".txt"
 }
 
-// CloudflareUpdateRequest represents the Cloudflare API request
+// CloudflareUpdateRequest represents the Cloudflare API request. Updates only
+// change the fields that are set, so a TTL of 0 leaves the record's TTL as it
+// is.
 type CloudflareUpdateRequest struct {
 	Type    string `json:"type"`
 	Name    string `json:"name"`
 	Content string `json:"content"`
-	TTL     int    `json:"ttl"`
+	TTL     int    `json:"ttl,omitempty"`
+	Proxied *bool  `json:"proxied,omitempty"`
 }
 
 // CloudflareResponse represents the API response structure
@@ -136,14 +550,45 @@ type CloudflareError struct {
 	Message string `json:"message"`
 }
 
-func updateCloudflareRecord(client *http.Client, record *DNSRecord, recordType string, address string) error {
+// updateCloudflareRecord points the record at the address. A record without a
+// zone_id or record_id has them discovered by its name and type first.
+func updateCloudflareRecord(ctx context.Context, client *http.Client, record *DNSRecord, recordType string, address string) error {
+	if record.ZoneID != "" && record.RecordID != "" {
+		return patchCloudflareRecord(ctx, client, record, recordType, address)
+	}
+
+	withIDs, keys, cached, created, err := discoverIDs(ctx, client, record, recordType, address)
+	if err != nil || created {
+		return err
+	}
+	err = patchCloudflareRecord(ctx, client, withIDs, recordType, address)
+	var notFound *notFoundError
+	if cached && errors.As(err, &notFound) {
+		// The zone or record was deleted and created again since its ID was
+		// discovered, so the new one is looked up.
+		for _, key := range keys {
+			discoveryFrom(ctx).forget(key)
+		}
+		if withIDs, _, _, created, err = discoverIDs(ctx, client, record, recordType, address); err != nil || created {
+			return err
+		}
+		err = patchCloudflareRecord(ctx, client, withIDs, recordType, address)
+	}
+	return err
+}
+
+// patchCloudflareRecord points the record with the given ID at the address.
+// Only the settings in the configuration are changed, so ones made in the
+// dashboard, like comments, tags, or being proxied, are kept.
+func patchCloudflareRecord(ctx context.Context, client *http.Client, record *DNSRecord, recordType string, address string) error {
 	url := "https://api.cloudflare.com/client/v4/zones/" + record.ZoneID + "/dns_records/" + record.RecordID
 
 	updateReq := CloudflareUpdateRequest{
 		Type:    recordType,
 		Name:    record.Name,
 		Content: address,
-		TTL:     1,
+		TTL:     record.TTL,
+		Proxied: record.Proxied,
 	}
 
 	jsonData, err := json.Marshal(updateReq)
@@ -151,34 +596,46 @@ func updateCloudflareRecord(client *http.Client, record *DNSRecord, recordType s
 		return fmt.Errorf("failed to marshal JSON: %w", err)
 	}
 
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
+	breaker := breakerFrom(ctx)
+	// Setting the record is idempotent, so it's safe to try again after a
+	// failure that might have happened after Cloudflare made the change.
+	return withRetries(ctx, cloudflareAttempts, func() error {
+		return breaker.call(func() error {
+			req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewReader(jsonData))
+			if err != nil {
+				return fmt.Errorf("failed to create request: %w", err)
+			}
 
-	req.Header.Set("Authorization", "Bearer "+record.APIToken)
-	req.Header.Set("Content-Type", "application/json")
+			req = withPurpose(req, "cloudflare_update")
+			req.Header.Set("Authorization", "Bearer "+record.APIToken)
+			req.Header.Set("Content-Type", "application/json")
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
+			resp, err := client.Do(req)
+			if err != nil {
+				return requestError(fmt.Errorf("request failed: %w", err))
+			}
+			defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
-	}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return &transientError{err: fmt.Errorf("failed to read response body: %w", err)}
+			}
 
-	if resp.StatusCode >= 400 {
-		var cfResp CloudflareResponse
-		if err := json.Unmarshal(body, &cfResp); err == nil && len(cfResp.Errors) > 0 {
-			return fmt.Errorf("API error: %s (code: %d)", cfResp.Errors[0].Message, cfResp.Errors[0].Code)
-		}
-		return fmt.Errorf("API error: %d %s", resp.StatusCode, string(body))
-	}
+			if resp.StatusCode >= 400 {
+				err := fmt.Errorf("API error: %d %s", resp.StatusCode, string(body))
+				var cfResp CloudflareResponse
+				if json.Unmarshal(body, &cfResp) == nil && len(cfResp.Errors) > 0 {
+					err = fmt.Errorf("API error: %s (code: %d)", cfResp.Errors[0].Message, cfResp.Errors[0].Code)
+				}
+				if resp.StatusCode == http.StatusNotFound {
+					return &notFoundError{err: err}
+				}
+				return statusError(resp, err)
+			}
 
-	return nil
+			return nil
+		})
+	})
 }
 
 func readCachedIP(basePath, fileName string) (string, error) {
@@ -206,17 +663,38 @@ func writeCachedIP(basePath, fileName, content string) error {
 		return fmt.Errorf("cannot write cache file, no base path provided")
 	}
 
-	cachePath := filepath.Join(basePath, fileName)
-	err := os.WriteFile(cachePath, []byte(content), 0644)
-	if err != nil {
+	if err := writeFileAtomically(filepath.Join(basePath, fileName), []byte(content), 0644); err != nil {
 		return fmt.Errorf("failed to write cache file: %w", err)
 	}
 
 	return nil
 }
 
-func getCurrentIP(client *http.Client, api string) (string, error) {
-	resp, err := client.Get(api)
+// writeFileAtomically writes the file to a temporary file next to it first,
+// and then renames it into place, so that being killed part of the way through
+// can't leave a truncated file behind, and a reader never sees one. A temporary
+// file left behind by an earlier attempt is removed first, because writing to
+// it wouldn't change its mode.
+func writeFileAtomically(path string, data []byte, perm os.FileMode) error {
+	tempPath := path + ".tmp"
+	if err := os.Remove(tempPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.WriteFile(tempPath, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+func getCurrentIP(ctx context.Context, client *http.Client, endpoint *Endpoint) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint.URL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withPurpose(req, "ip_lookup")
+	endpoint.authorize(req)
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to request IP: %w", err)
 	}
@@ -235,7 +713,8 @@ func getCurrentIP(client *http.Client, api string) (string, error) {
 }
 
 // sendWebhook sends raw JSON data to a webhook URL with retry logic
-func sendWebhook(logger *slog.Logger, client *http.Client, url string, jsonData []byte) error {
+func sendWebhook(ctx context.Context, logger *slog.Logger, client *http.Client, webhook *Endpoint, jsonData []byte) error {
+	url := webhook.URL
 	logger = logger.With("payload", string(jsonData))
 	maxRetries := 3
 	baseDelay := 1 * time.Second
@@ -247,7 +726,7 @@ func sendWebhook(logger *slog.Logger, client *http.Client, url string, jsonData
 
 		startTime := time.Now()
 
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 		if err != nil {
 			logger.Error("Failed to create webhook request",
 				"url", url,
@@ -255,12 +734,16 @@ func sendWebhook(logger *slog.Logger, client *http.Client, url string, jsonData
 				"max_retries", maxRetries,
 				"error", err)
 			if attempt < maxRetries {
-				time.Sleep(baseDelay * time.Duration(attempt))
+				if err := sleep(ctx, baseDelay*time.Duration(attempt)); err != nil {
+					return fmt.Errorf("webhook wasn't sent before the run's deadline: %w", err)
+				}
 				continue
 			}
 			return fmt.Errorf("failed to create request: %w", err)
 		}
 
+		req = withPurpose(req, "webhook")
+		webhook.authorize(req)
 		req.Header.Set("Content-Type", "application/json")
 
 		resp, err := client.Do(req)
@@ -273,7 +756,9 @@ func sendWebhook(logger *slog.Logger, client *http.Client, url string, jsonData
 				"response_time_ms", responseTime.Milliseconds(),
 				"error", err)
 			if attempt < maxRetries {
-				time.Sleep(baseDelay * time.Duration(attempt))
+				if err := sleep(ctx, baseDelay*time.Duration(attempt)); err != nil {
+					return fmt.Errorf("webhook wasn't sent before the run's deadline: %w", err)
+				}
 				continue
 			}
 			return fmt.Errorf("request failed: %w", err)
@@ -298,7 +783,9 @@ func sendWebhook(logger *slog.Logger, client *http.Client, url string, jsonData
 			"response_time_ms", responseTime.Milliseconds())
 
 		if attempt < maxRetries {
-			time.Sleep(baseDelay * time.Duration(attempt))
+			if err := sleep(ctx, baseDelay*time.Duration(attempt)); err != nil {
+				return fmt.Errorf("webhook wasn't sent before the run's deadline: %w", err)
+			}
 		}
 	}
 
@@ -306,7 +793,7 @@ func sendWebhook(logger *slog.Logger, client *http.Client, url string, jsonData
 }
 
 // notifyWebhooks sends notifications to all configured webhooks concurrently
-func notifyWebhooks(logger *slog.Logger, client *http.Client, webhooks []string, recordName string, recordType string, ipAddress string) {
+func notifyWebhooks(ctx context.Context, logger *slog.Logger, client *http.Client, webhooks []Endpoint, recordName string, recordType string, ipAddress string) {
 	logger = logger.With("component", "webhook")
 	if len(webhooks) == 0 {
 		return
@@ -316,11 +803,12 @@ func notifyWebhooks(logger *slog.Logger, client *http.Client, webhooks []string,
 		"webhook_count", len(webhooks))
 
 	var wg sync.WaitGroup
-	for _, webhookURL := range webhooks {
+	for _, webhook := range webhooks {
 		wg.Add(1)
-		go func(url string, logger *slog.Logger) {
+		go func(webhook Endpoint, logger *slog.Logger) {
 			defer wg.Done()
 
+			url := webhook.URL
 			logger = logger.With("url", url)
 
 			var jsonData []byte
@@ -355,14 +843,20 @@ func notifyWebhooks(logger *slog.Logger, client *http.Client, webhooks []string,
 				return
 			}
 
-			err = sendWebhook(logger, client, url, jsonData)
+			webhookClient, err := webhook.httpClient(client)
+			if err != nil {
+				logger.Error("Failed to create webhook client", "error", err)
+				return
+			}
+
+			err = sendWebhook(ctx, logger, webhookClient, &webhook, jsonData)
 
 			if err != nil {
 				logger.Error("Webhook notification failed", "error", err)
 			} else {
 				logger.Info("Webhook notification completed")
 			}
-		}(webhookURL, logger)
+		}(webhook, logger)
 	}
 
 	wg.Wait()
@@ -370,17 +864,52 @@ func notifyWebhooks(logger *slog.Logger, client *http.Client, webhooks []string,
 		"webhook_count", len(webhooks))
 }
 
+// checkFamily returns an error if the address isn't of the record type's
+// family, so an IPv6 address can't be put in an A record or the other way round.
+func checkFamily(address string, recordType string) error {
+	ip, err := netip.ParseAddr(address)
+	if err != nil {
+		return fmt.Errorf("%q isn't an IP address", address)
+	}
+	switch {
+	case recordType == "A" && ip.Is4In6():
+		return fmt.Errorf("%s is an IPv4 address written as IPv6, it must be written as %s", address, ip.Unmap())
+	case recordType == "A" && !ip.Is4():
+		return fmt.Errorf("%s is an IPv6 address, which can't be used for an A record", address)
+	case recordType == "AAAA" && (!ip.Is6() || ip.Is4In6()):
+		return fmt.Errorf("%s is an IPv4 address, which can't be used for an AAAA record", address)
+	}
+	return nil
+}
+
 // syncRecord ensures that the DNS record is up-to-date with the current IP address.
 // If the cached IP matches the current IP, skip update for this record.
 func syncRecord(
+	ctx context.Context,
 	logger *slog.Logger,
 	client *http.Client,
 	record *DNSRecord,
 	recordType string,
 	baseCachePath string,
 	currentIP string,
-) {
+) RecordStatus {
 	logger = logger.With("record_id", record.RecordID, "record_name", record.Name)
+	status := RecordStatus{
+		Name:     record.Name,
+		Type:     recordType,
+		RecordID: record.RecordID,
+		IP:       currentIP,
+	}
+
+	// Addresses are checked where they come from, but a record must never be
+	// pointed at an address of the wrong family, whatever the reason.
+	if err := checkFamily(currentIP, recordType); err != nil {
+		logger.Error("Refusing to update DNS record", "ip", currentIP, "error", err)
+		status.Result = recordFailed
+		status.Error = err.Error()
+		return status
+	}
+
 	cacheFileName := generateCacheFilename(record, recordType)
 	cachedIP, err := readCachedIP(baseCachePath, cacheFileName)
 	if err != nil {
@@ -391,23 +920,48 @@ func syncRecord(
 	// If cached IP address matches current IP address, skip update for this record
 	if cachedIP == currentIP {
 		logger.Info("IP address unchanged for record, skipping update", "ip", currentIP)
-		return
+		status.Result = recordUnchanged
+		return status
+	}
+
+	// Records that haven't been started when the run's deadline passes aren't
+	// started at all, whichever provider they use.
+	if err := context.Cause(ctx); err != nil {
+		logger.Error("Not updating DNS record", "error", err)
+		status.Result = recordFailed
+		status.Error = err.Error()
+		return status
 	}
 
 	logger.Info("Updating DNS record",
 		"old_ip", cachedIP,
 		"new_ip", currentIP)
 
-	err = updateCloudflareRecord(
+	err = updateRecord(
+		ctx,
 		client,
 		record,
 		recordType,
 		currentIP)
 
+	if err == nil && record.PTR != nil {
+		logger.Info("Updating PTR record", "ptr_record_id", record.PTR.RecordID)
+		if err = updatePTRRecord(ctx, client, record, currentIP); err != nil {
+			err = fmt.Errorf("failed to update PTR record: %w", err)
+		}
+	}
+
 	if err != nil {
-		logger.Error("Failed to update DNS record", "error", err)
+		if errors.Is(err, errCircuitOpen) {
+			logger.Warn("Skipped updating DNS record", "error", err)
+		} else {
+			logger.Error("Failed to update DNS record", "error", err)
+		}
+		status.Result = recordFailed
+		status.Error = err.Error()
 	} else {
 		logger.Info("Successfully updated DNS record", "ip", currentIP)
+		status.Result = recordUpdated
 
 		// Only cache IP for this record if the update was successful
 		if baseCachePath != "" {
@@ -424,6 +978,7 @@ func syncRecord(
 		// Send webhook notifications if configured
 		if len(record.Webhooks) > 0 {
 			notifyWebhooks(
+				ctx,
 				logger,
 				client,
 				record.Webhooks,
@@ -433,6 +988,8 @@ func syncRecord(
 			)
 		}
 	}
+
+	return status
 }
 
 type DNSUpdateConfig struct {
@@ -451,55 +1008,304 @@ type DNSUpdateConfig struct {
 	// which means that the DNS records will be updated every time, even
 	// if the IP address has not changed from the last run.
 	baseCachePath string
-	// ipAPIURL is the URL to use for fetching the current IP address.
-	// It is expected to return a plain string containing only an IP address.
-	// It does not matter which form of address it returns.
-	ipAPIURL string
+	// ipSources are where the current IP address is fetched from, in the
+	// order they're tried.
+	ipSources IPSourceList
+	// consensus requires most of the IP sources to agree on the address,
+	// instead of using the first one that returns it.
+	consensus bool
+	// health is how well each IP source has worked before. If it's set, the
+	// sources are tried in order of it, and it's updated with the results.
+	health *sourceHealth
+	// address is the current address given with -ip, which is used instead of
+	// looking it up if it's set.
+	address string
+	// updates has room for as many records as can be updated at the same
+	// time, and is shared by every record type.
+	updates chan struct{}
+	// stopping is closed when clouddns is told to stop, after which no more
+	// records are started.
+	stopping <-chan struct{}
 }
 
-func syncRecordsToIPAddress(config DNSUpdateConfig) {
+// syncRecordsToIPAddress syncs every record in the configuration, returning
+// the outcome for each record in the same order.
+func syncRecordsToIPAddress(ctx context.Context, config DNSUpdateConfig) []RecordStatus {
 	logger := config.logger.With("record_type", config.recordType)
 	logger.Info("Beginning update for records", "count", len(config.records))
 
-	currentIP, err := getCurrentIP(config.client, config.ipAPIURL)
-	if err != nil {
-		logger.Error("Failed to get current IP address", "error", err)
-		return
+	statuses := make([]RecordStatus, len(config.records))
+
+	// Records with a static IP address don't need the current one, and records
+	// can have their own sources, so each list of sources is only looked up
+	// once, and only if at least one record uses it.
+	type lookupResult struct {
+		address string
+		err     error
+	}
+	lookups := map[string]lookupResult{}
+	// offline is set once the machine is found to be offline, so the rest of
+	// the records fail without trying their sources or logging again.
+	var offline error
+	lookupIP := func(sources IPSourceList, allowPrivate bool) (string, error) {
+		if offline != nil {
+			return "", offline
+		}
+		data, _ := json.Marshal(sources)
+		key := fmt.Sprintf("%t %s", allowPrivate, data)
+		if result, ok := lookups[key]; ok {
+			return result.address, result.err
+		}
+		// While the machine is still offline from the last run, the sources
+		// aren't tried at all, so each run only logs a single line.
+		if !offlineSince(config.baseCachePath, config.recordType).IsZero() {
+			if offline = lookupOffline(ctx, logger, config.baseCachePath, config.recordType, nil); offline != nil {
+				return "", offline
+			}
+		}
+		lookup := sources.currentIP
+		if config.consensus && len(sources) > 1 {
+			lookup = sources.consensusIP
+		}
+		address, err := lookup(ctx, logger, config.client, config.recordType, allowPrivate, config.health)
+		if err != nil {
+			if offline = lookupOffline(ctx, logger, config.baseCachePath, config.recordType, err); offline != nil {
+				return "", offline
+			}
+			logger.Error("Failed to get current IP address", "sources", sources.describe(), "error", err)
+			err = fmt.Errorf("failed to get current IP address: %w", err)
+		} else {
+			markOnline(logger, config.baseCachePath, config.recordType)
+		}
+		lookups[key] = lookupResult{address, err}
+		return address, err
+	}
+	if config.address != "" {
+		logger.Info("Using the IP address given on the command line", "ip", config.address)
+		// The address is checked like one from an IP source, since a typo
+		// shouldn't be able to point the records somewhere else.
+		lookupIP = func(_ IPSourceList, allowPrivate bool) (string, error) {
+			if !allowPrivate {
+				if checkPublic(config.address) != nil {
+					err := fmt.Errorf("%s isn't a public address, so it can only be used for records with allow_private", config.address)
+					logger.Error("Refusing to use the IP address given on the command line", "error", err)
+					return "", err
+				}
+			}
+			return config.address, nil
+		}
 	}
 
 	var wg sync.WaitGroup
 
 	for i := range config.records {
+		syncProgressFrom(ctx).made()
+		record := &config.records[i]
+		skip := func() {
+			logger.Warn("Skipped updating DNS record", "record_name", record.Name, "error", errStopping)
+			statuses[i] = RecordStatus{
+				Name:     record.Name,
+				Type:     config.recordType,
+				RecordID: record.RecordID,
+				Result:   recordFailed,
+				Error:    errStopping.Error(),
+			}
+		}
+		if isClosed(config.stopping) {
+			skip()
+			continue
+		}
+
+		address := record.IP
+		if address == "" {
+			sources := record.IPSources
+			if len(sources) == 0 {
+				sources = config.ipSources
+			}
+			var err error
+			allowPrivate := record.AllowPrivate != nil && *record.AllowPrivate
+			if address, err = lookupIP(sources, allowPrivate); err != nil {
+				// If the current IP can't be found, the record can't be synced.
+				statuses[i] = RecordStatus{
+					Name:     record.Name,
+					Type:     config.recordType,
+					RecordID: record.RecordID,
+					Result:   recordFailed,
+					Error:    err.Error(),
+				}
+				continue
+			}
+			if record.IPv6Suffix != "" {
+				if address, err = withIPv6Suffix(address, record.IPv6Suffix, record.PrefixLength); err != nil {
+					statuses[i] = RecordStatus{
+						Name:     record.Name,
+						Type:     config.recordType,
+						RecordID: record.RecordID,
+						Result:   recordFailed,
+						Error:    err.Error(),
+					}
+					continue
+				}
+			}
+		}
+
+		// Static addresses and ones given on the command line are set on
+		// purpose, so only looked up ones need to be confirmed.
+		if record.IP == "" && config.address == "" {
+			address = confirmedIP(logger, record, config.recordType, config.baseCachePath, address)
+		}
+
+		// Waiting for a record to finish stops as soon as clouddns is told to
+		// stop, so that the rest are skipped instead of started.
+		select {
+		case config.updates <- struct{}{}:
+			if isClosed(config.stopping) {
+				<-config.updates
+				skip()
+				continue
+			}
+		case <-config.stopping:
+			skip()
+			continue
+		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			syncRecord(
+			defer func() { <-config.updates }()
+			statuses[i] = syncRecord(
+				ctx,
 				logger,
 				config.client,
-				&config.records[i],
+				record,
 				config.recordType,
 				config.baseCachePath,
-				currentIP,
+				address,
 			)
+			syncProgressFrom(ctx).made()
 		}()
 	}
 
 	wg.Wait()
+	return statuses
 }
 
-func run(logger *slog.Logger) error {
+// defaultRunTimeout is how long a run can take before whatever hasn't finished
+// is given up on, unless DDNS_RUN_TIMEOUT says otherwise.
+const defaultRunTimeout = 5 * time.Minute
+
+// runTimeout returns the deadline for each run from DDNS_RUN_TIMEOUT, which
+// can be 0 to never give up.
+func runTimeout() (time.Duration, error) {
+	value := os.Getenv("DDNS_RUN_TIMEOUT")
+	if value == "" {
+		return defaultRunTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid DDNS_RUN_TIMEOUT %q, it must be a duration like 2m", value)
+	}
+	return timeout, nil
+}
+
+// run syncs every configured record once, returning the outcome for each record.
+func run(ctx context.Context, logger *slog.Logger) (*RunStatus, error) {
 	logger.Info("Starting DDNS client")
 
+	configuration, err := loadDNSConfiguration(logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	logger.Info("Loaded configuration")
+
+	return runConfiguration(ctx, logger, &configuration)
+}
+
+// runConfiguration syncs every record in an already loaded configuration once,
+// giving up on whatever hasn't finished when the run's deadline passes. When
+// the context is done, no more records are started, and the ones that have
+// been are given stopGracePeriod to finish.
+func runConfiguration(ctx context.Context, logger *slog.Logger, configuration *DNSConfiguration) (*RunStatus, error) {
 	baseCachePath := getCachePath()
 	logger.Info("Cache path", "path", baseCachePath)
 
-	configuration, err := loadDNSConfiguration()
+	// Requests that have started aren't cancelled by the context, so an update
+	// isn't cut off halfway, unless they're still going after the grace period.
+	stopping := ctx
+	ctx, cancelRun := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer cancelRun(nil)
+	defer context.AfterFunc(stopping, func() {
+		time.AfterFunc(stopGracePeriod, func() { cancelRun(errStopping) })
+	})()
+
+	timeout, err := runTimeout()
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+		return nil, err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("the run took longer than %s", timeout))
+		defer cancel()
 	}
-	logger.Info("Loaded configuration")
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client, err := newHTTPClient(configuration.TLS, configuration.HTTP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP client: %w", err)
+	}
+	if configuration.Bind != nil {
+		if client, err = withBinding(client, configuration.Bind); err != nil {
+			return nil, fmt.Errorf("failed to create HTTP client: %w", err)
+		}
+	}
+
+	if shouldAudit() {
+		audit := &auditLog{}
+		client = withAudit(client, audit)
+		ctx = withAuditLog(ctx, audit)
+		defer audit.report(logger)
+	}
+
+	ipv4Sources := configuration.IPSources.A
+	if len(ipv4Sources) == 0 {
+		ipv4Sources = defaultIPSources("A")
+	}
+	ipv6Sources := configuration.IPSources.AAAA
+	if len(ipv6Sources) == 0 {
+		ipv6Sources = defaultIPSources("AAAA")
+	}
+
+	if configuration.Ping != nil {
+		ping(logger, client, configuration.Ping, "/start", "")
+	}
+
+	var health *sourceHealth
+	if configuration.IPSources.Adaptive {
+		if health, err = loadSourceHealth(baseCachePath); err != nil {
+			logger.Warn("Failed to load IP source health, treating every source as healthy", "error", err)
+		}
+	}
+
+	breaker := &circuitBreaker{}
+	ctx = withRateLimit(withBreaker(ctx, breaker), &rateLimit{})
+	discovery, err := loadDiscoveryCache(baseCachePath)
+	if err != nil {
+		logger.Warn("Failed to load discovered IDs, looking them up again", "error", err)
+	}
+	ctx = withDiscovery(ctx, discovery)
+	concurrency := configuration.Concurrency
+	if concurrency < 0 {
+		return nil, fmt.Errorf("invalid concurrency %d, it must be at least 1", concurrency)
+	} else if concurrency == 0 {
+		concurrency = defaultConcurrency
+	}
+	updates := make(chan struct{}, concurrency)
+
+	status := RunStatus{StartTime: time.Now()}
+	var statusMu sync.Mutex
+	addStatuses := func(statuses []RecordStatus) {
+		statusMu.Lock()
+		defer statusMu.Unlock()
+		status.Records = append(status.Records, statuses...)
+	}
 
 	var wg sync.WaitGroup
 
@@ -508,14 +1314,19 @@ func run(logger *slog.Logger) error {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			syncRecordsToIPAddress(DNSUpdateConfig{
+			addStatuses(syncRecordsToIPAddress(ctx, DNSUpdateConfig{
 				logger:        logger,
 				client:        client,
 				records:       configuration.A,
 				recordType:    "A",
 				baseCachePath: baseCachePath,
-				ipAPIURL:      "https://api.ipify.org",
-			})
+				ipSources:     ipv4Sources,
+				consensus:     configuration.IPSources.Consensus,
+				health:        health,
+				address:       os.Getenv("DDNS_IPV4"),
+				updates:       updates,
+				stopping:      stopping.Done(),
+			}))
 		}()
 	}
 
@@ -524,27 +1335,185 @@ func run(logger *slog.Logger) error {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			syncRecordsToIPAddress(DNSUpdateConfig{
+			addStatuses(syncRecordsToIPAddress(ctx, DNSUpdateConfig{
 				logger:        logger,
 				client:        client,
 				records:       configuration.AAAA,
 				recordType:    "AAAA",
 				baseCachePath: baseCachePath,
-				ipAPIURL:      "https://api6.ipify.org",
-			})
+				ipSources:     ipv6Sources,
+				consensus:     configuration.IPSources.Consensus,
+				health:        health,
+				address:       os.Getenv("DDNS_IPV6"),
+				updates:       updates,
+				stopping:      stopping.Done(),
+			}))
+		}()
+	}
+
+	if shouldVerifyTokens() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			verifyTokens(ctx, logger, client, configuration)
 		}()
 	}
 
 	wg.Wait()
+	breaker.logSummary(logger)
+
+	if health != nil && baseCachePath != "" {
+		if err := health.save(baseCachePath); err != nil {
+			logger.Warn("Failed to save IP source health", "error", err)
+		}
+	}
+	if baseCachePath != "" {
+		if err := discovery.save(baseCachePath); err != nil {
+			logger.Warn("Failed to save discovered IDs", "error", err)
+		}
+	}
+
+	status.FinishTime = time.Now()
+	if baseCachePath != "" {
+		if err := writeRunStatus(baseCachePath, &status); err != nil {
+			logger.Warn("Failed to write status file", "error", err)
+		}
+	}
+	if configuration.Ping != nil {
+		suffix := ""
+		if status.failedRecords() > 0 {
+			suffix = "/fail"
+		}
+		ping(logger, client, configuration.Ping, suffix, pingSummary(&status))
+	}
 
 	logger.Info("DDNS client finished")
-	return nil
+	return &status, nil
 }
 
 func main() {
 	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
 
-	if err := run(logger); err != nil {
+	if shouldLogToEventLog() {
+		handler, err := withEventLog(logger.Handler())
+		if err != nil {
+			logger.Error("Failed to open the Windows Event Log", "error", err)
+		} else {
+			logger = slog.New(handler)
+		}
+	}
+
+	flags := flag.NewFlagSet("clouddns", flag.ExitOnError)
+	configPath := flags.String("config", "", "path to the configuration file or directory (overrides DDNS_CONFIG_PATH)")
+	groups := flags.String("group", "", "comma-separated groups of records to sync (overrides DDNS_GROUPS)")
+	ip := flags.String("ip", "", "point the records of the address's family at it instead of looking it up")
+	ipv4 := flags.String("ipv4", "", "point A records at this address instead of looking it up (overrides DDNS_IPV4)")
+	ipv6 := flags.String("ipv6", "", "point AAAA records at this address instead of looking it up (overrides DDNS_IPV6)")
+	loop := flags.Bool("daemon", false, "keep running and sync on an interval (overrides DDNS_DAEMON)")
+	interval := flags.Duration("interval", 0, "time between syncs when running as a daemon, implies -daemon (overrides DDNS_INTERVAL)")
+	schedule := flags.String("schedule", "", "cron expression for when to sync instead of an interval, implies -daemon (overrides DDNS_SCHEDULE)")
+	timeout := flags.Duration("run-timeout", 0, "give up on whatever hasn't finished when a run takes this long (overrides DDNS_RUN_TIMEOUT)")
+	flags.Parse(os.Args[1:])
+	// Everything that reads the configuration, including the services that the
+	// install command writes, finds these settings through the environment.
+	if *configPath != "" {
+		os.Setenv("DDNS_CONFIG_PATH", *configPath)
+	}
+	if *groups != "" {
+		os.Setenv("DDNS_GROUPS", *groups)
+	}
+	if *loop {
+		os.Setenv("DDNS_DAEMON", "true")
+	}
+	if *interval != 0 {
+		os.Setenv("DDNS_INTERVAL", interval.String())
+	}
+	if *schedule != "" {
+		os.Setenv("DDNS_SCHEDULE", *schedule)
+	}
+	if *timeout != 0 {
+		os.Setenv("DDNS_RUN_TIMEOUT", timeout.String())
+	}
+	if *ip != "" {
+		if *ipv4 != "" || *ipv6 != "" {
+			logger.Error("-ip can't be used with -ipv4 or -ipv6, which set the address of each family explicitly")
+			os.Exit(2)
+		}
+		address, err := netip.ParseAddr(*ip)
+		if err != nil {
+			logger.Error("Invalid -ip address", "ip", *ip)
+			os.Exit(2)
+		}
+		if address.Unmap().Is4() {
+			*ipv4 = address.Unmap().String()
+		} else {
+			*ipv6 = address.String()
+		}
+	}
+	for _, explicit := range []struct{ flag, variable, recordType, address string }{
+		{"-ipv4", "DDNS_IPV4", "A", *ipv4},
+		{"-ipv6", "DDNS_IPV6", "AAAA", *ipv6},
+	} {
+		if explicit.address == "" {
+			continue
+		}
+		if err := checkFamily(explicit.address, explicit.recordType); err != nil {
+			logger.Error("Invalid "+explicit.flag+" address", "error", err)
+			os.Exit(2)
+		}
+		os.Setenv(explicit.variable, explicit.address)
+	}
+
+	if args := flags.Args(); len(args) > 0 {
+		var err error
+		switch args[0] {
+		case "service":
+			err = serviceCommand(logger, args[1:])
+		case "install":
+			err = installCommand(logger, args[1:])
+		case "controller":
+			err = controllerCommand(logger, args[1:])
+		case "trigger":
+			err = triggerCommand(logger, args[1:])
+		case "healthcheck":
+			err = healthcheckCommand(logger, args[1:])
+		case "bootstrap":
+			err = bootstrapCommand(logger, args[1:])
+		case "eventlog":
+			err = eventLogCommand(logger, args[1:])
+		case "validate":
+			err = validateCommand(logger, args[1:])
+		case "init":
+			err = initCommand(logger, args[1:])
+		case "import":
+			err = importCommand(logger, args[1:])
+		case "dyndns2-server":
+			err = dyndns2ServerCommand(logger, args[1:])
+		default:
+			logger.Error("Unknown command", "command", args[0])
+			os.Exit(2)
+		}
+		if err != nil {
+			logger.Error("Command failed", "command", args[0], "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if shouldRunDaemon() {
+		if err := runDaemon(logger); err != nil {
+			logger.Error("Application failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// When clouddns is told to stop, the records that are being updated are
+	// allowed to finish, so that a record isn't updated without its cache
+	// file being written, but no more are started.
+	ctx, stop := stopContext(logger)
+	defer stop()
+	if _, err := run(ctx, logger); err != nil {
 		logger.Error("Application failed", "error", err)
 		os.Exit(1)
 	}
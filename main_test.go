@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestCacheKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		record DNSRecord
+	}{
+		{"cloudflare with an ID", DNSRecord{Name: "home.example.com", ZoneID: "zone", RecordID: "record"}},
+		{"cloudflare discovered", DNSRecord{Name: "home.example.com", ZoneID: "zone"}},
+		{"cloudflare in another zone", DNSRecord{Name: "home.example.com", ZoneID: "other"}},
+		{"rfc2136", DNSRecord{Name: "home.example.com", Provider: "rfc2136", RFC2136: &RFC2136{Server: "10.0.0.53", Zone: "example.com"}}},
+		{"rfc2136 on another server", DNSRecord{Name: "home.example.com", Provider: "rfc2136", RFC2136: &RFC2136{Server: "10.0.1.53", Zone: "example.com"}}},
+		{"google", DNSRecord{Name: "home.example.com", Provider: "google", Google: &GoogleCloudDNS{Project: "p", ManagedZone: "z"}}},
+		{"linode", DNSRecord{Name: "home.example.com", Provider: "linode", Linode: &LinodeDNS{DomainID: "1", RecordID: "2"}}},
+		{"linode record", DNSRecord{Name: "home.example.com", Provider: "linode", Linode: &LinodeDNS{DomainID: "1", RecordID: "3"}}},
+		{"vultr", DNSRecord{Name: "home.example.com", Provider: "vultr", Vultr: &VultrDNS{Domain: "example.com", RecordID: "2"}}},
+		{"gandi", DNSRecord{Name: "home.example.com", Provider: "gandi", Gandi: &GandiLiveDNS{Domain: "example.com"}}},
+		{"godaddy", DNSRecord{Name: "home.example.com", Provider: "godaddy", GoDaddy: &GoDaddyDNS{Domain: "example.com"}}},
+		{"powerdns", DNSRecord{Name: "home.example.com", Provider: "powerdns", PowerDNS: &PowerDNS{URL: "http://pdns", Zone: "example.com"}}},
+		{"dyndns2", DNSRecord{Name: "home.example.com", Provider: "dyndns2", DynDNS2: &DynDNS2{Server: "dyn.example", Username: "u"}}},
+		{"dnsomatic", DNSRecord{Name: "home.example.com", Provider: "dnsomatic", DNSOMatic: &DNSOMatic{Username: "u"}}},
+		{"exec", DNSRecord{Name: "home.example.com", Provider: "exec", Exec: &ExecProvider{Command: []string{"update-dns"}}}},
+		{"another name", DNSRecord{Name: "office.example.com", Provider: "exec", Exec: &ExecProvider{Command: []string{"update-dns"}}}},
+	}
+
+	seen := map[string]string{}
+	for _, test := range tests {
+		for _, recordType := range []string{"A", "AAAA"} {
+			key := cacheKey(&test.record, recordType)
+			if other, ok := seen[key]; ok {
+				t.Errorf("%s %s has the same key as %s: %s", test.name, recordType, other, key)
+			}
+			seen[key] = test.name + " " + recordType
+		}
+	}
+
+	// Cloudflare records with an ID keep the key from before providers were
+	// added, so upgrading doesn't lose their cache.
+	record := DNSRecord{Name: "home.example.com", ZoneID: "zone", RecordID: "record"}
+	if got, want := cacheKey(&record, "A"), "A_home_example_com_record"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Credentials aren't part of the key, so rotating them keeps the cache.
+	withSecret := DNSRecord{Name: "home.example.com", Provider: "rfc2136", RFC2136: &RFC2136{Server: "10.0.0.53", Zone: "example.com", TSIGSecret: "a"}}
+	rotated := withSecret
+	rotated.RFC2136 = &RFC2136{Server: "10.0.0.53", Zone: "example.com", TSIGSecret: "b"}
+	if cacheKey(&withSecret, "A") != cacheKey(&rotated, "A") {
+		t.Error("the key changed with the TSIG secret")
+	}
+}
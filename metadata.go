@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MetadataSource reads the instance's public address from the metadata
+// service of the cloud it runs in, which answers instantly and doesn't depend
+// on any service outside the cloud.
+type MetadataSource struct {
+	// Provider is the cloud, which is "aws", "gcp", "azure", "hetzner", or
+	// "digitalocean".
+	Provider string `json:"provider"`
+}
+
+// metadataHost is the link-local address that every supported cloud serves
+// instance metadata on. Google Cloud also serves it there, but documents the
+// metadata.google.internal name instead.
+const metadataHost = "http://169.254.169.254"
+
+// metadataEndpoints are where each cloud reports the public addresses of the
+// instance's first network interface, and any headers the request needs.
+var metadataEndpoints = map[string]struct {
+	a, aaaa string
+	headers map[string]string
+}{
+	"aws": {
+		a:    metadataHost + "/latest/meta-data/public-ipv4",
+		aaaa: metadataHost + "/latest/meta-data/ipv6",
+	},
+	"gcp": {
+		a:       "http://metadata.google.internal/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip",
+		aaaa:    "http://metadata.google.internal/computeMetadata/v1/instance/network-interfaces/0/ipv6s",
+		headers: map[string]string{"Metadata-Flavor": "Google"},
+	},
+	"azure": {
+		a:       metadataHost + "/metadata/instance/network/interface/0/ipv4/ipAddress/0/publicIpAddress?api-version=2021-02-01&format=text",
+		aaaa:    metadataHost + "/metadata/instance/network/interface/0/ipv6/ipAddress/0/publicIpAddress?api-version=2021-02-01&format=text",
+		headers: map[string]string{"Metadata": "true"},
+	},
+	"hetzner": {
+		a: metadataHost + "/hetzner/v1/metadata/public-ipv4",
+	},
+	"digitalocean": {
+		a:    metadataHost + "/metadata/v1/interfaces/public/0/ipv4/address",
+		aaaa: metadataHost + "/metadata/v1/interfaces/public/0/ipv6/address",
+	},
+}
+
+// currentIP returns the instance's public address for the record type.
+func (s *MetadataSource) currentIP(ctx context.Context, client *http.Client, recordType string) (string, error) {
+	endpoints, ok := metadataEndpoints[s.Provider]
+	if !ok {
+		return "", fmt.Errorf("unknown metadata provider %q", s.Provider)
+	}
+	url := endpoints.a
+	if recordType == "AAAA" {
+		url = endpoints.aaaa
+	}
+	if url == "" {
+		return "", fmt.Errorf("the %s metadata service doesn't report addresses for %s records", s.Provider, recordType)
+	}
+
+	// The metadata service is only reachable directly, never through a proxy,
+	// and it answers immediately if it's there at all.
+	client = withTransport(client, "no proxy", "", func(transport *http.Transport) {
+		transport.Proxy = nil
+	})
+	client.Timeout = 5 * time.Second
+
+	endpoint := &Endpoint{URL: url, Headers: endpoints.headers}
+	if s.Provider == "aws" {
+		// IMDSv2 needs a session token, and some instances don't allow
+		// requests without one.
+		token, err := awsMetadataToken(client)
+		if err != nil {
+			return "", fmt.Errorf("aws metadata: %w", err)
+		}
+		endpoint.Headers = map[string]string{"X-aws-ec2-metadata-token": token}
+	}
+
+	address, err := getCurrentIP(ctx, client, endpoint)
+	if err != nil {
+		return "", fmt.Errorf("%s metadata: %w", s.Provider, err)
+	}
+	// Interfaces with more than one IPv6 address list them on separate lines.
+	address, _, _ = strings.Cut(address, "\n")
+	if address == "" {
+		return "", fmt.Errorf("%s metadata: the instance has no public address for %s records", s.Provider, recordType)
+	}
+	return strings.TrimSpace(address), nil
+}
+
+// awsMetadataToken requests a short-lived session token for the EC2 instance
+// metadata service.
+func awsMetadataToken(client *http.Client) (string, error) {
+	req, err := http.NewRequest("PUT", metadataHost+"/latest/api/token", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withPurpose(req, "ip_lookup")
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request session token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("session token request returned status code %d", resp.StatusCode)
+	}
+	token, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read session token: %w", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
@@ -0,0 +1,145 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+)
+
+// addressSettleTime is how long to wait after an address or route changes
+// before syncing, because reconnecting usually changes several of them in a
+// burst, and the new address should be in place by the time it's looked up.
+const addressSettleTime = 2 * time.Second
+
+// Multicast groups of rtnetlink messages, from linux/rtnetlink.h. The syscall
+// package doesn't define them.
+const (
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6IfAddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// rtScopeUniverse is the scope of addresses that aren't link-local or
+// loopback, which are the only ones that can be public.
+const rtScopeUniverse = 0
+
+// addressChanged reports whether the netlink message describes a change that
+// could mean the public address changed: a global address being added or
+// removed, or the default route changing.
+func addressChanged(message syscall.NetlinkMessage) bool {
+	switch message.Header.Type {
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		if len(message.Data) < syscall.SizeofIfAddrmsg {
+			return false
+		}
+		// The scope is the fourth byte of struct ifaddrmsg.
+		return message.Data[3] == rtScopeUniverse
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		if len(message.Data) < syscall.SizeofRtMsg {
+			return false
+		}
+		// Only the default route matters, which has a destination length of 0.
+		// It's the second byte of struct rtmsg.
+		return message.Data[1] == 0
+	}
+	return false
+}
+
+// subscribeToAddresses opens a netlink socket and calls trigger whenever an
+// address or the default route changes, until reading from the socket fails
+// or the context is done.
+func subscribeToAddresses(ctx context.Context, logger *slog.Logger, trigger func(reason string)) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+	groups := uint32(rtmgrpIPv4IfAddr | rtmgrpIPv4Route | rtmgrpIPv6IfAddr | rtmgrpIPv6Route)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("failed to subscribe to address changes: %w", err)
+	}
+
+	// Wrapping the non-blocking socket in a file lets reads be interrupted by
+	// closing it.
+	socket := os.NewFile(uintptr(fd), "netlink")
+	defer socket.Close()
+	stop := context.AfterFunc(ctx, func() { socket.Close() })
+	defer stop()
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		var pending <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+				pending = time.After(addressSettleTime)
+			case <-pending:
+				pending = nil
+				trigger("address_changed")
+			}
+		}
+	}()
+
+	logger.Info("Watching for address changes")
+	buf := make([]byte, 65536)
+	for {
+		n, err := socket.Read(buf)
+		if errors.Is(err, syscall.ENOBUFS) {
+			// Messages were dropped because they arrived faster than they were
+			// read, so one of them might have been a change.
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read from netlink socket: %w", err)
+		}
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			logger.Warn("Failed to parse netlink message", "error", err)
+			continue
+		}
+		for _, message := range messages {
+			if addressChanged(message) {
+				logger.Debug("Address or route changed", "type", message.Header.Type)
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// watchAddresses subscribes to address changes, opening the socket again if
+// reading from it fails, until the context is done.
+func watchAddresses(ctx context.Context, logger *slog.Logger, trigger func(reason string)) {
+	logger = logger.With("component", "netlink")
+	for {
+		err := subscribeToAddresses(ctx, logger, trigger)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Error("Failed to watch for address changes", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
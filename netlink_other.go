@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+func watchAddresses(ctx context.Context, logger *slog.Logger, trigger func(reason string)) {
+	logger.Error("Watching for address changes is only supported on Linux")
+}
@@ -0,0 +1,314 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This is a minimal D-Bus client that only supports what's needed to
+// subscribe to signals: authenticating, calling methods with string arguments,
+// and reading the headers of incoming messages.
+
+const systemBusPath = "/run/dbus/system_bus_socket"
+
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+	dbusSignal       = 4
+)
+
+const (
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSignature   = 8
+)
+
+// nmStateConnectedGlobal is the NetworkManager state for full internet connectivity.
+const nmStateConnectedGlobal = 70
+
+// networkMatchRules select the signals that are sent when connectivity changes.
+var networkMatchRules = []string{
+	"type='signal',sender='org.freedesktop.NetworkManager',interface='org.freedesktop.NetworkManager',member='StateChanged'",
+	"type='signal',sender='org.freedesktop.network1',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',path='/org/freedesktop/network1'",
+}
+
+type dbusMessage struct {
+	order  binary.ByteOrder
+	kind   byte
+	fields map[byte]any
+	body   []byte
+}
+
+type dbusConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	serial uint32
+}
+
+func dialSystemBus() (*dbusConn, error) {
+	path := systemBusPath
+	if address, ok := strings.CutPrefix(os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"), "unix:path="); ok {
+		path, _, _ = strings.Cut(address, ",")
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the system bus: %w", err)
+	}
+	c := &dbusConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := io.WriteString(conn, "\x00AUTH EXTERNAL "+uid+"\r\n"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to authenticate with the system bus: %w", err)
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "OK ") {
+		conn.Close()
+		return nil, fmt.Errorf("failed to authenticate with the system bus: %q %v", strings.TrimSpace(line), err)
+	}
+	if _, err := io.WriteString(conn, "BEGIN\r\n"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to authenticate with the system bus: %w", err)
+	}
+
+	if err := c.call("Hello"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// call calls a method on the bus itself and waits for the reply.
+func (c *dbusConn) call(member string, args ...string) error {
+	c.serial++
+	serial := c.serial
+
+	var body bytes.Buffer
+	for _, arg := range args {
+		writeDBusString(&body, arg)
+	}
+
+	var fields bytes.Buffer
+	writeDBusField(&fields, dbusFieldPath, "o", "/org/freedesktop/DBus")
+	writeDBusField(&fields, dbusFieldInterface, "s", "org.freedesktop.DBus")
+	writeDBusField(&fields, dbusFieldMember, "s", member)
+	writeDBusField(&fields, dbusFieldDestination, "s", "org.freedesktop.DBus")
+	if len(args) > 0 {
+		writeDBusField(&fields, dbusFieldSignature, "g", strings.Repeat("s", len(args)))
+	}
+
+	var message bytes.Buffer
+	message.Write([]byte{'l', dbusMethodCall, 0, 1})
+	binary.Write(&message, binary.LittleEndian, uint32(body.Len()))
+	binary.Write(&message, binary.LittleEndian, serial)
+	binary.Write(&message, binary.LittleEndian, uint32(fields.Len()))
+	message.Write(fields.Bytes())
+	padDBus(&message, 8)
+	message.Write(body.Bytes())
+
+	if _, err := c.conn.Write(message.Bytes()); err != nil {
+		return fmt.Errorf("failed to call %s: %w", member, err)
+	}
+
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return fmt.Errorf("failed to call %s: %w", member, err)
+		}
+		if reply.fields[dbusFieldReplySerial] != serial {
+			continue
+		}
+		if reply.kind == dbusError {
+			return fmt.Errorf("failed to call %s: %v", member, reply.fields[dbusFieldErrorName])
+		}
+		return nil
+	}
+}
+
+// read reads the next message. Only the header fields that hold strings or
+// integers are decoded, which is all of the standard ones.
+func (c *dbusConn) read() (*dbusMessage, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'B' {
+		order = binary.BigEndian
+	}
+	bodyLength := order.Uint32(header[4:8])
+	fieldsLength := order.Uint32(header[12:16])
+
+	fieldsEnd := 16 + int(fieldsLength)
+	rest := make([]byte, (fieldsEnd+7)/8*8-16+int(bodyLength))
+	if _, err := io.ReadFull(c.reader, rest); err != nil {
+		return nil, err
+	}
+
+	message := &dbusMessage{
+		order:  order,
+		kind:   header[1],
+		fields: map[byte]any{},
+		body:   rest[len(rest)-int(bodyLength):],
+	}
+
+	// Offsets are relative to the start of the message, because values are
+	// aligned relative to it.
+	data := append(header, rest[:fieldsLength]...)
+	offset := 16
+	for offset < fieldsEnd {
+		offset = (offset + 7) / 8 * 8
+		if offset+2 > fieldsEnd {
+			break
+		}
+		code := data[offset]
+		signatureLength := int(data[offset+1])
+		if offset+3+signatureLength > fieldsEnd {
+			break
+		}
+		signature := string(data[offset+2 : offset+2+signatureLength])
+		offset += 3 + signatureLength
+
+		switch signature {
+		case "s", "o":
+			offset = (offset + 3) / 4 * 4
+			length := int(order.Uint32(data[offset:]))
+			message.fields[code] = string(data[offset+4 : offset+4+length])
+			offset += 4 + length + 1
+		case "g":
+			length := int(data[offset])
+			message.fields[code] = string(data[offset+1 : offset+1+length])
+			offset += 1 + length + 1
+		case "u":
+			offset = (offset + 3) / 4 * 4
+			message.fields[code] = order.Uint32(data[offset:])
+			offset += 4
+		default:
+			return nil, fmt.Errorf("unsupported header field signature %q", signature)
+		}
+	}
+
+	return message, nil
+}
+
+func padDBus(buf *bytes.Buffer, alignment int) {
+	for buf.Len()%alignment != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+func writeDBusString(buf *bytes.Buffer, value string) {
+	padDBus(buf, 4)
+	binary.Write(buf, binary.LittleEndian, uint32(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte(0)
+}
+
+// writeDBusField writes a header field. The fields are written to their own
+// buffer, which works because it starts at an offset that's a multiple of 8.
+func writeDBusField(buf *bytes.Buffer, code byte, signature string, value string) {
+	padDBus(buf, 8)
+	buf.Write([]byte{code, byte(len(signature))})
+	buf.WriteString(signature)
+	buf.WriteByte(0)
+	if signature == "g" {
+		buf.WriteByte(byte(len(value)))
+		buf.WriteString(value)
+		buf.WriteByte(0)
+		return
+	}
+	writeDBusString(buf, value)
+}
+
+// connectivityChanged reports whether the signal means that the machine has
+// just connected to a network, and the reason to log for the sync.
+func connectivityChanged(message *dbusMessage) (string, bool) {
+	if message.kind != dbusSignal {
+		return "", false
+	}
+
+	switch {
+	case message.fields[dbusFieldInterface] == "org.freedesktop.NetworkManager" && message.fields[dbusFieldMember] == "StateChanged":
+		return "networkmanager", len(message.body) >= 4 && message.order.Uint32(message.body) == nmStateConnectedGlobal
+	case message.fields[dbusFieldPath] == "/org/freedesktop/network1" && message.fields[dbusFieldMember] == "PropertiesChanged":
+		// The properties of the manager all describe the state of the network,
+		// so any change is worth a sync.
+		return "networkd", true
+	}
+	return "", false
+}
+
+// subscribeToNetwork connects to the system bus and calls trigger whenever
+// NetworkManager or systemd-networkd report a connectivity change, until the
+// connection fails or the context is done.
+func subscribeToNetwork(ctx context.Context, logger *slog.Logger, trigger func(reason string)) error {
+	bus, err := dialSystemBus()
+	if err != nil {
+		return err
+	}
+	defer bus.conn.Close()
+
+	for _, rule := range networkMatchRules {
+		if err := bus.call("AddMatch", rule); err != nil {
+			return err
+		}
+	}
+
+	stop := context.AfterFunc(ctx, func() { bus.conn.Close() })
+	defer stop()
+
+	logger.Info("Watching for network changes")
+	for {
+		message, err := bus.read()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read from the system bus: %w", err)
+		}
+
+		if source, ok := connectivityChanged(message); ok {
+			logger.Info("Network connectivity changed", "source", source)
+			trigger("network_" + source)
+		}
+	}
+}
+
+// watchNetwork subscribes to connectivity changes, reconnecting to the system
+// bus if the connection is lost, until the context is done.
+func watchNetwork(ctx context.Context, logger *slog.Logger, trigger func(reason string)) {
+	logger = logger.With("component", "network")
+	for {
+		err := subscribeToNetwork(ctx, logger, trigger)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Error("Failed to watch for network changes", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+func watchNetwork(ctx context.Context, logger *slog.Logger, trigger func(reason string)) {
+	logger.Error("Watching for network changes is only supported on Linux")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// connectivityProbes are the addresses that are connected to when looking up
+// the current address fails, to tell whether the machine is offline. They're
+// Cloudflare's resolvers, which are about as reliably reachable as anything.
+var connectivityProbes = map[string]string{
+	"A":    "1.1.1.1:443",
+	"AAAA": "[2606:4700:4700::1111]:443",
+}
+
+// errOffline is returned instead of the errors of the IP sources when the
+// machine has no connection to the internet.
+var errOffline = errors.New("there's no internet connection")
+
+// isOnline reports whether a connection can be made to the internet over the
+// record type's family. A proxy might be the only way out, so the machine is
+// assumed to be online when one is configured.
+func isOnline(ctx context.Context, recordType string) bool {
+	req, _ := http.NewRequest("GET", "https://api.cloudflare.com", nil)
+	if proxy, err := http.ProxyFromEnvironment(req); err != nil || proxy != nil {
+		return true
+	}
+
+	network := "tcp4"
+	if recordType == "AAAA" {
+		network = "tcp6"
+	}
+	conn, err := auditDial(ctx, "connectivity_check", network, connectivityProbes[recordType], 3*time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// offlineFilename is the name of the file in the cache directory that exists
+// while the machine is offline for the record type, so that every run after
+// the first one that notices is quieter.
+func offlineFilename(recordType string) string {
+	return "offline_" + strings.ToLower(recordType) + ".txt"
+}
+
+// offlineSince returns when the machine was first found to be offline for the
+// record type, or the zero time if it wasn't offline at the last run.
+func offlineSince(baseCachePath string, recordType string) time.Time {
+	if baseCachePath == "" {
+		return time.Time{}
+	}
+	data, err := os.ReadFile(filepath.Join(baseCachePath, offlineFilename(recordType)))
+	if err != nil {
+		return time.Time{}
+	}
+	since, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}
+	}
+	return since
+}
+
+// lookupOffline is called when looking up the current address failed, or
+// before looking it up if the machine was offline at the last run. If the
+// machine is offline, it logs that quietly, and only as a warning the first
+// time, and returns errOffline. Otherwise it returns nil, and any failure is a
+// problem with the IP sources.
+func lookupOffline(ctx context.Context, logger *slog.Logger, baseCachePath string, recordType string, lookupErr error) error {
+	if isOnline(ctx, recordType) {
+		markOnline(logger, baseCachePath, recordType)
+		return nil
+	}
+
+	if since := offlineSince(baseCachePath, recordType); !since.IsZero() {
+		logger.Info("Still offline, skipping records", "offline_for", time.Since(since).Round(time.Second).String())
+		return errOffline
+	}
+	logger.Warn("There's no internet connection, skipping records until it's back", "error", lookupErr)
+	if baseCachePath != "" {
+		err := writeCachedIP(baseCachePath, offlineFilename(recordType), time.Now().UTC().Format(time.RFC3339))
+		if err != nil {
+			logger.Warn("Failed to record being offline", "error", err)
+		}
+	}
+	return errOffline
+}
+
+// markOnline removes the record of being offline for the record type, if there
+// is one, after the current address was looked up successfully.
+func markOnline(logger *slog.Logger, baseCachePath string, recordType string) {
+	since := offlineSince(baseCachePath, recordType)
+	if since.IsZero() {
+		return
+	}
+	if err := os.Remove(filepath.Join(baseCachePath, offlineFilename(recordType))); err != nil {
+		logger.Warn("Failed to record being back online", "error", fmt.Errorf("failed to remove file: %w", err))
+		return
+	}
+	logger.Info("Back online", "offline_for", time.Since(since).Round(time.Second).String())
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ping reports the progress of a run to a dead man's switch service like
+// healthchecks.io, which alerts when the pings stop arriving or a run fails.
+// The suffix is added to the URL's path: "/start" when a run starts, "/fail"
+// when one fails, and nothing when one succeeds. Failures are only logged,
+// because the service alerting is the point.
+func ping(logger *slog.Logger, client *http.Client, endpoint *Endpoint, suffix string, body string) {
+	logger = logger.With("component", "ping")
+
+	parsed, err := url.Parse(endpoint.URL)
+	if err != nil {
+		logger.Error("Invalid ping URL", "error", err)
+		return
+	}
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/") + suffix
+
+	req, err := http.NewRequest("POST", parsed.String(), strings.NewReader(body))
+	if err != nil {
+		logger.Error("Failed to create ping request", "error", err)
+		return
+	}
+	req = withPurpose(req, "ping")
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	endpoint.authorize(req)
+
+	pingClient, err := endpoint.httpClient(client)
+	if err != nil {
+		logger.Error("Failed to create ping client", "error", err)
+		return
+	}
+	resp, err := pingClient.Do(req)
+	if err != nil {
+		logger.Warn("Failed to send ping", "suffix", suffix, "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		logger.Warn("Ping returned non-OK status", "suffix", suffix, "status_code", resp.StatusCode)
+		return
+	}
+	logger.Debug("Sent ping", "suffix", suffix)
+}
+
+// pingSummary describes the outcome of a run for the body of the ping that
+// finishes it, which healthchecks.io shows alongside the ping.
+func pingSummary(status *RunStatus) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d records, %d failed\n", len(status.Records), status.failedRecords())
+	for _, record := range status.Records {
+		fmt.Fprintf(&sb, "%s %s: %s", record.Type, record.Name, record.Result)
+		if record.IP != "" {
+			fmt.Fprintf(&sb, " %s", record.IP)
+		}
+		if record.Error != "" {
+			fmt.Fprintf(&sb, " (%s)", record.Error)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
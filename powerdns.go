@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PowerDNS holds the settings for a record in a zone on a PowerDNS
+// Authoritative server, updated through its HTTP API.
+type PowerDNS struct {
+	// URL is the address of the API, such as http://pdns.internal:8081.
+	URL string `json:"url"`
+	// APIKey is the server's api-key setting.
+	APIKey string `json:"api_key"`
+	// APIKeyFile is the path to a file containing the API key, which is read
+	// when the configuration is loaded if APIKey isn't set.
+	APIKeyFile string `json:"api_key_file,omitempty"`
+	// ServerID is the ID of the server in the API. It's always "localhost"
+	// unless the API is behind something that manages several servers.
+	ServerID string `json:"server_id,omitempty"`
+	// Zone is the zone the record is in, such as example.com.
+	Zone string `json:"zone"`
+}
+
+// powerDNSPatch is the body of a request to change the RRsets in a zone.
+type powerDNSPatch struct {
+	RRSets []powerDNSRRSet `json:"rrsets"`
+}
+
+type powerDNSRRSet struct {
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	TTL        int              `json:"ttl"`
+	ChangeType string           `json:"changetype"`
+	Records    []powerDNSRecord `json:"records"`
+}
+
+type powerDNSRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+// update replaces the RRset with the record's name and type with the address,
+// creating it if it doesn't exist.
+func (p *PowerDNS) update(ctx context.Context, client *http.Client, record *DNSRecord, recordType string, address string) error {
+	serverID := p.ServerID
+	if serverID == "" {
+		serverID = "localhost"
+	}
+	// PowerDNS has no automatic TTL.
+	ttl := record.TTL
+	if ttl <= 1 {
+		ttl = 300
+	}
+	patch := powerDNSPatch{RRSets: []powerDNSRRSet{{
+		Name:       strings.TrimSuffix(record.Name, ".") + ".",
+		Type:       recordType,
+		TTL:        ttl,
+		ChangeType: "REPLACE",
+		Records:    []powerDNSRecord{{Content: address}},
+	}}}
+
+	zone := strings.TrimSuffix(p.Zone, ".") + "."
+	req, err := newJSONRequest(ctx, "PATCH", strings.TrimSuffix(p.URL, "/")+"/api/v1/servers/"+url.PathEscape(serverID)+
+		"/zones/"+url.PathEscape(zone), patch)
+	if err != nil {
+		return err
+	}
+	req = withPurpose(req, "dns_update")
+	req.Header.Set("X-API-Key", p.APIKey)
+	return doJSON(client, req, nil)
+}
+
+// validate returns a description of each problem with the settings for the
+// record.
+func (p *PowerDNS) validate(record *DNSRecord) []string {
+	var problems []string
+	if err := validateEndpoint(&Endpoint{URL: p.URL}); err != nil {
+		problems = append(problems, fmt.Sprintf("powerdns url: %v", err))
+	}
+	if strings.TrimSpace(p.APIKey) == "" {
+		problems = append(problems, "powerdns api_key is missing")
+	}
+	if strings.TrimSpace(p.Zone) == "" {
+		problems = append(problems, "powerdns zone is missing")
+	} else if _, err := relativeName(record.Name, p.Zone); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
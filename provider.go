@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// usesCloudflare reports whether the record is hosted on Cloudflare, which is
+// the default, rather than by another provider.
+func (r *DNSRecord) usesCloudflare() bool {
+	return r.Provider == "" || r.Provider == "cloudflare"
+}
+
+// updateRecord points the record at the address using its provider.
+func updateRecord(ctx context.Context, client *http.Client, record *DNSRecord, recordType string, address string) error {
+	switch record.Provider {
+	case "", "cloudflare":
+		return updateCloudflareRecord(ctx, client, record, recordType, address)
+	case "google":
+		if record.Google == nil {
+			return fmt.Errorf("google record has no google settings")
+		}
+		return record.Google.update(ctx, client, record, recordType, address)
+	case "linode":
+		if record.Linode == nil {
+			return fmt.Errorf("linode record has no linode settings")
+		}
+		return record.Linode.update(ctx, client, record, address)
+	case "vultr":
+		if record.Vultr == nil {
+			return fmt.Errorf("vultr record has no vultr settings")
+		}
+		return record.Vultr.update(ctx, client, record, address)
+	case "gandi":
+		if record.Gandi == nil {
+			return fmt.Errorf("gandi record has no gandi settings")
+		}
+		return record.Gandi.update(ctx, client, record, recordType, address)
+	case "dyndns2":
+		if record.DynDNS2 == nil {
+			return fmt.Errorf("dyndns2 record has no dyndns2 settings")
+		}
+		return record.DynDNS2.update(ctx, client, record, address)
+	case "dnsomatic":
+		if record.DNSOMatic == nil {
+			return fmt.Errorf("dnsomatic record has no dnsomatic settings")
+		}
+		return record.DNSOMatic.update(ctx, client, record, address)
+	case "godaddy":
+		if record.GoDaddy == nil {
+			return fmt.Errorf("godaddy record has no godaddy settings")
+		}
+		return record.GoDaddy.update(ctx, client, record, recordType, address)
+	case "rfc2136":
+		if record.RFC2136 == nil {
+			return fmt.Errorf("rfc2136 record has no rfc2136 settings")
+		}
+		return record.RFC2136.update(ctx, record, recordType, address)
+	case "powerdns":
+		if record.PowerDNS == nil {
+			return fmt.Errorf("powerdns record has no powerdns settings")
+		}
+		return record.PowerDNS.update(ctx, client, record, recordType, address)
+	case "exec":
+		if record.Exec == nil || len(record.Exec.Command) == 0 {
+			return fmt.Errorf("exec record has no command")
+		}
+		return record.Exec.update(ctx, record, recordType, address)
+	default:
+		return fmt.Errorf("unknown provider %q", record.Provider)
+	}
+}
+
+// providerIdentity returns the settings that identify where the record is
+// hosted by its provider, like the zone or server, leaving out credentials.
+func (r *DNSRecord) providerIdentity() []string {
+	switch {
+	case r.usesCloudflare():
+		return []string{r.ZoneID, r.RecordID}
+	case r.Provider == "google" && r.Google != nil:
+		return []string{r.Google.Project, r.Google.ManagedZone}
+	case r.Provider == "linode" && r.Linode != nil:
+		return []string{r.Linode.DomainID, r.Linode.RecordID}
+	case r.Provider == "vultr" && r.Vultr != nil:
+		return []string{r.Vultr.Domain, r.Vultr.RecordID}
+	case r.Provider == "gandi" && r.Gandi != nil:
+		return []string{r.Gandi.Domain}
+	case r.Provider == "dyndns2" && r.DynDNS2 != nil:
+		return []string{r.DynDNS2.Server, r.DynDNS2.Username, r.DynDNS2.Hostname}
+	case r.Provider == "dnsomatic" && r.DNSOMatic != nil:
+		return []string{r.DNSOMatic.Username, r.DNSOMatic.Hostname}
+	case r.Provider == "godaddy" && r.GoDaddy != nil:
+		return []string{r.GoDaddy.Domain}
+	case r.Provider == "rfc2136" && r.RFC2136 != nil:
+		return []string{r.RFC2136.Server, r.RFC2136.Zone}
+	case r.Provider == "powerdns" && r.PowerDNS != nil:
+		return []string{r.PowerDNS.URL, r.PowerDNS.ServerID, r.PowerDNS.Zone}
+	case r.Provider == "exec" && r.Exec != nil:
+		return r.Exec.Command
+	}
+	return nil
+}
+
+// validateProvider returns a description of each problem with the settings
+// for the record's provider.
+func validateProvider(record *DNSRecord) []string {
+	switch record.Provider {
+	case "", "cloudflare":
+		if strings.TrimSpace(record.APIToken) == "" {
+			return []string{"api_token is missing"}
+		}
+		return nil
+	case "google":
+		if record.Google == nil {
+			return []string{"google settings are missing"}
+		}
+		return record.Google.validate()
+	case "linode":
+		if record.Linode == nil {
+			return []string{"linode settings are missing"}
+		}
+		return record.Linode.validate()
+	case "vultr":
+		if record.Vultr == nil {
+			return []string{"vultr settings are missing"}
+		}
+		return record.Vultr.validate()
+	case "gandi":
+		if record.Gandi == nil {
+			return []string{"gandi settings are missing"}
+		}
+		return record.Gandi.validate(record)
+	case "dyndns2":
+		if record.DynDNS2 == nil {
+			return []string{"dyndns2 settings are missing"}
+		}
+		return record.DynDNS2.validate()
+	case "dnsomatic":
+		if record.DNSOMatic == nil {
+			return []string{"dnsomatic settings are missing"}
+		}
+		return record.DNSOMatic.validate()
+	case "godaddy":
+		if record.GoDaddy == nil {
+			return []string{"godaddy settings are missing"}
+		}
+		return record.GoDaddy.validate(record)
+	case "rfc2136":
+		if record.RFC2136 == nil {
+			return []string{"rfc2136 settings are missing"}
+		}
+		return record.RFC2136.validate(record)
+	case "powerdns":
+		if record.PowerDNS == nil {
+			return []string{"powerdns settings are missing"}
+		}
+		return record.PowerDNS.validate(record)
+	case "exec":
+		if record.Exec == nil {
+			return []string{"exec settings are missing"}
+		}
+		return record.Exec.validate()
+	default:
+		return []string{fmt.Sprintf("unknown provider %q", record.Provider)}
+	}
+}
+
+// relativeName returns the name of the record relative to the domain, which is
+// "@" for the domain itself.
+func relativeName(name string, domain string) (string, error) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if name == domain {
+		return "@", nil
+	}
+	relative, ok := strings.CutSuffix(name, "."+domain)
+	if !ok {
+		return "", fmt.Errorf("%s isn't in the domain %s", name, domain)
+	}
+	return relative, nil
+}
+
+// newJSONRequest creates a request with body encoded as JSON, or without a body
+// if it's nil.
+func newJSONRequest(ctx context.Context, method string, url string, body any) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// providerError is returned by doJSON when a provider's API responds with an
+// error status.
+type providerError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *providerError) Error() string {
+	return fmt.Sprintf("API error: %d %s", e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// doJSON sends the request and, if response isn't nil, decodes the JSON
+// response into it. A response with an error status is returned as a
+// *providerError.
+func doJSON(client *http.Client, req *http.Request, response any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return &providerError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	if response != nil {
+		if err := json.Unmarshal(body, response); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+	}
+	return nil
+}
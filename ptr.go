@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// PTRRecord is a reverse DNS record that is kept pointing at the name of the
+// record it belongs to, in a reverse zone hosted on Cloudflare.
+type PTRRecord struct {
+	// APIToken is the token used to update the PTR record. If it's empty, the
+	// token of the forward record is used.
+	APIToken string `json:"api_token,omitempty"`
+	// APITokenFile is the path to a file containing the API token, which is
+	// used if APIToken isn't set.
+	APITokenFile string `json:"api_token_file,omitempty"`
+	// ZoneID is the ID of the reverse zone, such as 113.0.203.in-addr.arpa.
+	ZoneID string `json:"zone_id"`
+	// RecordID is the ID of the PTR record in the reverse zone.
+	RecordID string `json:"record_id"`
+}
+
+// reverseName returns the name in in-addr.arpa or ip6.arpa that holds the PTR
+// record for the address.
+func reverseName(address string) (string, error) {
+	ip, err := netip.ParseAddr(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP address %q: %w", address, err)
+	}
+	ip = ip.Unmap()
+
+	var labels []string
+	if ip.Is4() {
+		octets := ip.As4()
+		for i := len(octets) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprint(octets[i]))
+		}
+		return strings.Join(labels, ".") + ".in-addr.arpa", nil
+	}
+
+	octets := ip.As16()
+	for i := len(octets) - 1; i >= 0; i-- {
+		labels = append(labels, fmt.Sprintf("%x", octets[i]&0xf), fmt.Sprintf("%x", octets[i]>>4))
+	}
+	return strings.Join(labels, ".") + ".ip6.arpa", nil
+}
+
+// updatePTRRecord points the record's PTR record at the record's name. The
+// name of the PTR record changes along with the address.
+func updatePTRRecord(ctx context.Context, client *http.Client, record *DNSRecord, address string) error {
+	name, err := reverseName(address)
+	if err != nil {
+		return err
+	}
+
+	ptr := DNSRecord{
+		Name:     name,
+		APIToken: record.PTR.APIToken,
+		ZoneID:   record.PTR.ZoneID,
+		RecordID: record.PTR.RecordID,
+		TTL:      record.TTL,
+	}
+	if ptr.APIToken == "" {
+		ptr.APIToken = record.APIToken
+	}
+
+	return updateCloudflareRecord(ctx, client, &ptr, "PTR", record.Name)
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+		wantErr string
+	}{
+		{address: "203.0.113.7", want: "7.113.0.203.in-addr.arpa"},
+		{address: "10.0.0.255", want: "255.0.0.10.in-addr.arpa"},
+		{address: "::ffff:192.0.2.1", want: "1.2.0.192.in-addr.arpa"},
+		{
+			address: "2001:db8::567:89ab",
+			want:    "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+		},
+		{
+			address: "::1",
+			want:    "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa",
+		},
+		{address: "example.com", wantErr: `invalid IP address "example.com"`},
+		{address: "", wantErr: `invalid IP address ""`},
+	}
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			got, err := reverseName(test.address)
+			checkError(t, err, test.wantErr)
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
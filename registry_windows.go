@@ -0,0 +1,120 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procRegCreateKeyExW = advapi32.NewProc("RegCreateKeyExW")
+	procRegSetValueExW  = advapi32.NewProc("RegSetValueExW")
+	procRegDeleteKeyW   = advapi32.NewProc("RegDeleteKeyW")
+)
+
+func regCreateKey(parent syscall.Handle, path string) (syscall.Handle, error) {
+	subkey, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var key syscall.Handle
+	var disposition uint32
+	r, _, _ := procRegCreateKeyExW.Call(
+		uintptr(parent),
+		uintptr(unsafe.Pointer(subkey)),
+		0,
+		0,
+		0,
+		syscall.KEY_WRITE,
+		0,
+		uintptr(unsafe.Pointer(&key)),
+		uintptr(unsafe.Pointer(&disposition)))
+	if r != 0 {
+		return 0, syscall.Errno(r)
+	}
+	return key, nil
+}
+
+func regOpenKey(parent syscall.Handle, path string) (syscall.Handle, error) {
+	subkey, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var key syscall.Handle
+	if err := syscall.RegOpenKeyEx(parent, subkey, 0, syscall.KEY_WRITE, &key); err != nil {
+		return 0, err
+	}
+	return key, nil
+}
+
+func regSetValue(key syscall.Handle, name string, valueType uint32, data []byte) error {
+	valueName, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	var dataPtr uintptr
+	if len(data) > 0 {
+		dataPtr = uintptr(unsafe.Pointer(&data[0]))
+	}
+	r, _, _ := procRegSetValueExW.Call(
+		uintptr(key),
+		uintptr(unsafe.Pointer(valueName)),
+		0,
+		uintptr(valueType),
+		dataPtr,
+		uintptr(len(data)))
+	if r != 0 {
+		return syscall.Errno(r)
+	}
+	return nil
+}
+
+func regSetString(key syscall.Handle, name string, valueType uint32, value string) error {
+	utf16, err := syscall.UTF16FromString(value)
+	if err != nil {
+		return err
+	}
+	return regSetValue(key, name, valueType, utf16Bytes(utf16))
+}
+
+// regSetMultiString sets a REG_MULTI_SZ value, which is a sequence of
+// null-terminated strings followed by an extra null terminator.
+func regSetMultiString(key syscall.Handle, name string, values []string) error {
+	var utf16 []uint16
+	for _, value := range values {
+		encoded, err := syscall.UTF16FromString(value)
+		if err != nil {
+			return err
+		}
+		utf16 = append(utf16, encoded...)
+	}
+	utf16 = append(utf16, 0)
+	return regSetValue(key, name, syscall.REG_MULTI_SZ, utf16Bytes(utf16))
+}
+
+func regSetDWORD(key syscall.Handle, name string, value uint32) error {
+	data := []byte{byte(value), byte(value >> 8), byte(value >> 16), byte(value >> 24)}
+	return regSetValue(key, name, syscall.REG_DWORD, data)
+}
+
+func regDeleteKey(parent syscall.Handle, path string) error {
+	subkey, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	r, _, _ := procRegDeleteKeyW.Call(uintptr(parent), uintptr(unsafe.Pointer(subkey)))
+	if r != 0 {
+		return syscall.Errno(r)
+	}
+	return nil
+}
+
+func utf16Bytes(s []uint16) []byte {
+	b := make([]byte, len(s)*2)
+	for i, c := range s {
+		b[i*2] = byte(c)
+		b[i*2+1] = byte(c >> 8)
+	}
+	return b
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// remoteConfigFileName is the file in the cache directory that holds the last
+// configuration fetched from a URL. It's used for conditional requests, and
+// instead of the URL when the server can't be reached.
+const remoteConfigFileName = "remote_config.json"
+
+// remoteConfig is a configuration fetched from a URL, along with the validators
+// the server sent for it.
+type remoteConfig struct {
+	// URL is the URL the configuration was fetched from, without its password.
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Data         string `json:"data"`
+}
+
+// isConfigURL reports whether the configuration path is a URL to fetch the
+// configuration from.
+func isConfigURL(path string) bool {
+	return strings.HasPrefix(path, "https://")
+}
+
+// fetchConfig fetches the configuration from an HTTPS URL. If DDNS_CACHE_PATH is
+// set, the configuration is saved there so that the next request can be
+// conditional, and so that the saved copy can be used if the server can't be
+// reached.
+func fetchConfig(logger *slog.Logger, configURL string) ([]byte, error) {
+	parsed, err := url.Parse(configURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config URL: %w", err)
+	}
+
+	baseCachePath := getCachePath()
+	var saved *remoteConfig
+	if baseCachePath != "" {
+		saved, err = readRemoteConfig(baseCachePath, parsed.Redacted())
+		if err != nil {
+			logger.Warn("Failed to read the saved copy of the configuration", "error", err)
+		}
+	}
+
+	fetched, err := downloadConfig(parsed, saved)
+	if err != nil {
+		if saved == nil {
+			return nil, err
+		}
+		logger.Warn("Failed to fetch configuration, using the saved copy", "url", parsed.Redacted(), "error", err)
+		return []byte(saved.Data), nil
+	}
+
+	if fetched != saved && baseCachePath != "" {
+		if err := writeRemoteConfig(baseCachePath, fetched); err != nil {
+			logger.Warn("Failed to save a copy of the configuration", "error", err)
+		}
+	}
+	return []byte(fetched.Data), nil
+}
+
+// downloadConfig requests the configuration, returning saved if the server
+// reports that it hasn't changed.
+func downloadConfig(configURL *url.URL, saved *remoteConfig) (*remoteConfig, error) {
+	client, err := newHTTPClient(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", configURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	// Basic authentication comes from the URL's user info, which the client
+	// adds by itself.
+	if token := os.Getenv("DDNS_CONFIG_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if saved != nil {
+		if saved.ETag != "" {
+			req.Header.Set("If-None-Match", saved.ETag)
+		}
+		if saved.LastModified != "" {
+			req.Header.Set("If-Modified-Since", saved.LastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch configuration: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && saved != nil {
+		return saved, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("config server returned status code %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration: %w", err)
+	}
+
+	return &remoteConfig{
+		URL:          configURL.Redacted(),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Data:         string(data),
+	}, nil
+}
+
+// readRemoteConfig returns the saved copy of the configuration from the URL, or
+// nil if there isn't one.
+func readRemoteConfig(basePath, configURL string) (*remoteConfig, error) {
+	data, err := os.ReadFile(filepath.Join(basePath, remoteConfigFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved remoteConfig
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	if saved.URL != configURL {
+		return nil, nil
+	}
+	return &saved, nil
+}
+
+// writeRemoteConfig saves a copy of the configuration. Only the owner can read
+// it, because it usually contains API tokens.
+func writeRemoteConfig(basePath string, config *remoteConfig) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(basePath, remoteConfigFileName), data, 0600)
+}
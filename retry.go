@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Transient failures of requests to the Cloudflare API are retried this many
+// times in total, waiting a random time up to retryBaseDelay, then up to twice
+// that, and so on, but never longer than retryMaxDelay. The jitter stops many
+// clients that failed at the same time from retrying at the same time too.
+const (
+	cloudflareAttempts = 4
+	retryBaseDelay     = time.Second
+	retryMaxDelay      = 30 * time.Second
+)
+
+// rateLimitMaxWait is how long a request waits in total for Cloudflare's rate
+// limit before giving up. The limit is on requests over 5 minutes, so this is
+// long enough for it to reset at least once.
+const rateLimitMaxWait = 6 * time.Minute
+
+// transientError is a failure that's likely to go away if the request is made
+// again, like a timeout or a 5xx status.
+type transientError struct {
+	err error
+	// retryAfter is how long the server asked to wait, if it did.
+	retryAfter time.Duration
+	// rateLimited is set when the status was 429, which means the request
+	// should work once the limit resets.
+	rateLimited bool
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// requestError wraps an error from making a request, which is transient unless
+// it's a problem with the server's certificate, which won't fix itself.
+func requestError(err error) error {
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return err
+	}
+	return &transientError{err: err}
+}
+
+// statusError wraps the error for a response's status, which is transient if
+// the status is 429 or 5xx.
+func statusError(resp *http.Response, err error) error {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return err
+	}
+	transient := &transientError{err: err, rateLimited: resp.StatusCode == http.StatusTooManyRequests}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		transient.retryAfter = time.Duration(seconds) * time.Second
+	}
+	return transient
+}
+
+// retryDelay returns how long to wait after the attempt failed, before the
+// next one.
+func retryDelay(attempt int, err *transientError) time.Duration {
+	if err.retryAfter > 0 {
+		return min(err.retryAfter, retryMaxDelay)
+	}
+	limit := min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	return rand.N(limit) + 1
+}
+
+// withRetries calls request until it succeeds, fails with an error that isn't
+// transient, or has been called attempts times, and returns its last error.
+// Being rate limited doesn't use up an attempt, because it says nothing about
+// whether the request would work, but the request waits for as long as the
+// server asks first, and so does every other request sharing the context's
+// rateLimit. It stops waiting to retry when the context is done.
+func withRetries(ctx context.Context, attempts int, request func() error) error {
+	limit := rateLimitFrom(ctx)
+	var rateLimited time.Duration
+	for attempt := 1; ; attempt++ {
+		if err := limit.wait(ctx); err != nil {
+			return err
+		}
+		syncProgressFrom(ctx).made()
+		err := request()
+		var transient *transientError
+		if err == nil || !errors.As(err, &transient) || ctx.Err() != nil {
+			return err
+		}
+		if transient.rateLimited && rateLimited < rateLimitMaxWait {
+			delay := transient.retryAfter
+			if delay == 0 {
+				delay = retryMaxDelay
+			}
+			rateLimited += delay
+			limit.pause(delay)
+			if sleep(ctx, delay) != nil {
+				return fmt.Errorf("%w (gave up waiting for the rate limit to reset, because the run took too long)", err)
+			}
+			attempt--
+			continue
+		}
+		if attempt == attempts {
+			return fmt.Errorf("%w (gave up after %d attempts)", err, attempts)
+		}
+		if sleep(ctx, retryDelay(attempt, transient)) != nil {
+			return fmt.Errorf("%w (gave up after %d attempts, because the run took too long)", err, attempt)
+		}
+	}
+}
+
+// rateLimit is when requests can be made again after one was rate limited.
+// It's shared by every record in a run, so that they all wait instead of each
+// being rate limited in turn, which would only make the limit last longer.
+type rateLimit struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+type rateLimitKey struct{}
+
+// withRateLimit returns a context that carries the rate limit.
+func withRateLimit(ctx context.Context, l *rateLimit) context.Context {
+	return context.WithValue(ctx, rateLimitKey{}, l)
+}
+
+// rateLimitFrom returns the rate limit in the context, or nil if there isn't
+// one, which never waits.
+func rateLimitFrom(ctx context.Context) *rateLimit {
+	l, _ := ctx.Value(rateLimitKey{}).(*rateLimit)
+	return l
+}
+
+// pause stops requests from being made for the duration.
+func (l *rateLimit) pause(d time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.until) {
+		l.until = until
+	}
+}
+
+// wait returns once requests can be made, or with an error if the context is
+// done first.
+func (l *rateLimit) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	d := time.Until(l.until)
+	l.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	if sleep(ctx, d) != nil {
+		return fmt.Errorf("gave up waiting for the rate limit to reset: %w", context.Cause(ctx))
+	}
+	return nil
+}
+
+// sleep waits for the duration, or returns the context's error if it's done
+// first.
+func sleep(ctx context.Context, d time.Duration) error {
+	syncProgressFrom(ctx).waiting(d)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// RFC2136 holds the settings for a record on an authoritative server that
+// accepts dynamic updates, such as BIND, Knot, or PowerDNS.
+type RFC2136 struct {
+	// Server is the host of the primary server, optionally with a port. The
+	// default port is 53.
+	Server string `json:"server"`
+	// Zone is the zone the record is in, such as example.com.
+	Zone string `json:"zone"`
+	// TSIGKeyName is the name of the key used to sign updates. If it's empty,
+	// updates aren't signed, which the server must allow by address.
+	TSIGKeyName string `json:"tsig_key_name,omitempty"`
+	// TSIGAlgorithm is "hmac-sha1", "hmac-sha256" (the default), or
+	// "hmac-sha512".
+	TSIGAlgorithm string `json:"tsig_algorithm,omitempty"`
+	// TSIGSecret is the key's secret, encoded in base64 like in BIND's key
+	// files.
+	TSIGSecret string `json:"tsig_secret,omitempty"`
+	// TSIGSecretFile is the path to a file containing the secret, which is read
+	// when the configuration is loaded if TSIGSecret isn't set.
+	TSIGSecretFile string `json:"tsig_secret_file,omitempty"`
+}
+
+// DNS constants used in dynamic updates.
+const (
+	dnsOpcodeUpdate = 5
+	dnsTypeA        = 1
+	dnsTypeSOA      = 6
+	dnsTypeAAAA     = 28
+	dnsTypeTSIG     = 250
+	dnsClassIN      = 1
+	dnsClassANY     = 255
+	tsigFudge       = 300
+)
+
+// dnsRcodes describes the response codes that a server can reject an update
+// with.
+var dnsRcodes = map[int]string{
+	1:  "FORMERR: the server couldn't parse the update",
+	2:  "SERVFAIL: the server failed to apply the update",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP: the server doesn't support dynamic updates",
+	5:  "REFUSED: the server doesn't allow updates from this client or key",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH: the server isn't authoritative for the zone, or rejected the TSIG key, secret, or clock",
+	10: "NOTZONE: the record isn't in the zone",
+}
+
+// tsigHash returns the hash function for the TSIG algorithm.
+func tsigHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToLower(strings.TrimSuffix(algorithm, ".")) {
+	case "hmac-sha1":
+		return sha1.New, nil
+	case "", "hmac-sha256":
+		return sha256.New, nil
+	case "hmac-sha512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported TSIG algorithm %q", algorithm)
+	}
+}
+
+// dnsName encodes a domain name in wire format, without compression.
+func dnsName(name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	var encoded []byte
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("invalid domain name %q", name)
+			}
+			encoded = append(encoded, byte(len(label)))
+			encoded = append(encoded, label...)
+		}
+	}
+	return append(encoded, 0), nil
+}
+
+// appendRR appends a resource record to the message.
+func appendRR(message []byte, name []byte, rrType uint16, class uint16, ttl uint32, rdata []byte) []byte {
+	message = append(message, name...)
+	message = binary.BigEndian.AppendUint16(message, rrType)
+	message = binary.BigEndian.AppendUint16(message, class)
+	message = binary.BigEndian.AppendUint32(message, ttl)
+	message = binary.BigEndian.AppendUint16(message, uint16(len(rdata)))
+	return append(message, rdata...)
+}
+
+// updateMessage builds an update that replaces every record of the type at the
+// name with a single record for the address.
+func (r *RFC2136) updateMessage(id uint16, name string, recordType string, ttl int, address netip.Addr) ([]byte, error) {
+	zone, err := dnsName(r.Zone)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := dnsName(name)
+	if err != nil {
+		return nil, err
+	}
+	rrType := uint16(dnsTypeA)
+	if recordType == "AAAA" {
+		rrType = dnsTypeAAAA
+	}
+
+	message := binary.BigEndian.AppendUint16(nil, id)
+	message = binary.BigEndian.AppendUint16(message, dnsOpcodeUpdate<<11)
+	// One zone, no prerequisites, two updates, and no additional records.
+	message = binary.BigEndian.AppendUint16(message, 1)
+	message = binary.BigEndian.AppendUint16(message, 0)
+	message = binary.BigEndian.AppendUint16(message, 2)
+	message = binary.BigEndian.AppendUint16(message, 0)
+
+	message = append(message, zone...)
+	message = binary.BigEndian.AppendUint16(message, dnsTypeSOA)
+	message = binary.BigEndian.AppendUint16(message, dnsClassIN)
+
+	// Deleting the RRset with class ANY removes the old address first.
+	message = appendRR(message, owner, rrType, dnsClassANY, 0, nil)
+	message = appendRR(message, owner, rrType, dnsClassIN, uint32(ttl), address.AsSlice())
+	return message, nil
+}
+
+// sign appends a TSIG record to the message, as described in RFC 8945.
+func (r *RFC2136) sign(message []byte, now time.Time) ([]byte, error) {
+	newHash, err := tsigHash(r.TSIGAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := base64.StdEncoding.DecodeString(r.TSIGSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode TSIG secret: %w", err)
+	}
+	algorithm := strings.ToLower(strings.TrimSuffix(r.TSIGAlgorithm, "."))
+	if algorithm == "" {
+		algorithm = "hmac-sha256"
+	}
+	keyName, err := dnsName(strings.ToLower(r.TSIGKeyName))
+	if err != nil {
+		return nil, err
+	}
+	algorithmName, err := dnsName(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	// The time is a 48-bit number of seconds.
+	timeSigned := binary.BigEndian.AppendUint64(nil, uint64(now.Unix()))[2:]
+
+	mac := hmac.New(newHash, secret)
+	mac.Write(message)
+	mac.Write(keyName)
+	binary.Write(mac, binary.BigEndian, uint16(dnsClassANY))
+	binary.Write(mac, binary.BigEndian, uint32(0))
+	mac.Write(algorithmName)
+	mac.Write(timeSigned)
+	binary.Write(mac, binary.BigEndian, uint16(tsigFudge))
+	// No error and no other data.
+	binary.Write(mac, binary.BigEndian, uint32(0))
+	sum := mac.Sum(nil)
+
+	rdata := append([]byte{}, algorithmName...)
+	rdata = append(rdata, timeSigned...)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, message[0:2]...)
+	rdata = binary.BigEndian.AppendUint32(rdata, 0)
+
+	signed := appendRR(append([]byte{}, message...), keyName, dnsTypeTSIG, dnsClassANY, 0, rdata)
+	binary.BigEndian.PutUint16(signed[10:12], 1)
+	return signed, nil
+}
+
+// exchange sends the message to the server over TCP and returns the response.
+func (r *RFC2136) exchange(ctx context.Context, message []byte) ([]byte, error) {
+	address := r.Server
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "53")
+	}
+
+	conn, err := auditDial(ctx, "dns_update", "tcp", address, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to DNS server: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// Messages over TCP are prefixed with their length.
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(message))), message...)); err != nil {
+		return nil, fmt.Errorf("failed to send update: %w", err)
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return response, nil
+}
+
+// update replaces the record's address on the server. The response's TSIG
+// signature isn't checked, because the response only says whether the update
+// was applied.
+func (r *RFC2136) update(ctx context.Context, record *DNSRecord, recordType string, address string) error {
+	ip, err := netip.ParseAddr(address)
+	if err != nil {
+		return fmt.Errorf("invalid IP address %q: %w", address, err)
+	}
+	ttl := record.TTL
+	if ttl <= 1 {
+		ttl = 300
+	}
+
+	var id [2]byte
+	rand.Read(id[:])
+	message, err := r.updateMessage(binary.BigEndian.Uint16(id[:]), record.Name, recordType, ttl, ip.Unmap())
+	if err != nil {
+		return err
+	}
+	if r.TSIGKeyName != "" {
+		if message, err = r.sign(message, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	response, err := r.exchange(ctx, message)
+	if err != nil {
+		return err
+	}
+	if len(response) < 12 || response[0] != id[0] || response[1] != id[1] {
+		return errors.New("DNS server returned an invalid response")
+	}
+	if rcode := int(response[3] & 0x0f); rcode != 0 {
+		if description, ok := dnsRcodes[rcode]; ok {
+			return fmt.Errorf("DNS server rejected the update: %s", description)
+		}
+		return fmt.Errorf("DNS server rejected the update with rcode %d", rcode)
+	}
+	return nil
+}
+
+// validate returns a description of each problem with the settings for the
+// record.
+func (r *RFC2136) validate(record *DNSRecord) []string {
+	var problems []string
+	if strings.TrimSpace(r.Server) == "" {
+		problems = append(problems, "rfc2136 server is missing")
+	}
+	if strings.TrimSpace(r.Zone) == "" {
+		problems = append(problems, "rfc2136 zone is missing")
+	} else if _, err := relativeName(record.Name, r.Zone); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if r.TSIGKeyName != "" {
+		if _, err := tsigHash(r.TSIGAlgorithm); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if r.TSIGSecret == "" {
+			problems = append(problems, "rfc2136 tsig_secret is missing")
+		} else if _, err := base64.StdEncoding.DecodeString(r.TSIGSecret); err != nil {
+			problems = append(problems, "rfc2136 tsig_secret isn't valid base64")
+		}
+	}
+	return problems
+}
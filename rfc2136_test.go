@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"testing"
+	"time"
+)
+
+func TestRFC2136Sign(t *testing.T) {
+	secret := []byte("a shared secret for the test key")
+	message := []byte{
+		0x12, 0x34, 0x28, 0x00, // ID and opcode UPDATE
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // one zone, nothing else
+		0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00,
+		0x00, 0x06, 0x00, 0x01, // SOA IN
+	}
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name      string
+		algorithm string
+		keyName   string
+		secret    string
+		newHash   func() hash.Hash
+		wireAlg   string
+		wantErr   string
+	}{
+		{
+			name:    "default algorithm",
+			keyName: "ddns-key.",
+			secret:  base64.StdEncoding.EncodeToString(secret),
+			newHash: sha256.New,
+			wireAlg: "\x0bhmac-sha256\x00",
+		},
+		{
+			name:      "hmac-sha1",
+			algorithm: "hmac-sha1",
+			keyName:   "ddns-key",
+			secret:    base64.StdEncoding.EncodeToString(secret),
+			newHash:   sha1.New,
+			wireAlg:   "\x09hmac-sha1\x00",
+		},
+		{
+			name:      "hmac-sha512 in upper case",
+			algorithm: "HMAC-SHA512.",
+			keyName:   "DDNS-Key",
+			secret:    base64.StdEncoding.EncodeToString(secret),
+			newHash:   sha512.New,
+			wireAlg:   "\x0bhmac-sha512\x00",
+		},
+		{
+			name:      "unsupported algorithm",
+			algorithm: "hmac-md5.sig-alg.reg.int",
+			keyName:   "ddns-key",
+			secret:    base64.StdEncoding.EncodeToString(secret),
+			wantErr:   `unsupported TSIG algorithm "hmac-md5.sig-alg.reg.int"`,
+		},
+		{
+			name:    "secret that isn't base64",
+			keyName: "ddns-key",
+			secret:  "not base64!",
+			wantErr: "failed to decode TSIG secret",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &RFC2136{TSIGKeyName: test.keyName, TSIGSecret: test.secret, TSIGAlgorithm: test.algorithm}
+			got, err := r.sign(message, now)
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				return
+			}
+
+			// The key name is always signed and sent in lower case.
+			keyName := []byte("\x08ddns-key\x00")
+			timeSigned := []byte{0x00, 0x00, 0x65, 0x53, 0xf1, 0x00}
+			fudge := []byte{0x01, 0x2c}
+
+			// The TSIG variables from RFC 8945 section 4.3.3.
+			mac := hmac.New(test.newHash, secret)
+			mac.Write(message)
+			mac.Write(keyName)
+			mac.Write([]byte{0x00, 0xff, 0x00, 0x00, 0x00, 0x00})
+			mac.Write([]byte(test.wireAlg))
+			mac.Write(timeSigned)
+			mac.Write(fudge)
+			mac.Write([]byte{0x00, 0x00, 0x00, 0x00})
+			sum := mac.Sum(nil)
+
+			var rdata []byte
+			rdata = append(rdata, test.wireAlg...)
+			rdata = append(rdata, timeSigned...)
+			rdata = append(rdata, fudge...)
+			rdata = append(rdata, 0x00, byte(len(sum)))
+			rdata = append(rdata, sum...)
+			rdata = append(rdata, 0x12, 0x34, 0x00, 0x00, 0x00, 0x00)
+
+			want := append([]byte{}, message...)
+			want[11] = 1 // one additional record
+			want = append(want, keyName...)
+			want = append(want, 0x00, 0xfa, 0x00, 0xff, 0x00, 0x00, 0x00, 0x00)
+			want = append(want, byte(len(rdata)>>8), byte(len(rdata)))
+			want = append(want, rdata...)
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("got\n%x\nwant\n%x", got, want)
+			}
+			if message[11] != 0 {
+				t.Error("the original message was changed")
+			}
+		})
+	}
+}
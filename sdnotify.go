@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// notifySystemd sends a state change, like READY=1, to systemd if it started
+// the process as a Type=notify service. Otherwise, it does nothing.
+func notifySystemd(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	// A leading @ means the socket is in the abstract namespace.
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// watchdogInterval returns how often systemd expects to be told that the
+// process is still working, or zero if the watchdog isn't enabled for it.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// syncProgress is when a sync last got anywhere, so the watchdog can tell one
+// that's slow, because it's retrying or waiting out a rate limit, from one
+// that's stuck.
+type syncProgress struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+type syncProgressKey struct{}
+
+// withSyncProgress returns a context that carries the progress of the sync.
+func withSyncProgress(ctx context.Context, p *syncProgress) context.Context {
+	return context.WithValue(ctx, syncProgressKey{}, p)
+}
+
+// syncProgressFrom returns the progress in the context, or nil if there isn't
+// one, which ignores everything.
+func syncProgressFrom(ctx context.Context) *syncProgress {
+	p, _ := ctx.Value(syncProgressKey{}).(*syncProgress)
+	return p
+}
+
+// made records that the sync has just got somewhere, like starting a request
+// or finishing a record.
+func (p *syncProgress) made() {
+	p.waiting(0)
+}
+
+// waiting records that the sync is about to wait on purpose for the duration,
+// which counts as progress until it's over.
+func (p *syncProgress) waiting(d time.Duration) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if until := time.Now().Add(d); until.After(p.last) {
+		p.last = until
+	}
+}
+
+// idle returns how long it's been since the sync last got anywhere.
+func (p *syncProgress) idle() time.Duration {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return max(time.Since(p.last), 0)
+}
+
+// feedWatchdog tells systemd that the daemon is working twice per watchdog
+// interval, until the context is done. If a sync hasn't got anywhere for
+// longer than the interval, it's assumed to be stuck and systemd isn't told,
+// so that it restarts the process. A sync that's slow but still making
+// requests, or waiting out a rate limit, isn't stuck.
+func (d *daemon) feedWatchdog(ctx context.Context, timeout time.Duration) {
+	logger := d.logger.With("component", "watchdog")
+	logger.Info("Notifying the systemd watchdog", "timeout", timeout.String())
+
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.mu.Lock()
+		stuck := d.syncing && d.progress.idle() > timeout
+		d.mu.Unlock()
+		if stuck {
+			logger.Error("Sync hasn't made progress for longer than the watchdog timeout, not notifying systemd")
+			continue
+		}
+		if err := notifySystemd("WATCHDOG=1"); err != nil {
+			logger.Warn("Failed to notify the systemd watchdog", "error", err)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+func serviceCommand(logger *slog.Logger, args []string) error {
+	return fmt.Errorf("the service command is only supported on Windows")
+}
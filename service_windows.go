@@ -0,0 +1,390 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// serviceName is the name the service is installed under.
+const serviceName = "clouddns"
+
+const (
+	scManagerAllAccess     = 0xF003F
+	serviceAllAccess       = 0xF01FF
+	serviceWin32OwnProcess = 0x10
+	serviceAutoStart       = 2
+	serviceErrorNormal     = 1
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	serviceAcceptStop     = 0x1
+	serviceAcceptShutdown = 0x4
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	errorCallNotImplemented = 120
+	errorServiceNotActive   = 1062
+)
+
+var (
+	advapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procOpenSCManagerW                = advapi32.NewProc("OpenSCManagerW")
+	procCreateServiceW                = advapi32.NewProc("CreateServiceW")
+	procOpenServiceW                  = advapi32.NewProc("OpenServiceW")
+	procStartServiceW                 = advapi32.NewProc("StartServiceW")
+	procControlService                = advapi32.NewProc("ControlService")
+	procDeleteService                 = advapi32.NewProc("DeleteService")
+	procCloseServiceHandle            = advapi32.NewProc("CloseServiceHandle")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+)
+
+// serviceStatus is SERVICE_STATUS from winsvc.h
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// serviceTableEntry is SERVICE_TABLE_ENTRYW from winsvc.h
+type serviceTableEntry struct {
+	ServiceName *uint16
+	ServiceProc uintptr
+}
+
+// windowsService is the state of the running service. The service control
+// manager calls back into plain functions, so it's stored in a package variable.
+type windowsService struct {
+	logger       *slog.Logger
+	interval     time.Duration
+	statusHandle uintptr
+	ctx          context.Context
+	stop         context.CancelFunc
+}
+
+var (
+	runningService         *windowsService
+	serviceMainCallback    = syscall.NewCallback(serviceMain)
+	serviceHandlerCallback = syscall.NewCallback(serviceHandler)
+)
+
+func (s *windowsService) setStatus(state uint32, accepts uint32, waitHint time.Duration) {
+	status := serviceStatus{
+		ServiceType:      serviceWin32OwnProcess,
+		CurrentState:     state,
+		ControlsAccepted: accepts,
+		WaitHint:         uint32(waitHint.Milliseconds()),
+	}
+	r, _, err := procSetServiceStatus.Call(s.statusHandle, uintptr(unsafe.Pointer(&status)))
+	if r == 0 {
+		s.logger.Error("Failed to set service status", "state", state, "error", err)
+	}
+}
+
+// serviceMain is called by the service control manager on its own thread once
+// the service has started, and the service stops when it returns.
+func serviceMain(argc, argv uintptr) uintptr {
+	s := runningService
+
+	name, _ := syscall.UTF16PtrFromString(serviceName)
+	handle, _, err := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(name)), serviceHandlerCallback, 0)
+	if handle == 0 {
+		s.logger.Error("Failed to register service control handler", "error", err)
+		return 0
+	}
+	s.statusHandle = handle
+
+	s.setStatus(serviceRunning, serviceAcceptStop|serviceAcceptShutdown, 0)
+	s.logger.Info("Service started", "interval", s.interval.String())
+
+	d := newDaemon(s.logger, s.interval)
+	if path := os.Getenv("DDNS_EVENTS_SOCKET"); path != "" {
+		if err := d.serveEvents(s.ctx, path); err != nil {
+			s.logger.Error("Failed to publish events", "error", err)
+		}
+	}
+	if path := os.Getenv("DDNS_CONTROL_SOCKET"); path != "" {
+		if err := d.serveControl(s.ctx, path); err != nil {
+			s.logger.Error("Failed to listen for commands", "error", err)
+		}
+	}
+	if address := os.Getenv("DDNS_HEALTH_LISTEN"); address != "" {
+		if err := d.serveHealth(s.ctx, address); err != nil {
+			s.logger.Error("Failed to serve health checks", "error", err)
+		}
+	}
+	go d.watchConfiguration(s.ctx)
+	go d.watchSchedules(s.ctx)
+	d.run(s.ctx)
+
+	s.logger.Info("Service stopped")
+	s.setStatus(serviceStopped, 0, 0)
+	return 0
+}
+
+// serviceHandler receives control requests, such as stop, from the service control manager.
+func serviceHandler(control, eventType, eventData, context uintptr) uintptr {
+	s := runningService
+
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		// A run that's already in progress is allowed to finish, which is bounded
+		// by the HTTP client timeout and webhook retries.
+		s.setStatus(serviceStopPending, 0, 30*time.Second)
+		s.stop()
+		return 0
+	case serviceControlInterrogate:
+		return 0
+	default:
+		return errorCallNotImplemented
+	}
+}
+
+func openSCManager() (uintptr, error) {
+	scm, _, err := procOpenSCManagerW.Call(0, 0, scManagerAllAccess)
+	if scm == 0 {
+		return 0, fmt.Errorf("failed to connect to the service control manager: %w", err)
+	}
+	return scm, nil
+}
+
+func openService(scm uintptr) (uintptr, error) {
+	name, err := syscall.UTF16PtrFromString(serviceName)
+	if err != nil {
+		return 0, err
+	}
+	service, _, err := procOpenServiceW.Call(scm, uintptr(unsafe.Pointer(name)), serviceAllAccess)
+	if service == 0 {
+		return 0, fmt.Errorf("failed to open service: %w", err)
+	}
+	return service, nil
+}
+
+func closeServiceHandle(handle uintptr) {
+	procCloseServiceHandle.Call(handle)
+}
+
+func installService(interval time.Duration) error {
+	environment, err := installEnvironment()
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable path: %w", err)
+	}
+	binaryPath := fmt.Sprintf(`"%s" service run -interval %s`, exe, interval)
+
+	scm, err := openSCManager()
+	if err != nil {
+		return err
+	}
+	defer closeServiceHandle(scm)
+
+	name, _ := syscall.UTF16PtrFromString(serviceName)
+	displayName, _ := syscall.UTF16PtrFromString("clouddns Cloudflare DDNS client")
+	binaryPathPtr, err := syscall.UTF16PtrFromString(binaryPath)
+	if err != nil {
+		return err
+	}
+
+	service, _, err := procCreateServiceW.Call(
+		scm,
+		uintptr(unsafe.Pointer(name)),
+		uintptr(unsafe.Pointer(displayName)),
+		serviceAllAccess,
+		serviceWin32OwnProcess,
+		serviceAutoStart,
+		serviceErrorNormal,
+		uintptr(unsafe.Pointer(binaryPathPtr)),
+		0, 0, 0, 0, 0)
+	if service == 0 {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer closeServiceHandle(service)
+
+	// The service control manager reads the environment for the service from the
+	// Environment value of its registry key.
+	key, err := regOpenKey(syscall.HKEY_LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to open service registry key: %w", err)
+	}
+	defer syscall.RegCloseKey(key)
+	if err := regSetMultiString(key, "Environment", environment); err != nil {
+		return fmt.Errorf("failed to set service environment: %w", err)
+	}
+
+	if err := registerEventSource(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func uninstallService() error {
+	scm, err := openSCManager()
+	if err != nil {
+		return err
+	}
+	defer closeServiceHandle(scm)
+
+	service, err := openService(scm)
+	if err != nil {
+		return err
+	}
+	defer closeServiceHandle(service)
+
+	// Deleting a running service only marks it for deletion, so it's stopped first.
+	if err := controlService(service, serviceControlStop); err != nil && !errors.Is(err, syscall.Errno(errorServiceNotActive)) {
+		return err
+	}
+
+	r, _, err := procDeleteService.Call(service)
+	if r == 0 {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+
+	if err := unregisterEventSource(); err != nil {
+		return fmt.Errorf("failed to remove event source: %w", err)
+	}
+
+	return nil
+}
+
+func startService() error {
+	scm, err := openSCManager()
+	if err != nil {
+		return err
+	}
+	defer closeServiceHandle(scm)
+
+	service, err := openService(scm)
+	if err != nil {
+		return err
+	}
+	defer closeServiceHandle(service)
+
+	r, _, err := procStartServiceW.Call(service, 0, 0)
+	if r == 0 {
+		return fmt.Errorf("failed to start service: %w", err)
+	}
+	return nil
+}
+
+func stopService() error {
+	scm, err := openSCManager()
+	if err != nil {
+		return err
+	}
+	defer closeServiceHandle(scm)
+
+	service, err := openService(scm)
+	if err != nil {
+		return err
+	}
+	defer closeServiceHandle(service)
+
+	return controlService(service, serviceControlStop)
+}
+
+func controlService(service uintptr, control uint32) error {
+	var status serviceStatus
+	r, _, err := procControlService.Call(service, uintptr(control), uintptr(unsafe.Pointer(&status)))
+	if r == 0 {
+		return fmt.Errorf("failed to control service: %w", err)
+	}
+	return nil
+}
+
+// runService connects to the service control manager and blocks until the service stops.
+func runService(interval time.Duration) error {
+	handler, err := newEventLogHandler(slog.LevelInfo)
+	if err != nil {
+		return err
+	}
+	defer handler.Close()
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	runningService = &windowsService{
+		logger:   slog.New(handler),
+		interval: interval,
+		ctx:      ctx,
+		stop:     stop,
+	}
+
+	// The service is run in its own process, so the name is ignored.
+	name, _ := syscall.UTF16PtrFromString("")
+	table := []serviceTableEntry{
+		{ServiceName: name, ServiceProc: serviceMainCallback},
+		{ServiceName: nil, ServiceProc: 0},
+	}
+	r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0])))
+	if r == 0 {
+		return fmt.Errorf("failed to connect to the service control manager: %w", err)
+	}
+	return nil
+}
+
+// serviceCommand handles "clouddns service <install|uninstall|start|stop|run>".
+func serviceCommand(logger *slog.Logger, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: clouddns service <install|uninstall|start|stop|run>")
+	}
+
+	command := args[0]
+	flags := flag.NewFlagSet("service "+command, flag.ExitOnError)
+	interval := flags.Duration("interval", 15*time.Minute, "time between runs")
+	flags.Parse(args[1:])
+
+	switch command {
+	case "install":
+		if err := installService(*interval); err != nil {
+			return err
+		}
+		logger.Info("Installed service", "name", serviceName, "interval", interval.String())
+	case "uninstall":
+		if err := uninstallService(); err != nil {
+			return err
+		}
+		logger.Info("Uninstalled service", "name", serviceName)
+	case "start":
+		if err := startService(); err != nil {
+			return err
+		}
+		logger.Info("Started service", "name", serviceName)
+	case "stop":
+		if err := stopService(); err != nil {
+			return err
+		}
+		logger.Info("Stopped service", "name", serviceName)
+	case "run":
+		return runService(*interval)
+	default:
+		return fmt.Errorf("unknown service command %q", command)
+	}
+
+	return nil
+}
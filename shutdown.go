@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout is how long a sync that's running when clouddns is told to
+// stop can take to finish before clouddns exits anyway. It's shorter than the
+// 30 seconds that Kubernetes waits by default, so that the exit is logged
+// rather than the process being killed.
+const shutdownTimeout = 25 * time.Second
+
+// stopGracePeriod is how long the records that were being updated when
+// clouddns was told to stop are given to finish. It's shorter than
+// shutdownTimeout, so that they're given up on, and the run is wrapped up,
+// before clouddns exits anyway.
+const stopGracePeriod = shutdownTimeout - 5*time.Second
+
+// errStopping is the error of the records that weren't started because
+// clouddns was told to stop.
+var errStopping = errors.New("skipped because clouddns is stopping")
+
+// isClosed returns whether the channel is closed, without waiting. A nil
+// channel is never closed.
+func isClosed(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopContext returns a context that's done when clouddns receives SIGINT or
+// SIGTERM. Whatever is running is allowed to finish, so that a record isn't
+// left updated without its cache file, but only for shutdownTimeout. A second
+// signal exits immediately.
+func stopContext(logger *slog.Logger) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-ctx.Done():
+			signal.Stop(signals)
+			return
+		case received := <-signals:
+			// Stopping the notification restores the default behaviour, which
+			// is to exit, for the next signal.
+			signal.Stop(signals)
+			logger.Info("Stopping once the current sync finishes", "signal", received.String(), "timeout", shutdownTimeout.String())
+			cancel()
+		}
+		time.AfterFunc(shutdownTimeout, func() {
+			logger.Error("Sync didn't finish in time, exiting anyway", "timeout", shutdownTimeout.String())
+			os.Exit(1)
+		})
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleDaemonSignals reloads the configuration on SIGHUP, logs the daemon's
+// status on SIGUSR1, and triggers a sync on SIGUSR2, until the context is done.
+func handleDaemonSignals(ctx context.Context, d *daemon) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			switch sig {
+			case syscall.SIGHUP:
+				d.reload("signal")
+			case syscall.SIGUSR1:
+				d.logStatus()
+			case syscall.SIGUSR2:
+				d.trigger("signal")
+			}
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "context"
+
+// handleDaemonSignals does nothing, because Windows doesn't have SIGHUP, SIGUSR1, or SIGUSR2.
+func handleDaemonSignals(ctx context.Context, d *daemon) {}
@@ -0,0 +1,778 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SNMPSource reads the WAN address from a router or modem over SNMP, for
+// equipment that doesn't have an HTTP API.
+type SNMPSource struct {
+	// Address is the host of the agent, optionally with a port. The default port is 161.
+	Address string `json:"address"`
+	// OID is the object whose value is the WAN address. It can be either an
+	// IpAddress, or an OCTET STRING holding the raw or textual address.
+	OID string `json:"oid"`
+	// Version is "1", "2c" (the default), or "3".
+	Version string `json:"version,omitempty"`
+	// Community is used by versions 1 and 2c. The default is "public".
+	Community string `json:"community,omitempty"`
+	// Username, along with the settings below, is used by version 3.
+	Username string `json:"username,omitempty"`
+	// AuthProtocol is "MD5" or "SHA". If it's empty, requests aren't authenticated.
+	AuthProtocol string `json:"auth_protocol,omitempty"`
+	AuthPassword string `json:"auth_password,omitempty"`
+	// PrivProtocol is "DES" or "AES". If it's empty, requests aren't encrypted.
+	PrivProtocol string `json:"priv_protocol,omitempty"`
+	PrivPassword string `json:"priv_password,omitempty"`
+}
+
+// ASN.1 BER tags used by SNMP.
+const (
+	berInteger        = 0x02
+	berOctetString    = 0x04
+	berNull           = 0x05
+	berObjectID       = 0x06
+	berSequence       = 0x30
+	berIPAddress      = 0x40
+	berNoSuchObject   = 0x80
+	berNoSuchInstance = 0x81
+	berEndOfMIBView   = 0x82
+	snmpGetRequest    = 0xa0
+	snmpResponse      = 0xa2
+	snmpReport        = 0xa8
+)
+
+// snmpReportErrors describes the USM counters that agents report when an SNMPv3
+// request is rejected.
+var snmpReportErrors = map[string]string{
+	"1.3.6.1.6.3.15.1.1.1.0": "unsupported security level",
+	"1.3.6.1.6.3.15.1.1.2.0": "not in time window",
+	"1.3.6.1.6.3.15.1.1.3.0": "unknown user name",
+	"1.3.6.1.6.3.15.1.1.4.0": "unknown engine ID",
+	"1.3.6.1.6.3.15.1.1.5.0": "wrong digest, check the auth password",
+	"1.3.6.1.6.3.15.1.1.6.0": "decryption error, check the priv password",
+}
+
+func berLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	var encoded []byte
+	for length > 0 {
+		encoded = append([]byte{byte(length)}, encoded...)
+		length >>= 8
+	}
+	return append([]byte{0x80 | byte(len(encoded))}, encoded...)
+}
+
+func berTLV(tag byte, content ...[]byte) []byte {
+	joined := bytes.Join(content, nil)
+	return append(append([]byte{tag}, berLength(len(joined))...), joined...)
+}
+
+// berInt encodes an INTEGER in the fewest bytes that preserve its sign.
+func berInt(value int64) []byte {
+	content := binary.BigEndian.AppendUint64(nil, uint64(value))
+	for len(content) > 1 && (content[0] == 0 && content[1]&0x80 == 0 || content[0] == 0xff && content[1]&0x80 != 0) {
+		content = content[1:]
+	}
+	return berTLV(berInteger, content)
+}
+
+func berOID(oid string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", oid)
+	}
+
+	arcs := make([]uint64, len(parts))
+	for i, part := range parts {
+		arc, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", oid)
+		}
+		arcs[i] = arc
+	}
+
+	content := []byte{byte(arcs[0]*40 + arcs[1])}
+	for _, arc := range arcs[2:] {
+		encoded := []byte{byte(arc & 0x7f)}
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			encoded = append([]byte{0x80 | byte(arc&0x7f)}, encoded...)
+		}
+		content = append(content, encoded...)
+	}
+	return berTLV(berObjectID, content), nil
+}
+
+func parseBEROID(content []byte) string {
+	if len(content) == 0 {
+		return ""
+	}
+	arcs := []string{strconv.Itoa(int(content[0]) / 40), strconv.Itoa(int(content[0]) % 40)}
+	var arc uint64
+	for _, b := range content[1:] {
+		arc = arc<<7 | uint64(b&0x7f)
+		if b&0x80 == 0 {
+			arcs = append(arcs, strconv.FormatUint(arc, 10))
+			arc = 0
+		}
+	}
+	return strings.Join(arcs, ".")
+}
+
+// berRead reads a single TLV from the start of data. The content is a slice of
+// data, which lets the caller find its offset in the original message.
+func berRead(data []byte) (tag byte, content []byte, rest []byte, err error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errors.New("truncated BER value")
+	}
+	tag = data[0]
+	length := int(data[1])
+	offset := 2
+	if length&0x80 != 0 {
+		size := length & 0x7f
+		if size == 0 || size > 4 || len(data) < 2+size {
+			return 0, nil, nil, errors.New("invalid BER length")
+		}
+		length = 0
+		for _, b := range data[2 : 2+size] {
+			length = length<<8 | int(b)
+		}
+		offset += size
+	}
+	if len(data) < offset+length {
+		return 0, nil, nil, errors.New("truncated BER value")
+	}
+	return tag, data[offset : offset+length], data[offset+length:], nil
+}
+
+// berReadInt reads an INTEGER from the start of data.
+func berReadInt(data []byte) (int64, []byte, error) {
+	tag, content, rest, err := berRead(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if tag != berInteger || len(content) == 0 || len(content) > 8 {
+		return 0, nil, errors.New("expected an INTEGER")
+	}
+	value := int64(int8(content[0]))
+	for _, b := range content[1:] {
+		value = value<<8 | int64(b)
+	}
+	return value, rest, nil
+}
+
+// berReadString reads an OCTET STRING from the start of data.
+func berReadString(data []byte) ([]byte, []byte, error) {
+	tag, content, rest, err := berRead(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tag != berOctetString {
+		return nil, nil, errors.New("expected an OCTET STRING")
+	}
+	return content, rest, nil
+}
+
+func randomInt32() int64 {
+	var b [4]byte
+	rand.Read(b[:])
+	return int64(binary.BigEndian.Uint32(b[:]) & 0x7fffffff)
+}
+
+// getRequestPDU builds a GetRequest for the OID, or with no variable bindings
+// if the OID is nil, which is used for SNMPv3 engine discovery.
+func getRequestPDU(requestID int64, oid []byte) []byte {
+	var bindings []byte
+	if oid != nil {
+		bindings = berTLV(berSequence, oid, berTLV(berNull))
+	}
+	return berTLV(snmpGetRequest, berInt(requestID), berInt(0), berInt(0), berTLV(berSequence, bindings))
+}
+
+// parseResponsePDU returns the PDU type and the value of the first variable binding.
+func parseResponsePDU(data []byte, requestID int64) (byte, string, byte, []byte, error) {
+	tag, content, _, err := berRead(data)
+	if err != nil {
+		return 0, "", 0, nil, err
+	}
+	if tag != snmpResponse && tag != snmpReport {
+		return 0, "", 0, nil, fmt.Errorf("unexpected PDU type 0x%x", tag)
+	}
+
+	responseID, content, err := berReadInt(content)
+	if err != nil {
+		return 0, "", 0, nil, err
+	}
+	// Reports to discovery requests don't have to echo the request ID.
+	if tag == snmpResponse && responseID != requestID {
+		return 0, "", 0, nil, errors.New("response is for a different request")
+	}
+	errorStatus, content, err := berReadInt(content)
+	if err != nil {
+		return 0, "", 0, nil, err
+	}
+	if errorStatus != 0 {
+		return 0, "", 0, nil, fmt.Errorf("agent returned error status %d", errorStatus)
+	}
+	_, content, err = berReadInt(content)
+	if err != nil {
+		return 0, "", 0, nil, err
+	}
+
+	_, bindings, _, err := berRead(content)
+	if err != nil {
+		return 0, "", 0, nil, err
+	}
+	_, binding, _, err := berRead(bindings)
+	if err != nil {
+		return 0, "", 0, nil, fmt.Errorf("response has no variable bindings: %w", err)
+	}
+	oidTag, oid, value, err := berRead(binding)
+	if err != nil || oidTag != berObjectID {
+		return 0, "", 0, nil, errors.New("invalid variable binding")
+	}
+	valueTag, valueContent, _, err := berRead(value)
+	if err != nil {
+		return 0, "", 0, nil, err
+	}
+	return tag, parseBEROID(oid), valueTag, valueContent, nil
+}
+
+// snmpAddress converts the value of the configured OID to an IP address.
+func snmpAddress(tag byte, value []byte, recordType string) (string, error) {
+	switch tag {
+	case berNoSuchObject, berNoSuchInstance, berEndOfMIBView:
+		return "", errors.New("the OID doesn't exist on the agent")
+	case berIPAddress, berOctetString:
+	default:
+		return "", fmt.Errorf("the OID has an unsupported type 0x%x", tag)
+	}
+
+	// An IpAddress is always the raw bytes, but an OCTET STRING can be either
+	// those or the address as text. Text is tried first, because text like
+	// "1.2.3.4" that's padded to 16 bytes would otherwise be read as bytes.
+	var address netip.Addr
+	var err error
+	if tag == berOctetString {
+		address, err = netip.ParseAddr(strings.TrimSpace(string(value)))
+	}
+	if tag == berIPAddress || err != nil {
+		var ok bool
+		if address, ok = netip.AddrFromSlice(value); !ok {
+			return "", fmt.Errorf("the OID's value %q isn't an IP address", value)
+		}
+	}
+	address = address.Unmap()
+
+	if recordType == "A" && !address.Is4() || recordType == "AAAA" && !address.Is6() {
+		return "", fmt.Errorf("the OID's value %s is the wrong type of address for %s records", address, recordType)
+	}
+	return address.String(), nil
+}
+
+// exchange sends a request to the agent and waits for the response, retrying
+// twice if there isn't one.
+func (s *SNMPSource) exchange(ctx context.Context, request []byte) ([]byte, error) {
+	address := s.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "161")
+	}
+
+	conn, err := auditDial(ctx, "ip_lookup", "udp", address, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SNMP agent: %w", err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 65535)
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return nil, fmt.Errorf("failed to send SNMP request: %w", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err == nil {
+			return buf[:n], nil
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			return nil, fmt.Errorf("failed to read SNMP response: %w", err)
+		}
+	}
+	return nil, errors.New("SNMP agent didn't respond")
+}
+
+// currentIP gets the value of the OID from the agent.
+func (s *SNMPSource) currentIP(ctx context.Context, recordType string) (string, error) {
+	if s.Address == "" || s.OID == "" {
+		return "", errors.New("snmp IP source requires an address and an OID")
+	}
+	oid, err := berOID(s.OID)
+	if err != nil {
+		return "", err
+	}
+
+	var tag byte
+	var value []byte
+	switch s.Version {
+	case "1", "2c", "":
+		tag, value, err = s.getCommunity(ctx, oid)
+	case "3":
+		tag, value, err = s.getUSM(ctx, oid)
+	default:
+		return "", fmt.Errorf("unsupported SNMP version %q", s.Version)
+	}
+	if err != nil {
+		return "", err
+	}
+	return snmpAddress(tag, value, recordType)
+}
+
+// getCommunity gets the OID using SNMPv1 or SNMPv2c.
+func (s *SNMPSource) getCommunity(ctx context.Context, oid []byte) (byte, []byte, error) {
+	version := int64(1)
+	if s.Version == "1" {
+		version = 0
+	}
+	community := s.Community
+	if community == "" {
+		community = "public"
+	}
+
+	requestID := randomInt32()
+	request := berTLV(berSequence,
+		berInt(version),
+		berTLV(berOctetString, []byte(community)),
+		getRequestPDU(requestID, oid))
+
+	response, err := s.exchange(ctx, request)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	_, message, _, err := berRead(response)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid SNMP response: %w", err)
+	}
+	if _, message, err = berReadInt(message); err != nil {
+		return 0, nil, fmt.Errorf("invalid SNMP response: %w", err)
+	}
+	if _, message, err = berReadString(message); err != nil {
+		return 0, nil, fmt.Errorf("invalid SNMP response: %w", err)
+	}
+
+	_, _, tag, value, err := parseResponsePDU(message, requestID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid SNMP response: %w", err)
+	}
+	return tag, value, nil
+}
+
+// usmParameters are the User-based Security Model parameters of an SNMPv3 message.
+type usmParameters struct {
+	engineID    []byte
+	engineBoots int64
+	engineTime  int64
+	username    []byte
+	authParams  []byte
+	privParams  []byte
+}
+
+func (p *usmParameters) encode() []byte {
+	return berTLV(berSequence,
+		berTLV(berOctetString, p.engineID),
+		berInt(p.engineBoots),
+		berInt(p.engineTime),
+		berTLV(berOctetString, p.username),
+		berTLV(berOctetString, p.authParams),
+		berTLV(berOctetString, p.privParams))
+}
+
+// snmpV3Message is a parsed SNMPv3 message. The data is either a plaintext
+// scoped PDU, or the encrypted scoped PDU if encrypted is set.
+type snmpV3Message struct {
+	raw       []byte
+	msgID     int64
+	flags     byte
+	usm       usmParameters
+	encrypted bool
+	data      []byte
+}
+
+func parseSNMPv3Message(raw []byte) (*snmpV3Message, error) {
+	_, message, _, err := berRead(raw)
+	if err != nil {
+		return nil, err
+	}
+	version, message, err := berReadInt(message)
+	if err != nil {
+		return nil, err
+	}
+	if version != 3 {
+		return nil, fmt.Errorf("unexpected SNMP version %d", version)
+	}
+
+	_, header, message, err := berRead(message)
+	if err != nil {
+		return nil, err
+	}
+	msgID, header, err := berReadInt(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, header, err = berReadInt(header); err != nil {
+		return nil, err
+	}
+	flags, _, err := berReadString(header)
+	if err != nil || len(flags) != 1 {
+		return nil, errors.New("invalid message flags")
+	}
+
+	securityParameters, message, err := berReadString(message)
+	if err != nil {
+		return nil, err
+	}
+	_, usm, _, err := berRead(securityParameters)
+	if err != nil {
+		return nil, err
+	}
+	parsed := &snmpV3Message{raw: raw, msgID: msgID, flags: flags[0]}
+	if parsed.usm.engineID, usm, err = berReadString(usm); err != nil {
+		return nil, err
+	}
+	if parsed.usm.engineBoots, usm, err = berReadInt(usm); err != nil {
+		return nil, err
+	}
+	if parsed.usm.engineTime, usm, err = berReadInt(usm); err != nil {
+		return nil, err
+	}
+	if parsed.usm.username, usm, err = berReadString(usm); err != nil {
+		return nil, err
+	}
+	if parsed.usm.authParams, usm, err = berReadString(usm); err != nil {
+		return nil, err
+	}
+	if parsed.usm.privParams, _, err = berReadString(usm); err != nil {
+		return nil, err
+	}
+
+	tag, data, _, err := berRead(message)
+	if err != nil {
+		return nil, err
+	}
+	parsed.encrypted = tag == berOctetString
+	parsed.data = data
+	return parsed, nil
+}
+
+// snmpKeys are the keys for a user, localized to an agent's engine ID.
+type snmpKeys struct {
+	newHash func() hash.Hash
+	auth    []byte
+	priv    []byte
+}
+
+// localizeKey derives a user's key for an engine from a password, as described
+// in RFC 3414 section A.2.
+func localizeKey(newHash func() hash.Hash, password string, engineID []byte) []byte {
+	h := newHash()
+	repeated := bytes.Repeat([]byte(password), 1048576/len(password)+1)
+	h.Write(repeated[:1048576])
+	key := h.Sum(nil)
+
+	h.Reset()
+	h.Write(key)
+	h.Write(engineID)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+func (s *SNMPSource) keys(engineID []byte) (*snmpKeys, error) {
+	if s.AuthProtocol == "" {
+		if s.PrivProtocol != "" {
+			return nil, errors.New("SNMPv3 privacy requires an auth protocol")
+		}
+		return nil, nil
+	}
+
+	keys := &snmpKeys{}
+	switch strings.ToUpper(s.AuthProtocol) {
+	case "MD5":
+		keys.newHash = md5.New
+	case "SHA":
+		keys.newHash = sha1.New
+	default:
+		return nil, fmt.Errorf("unsupported SNMPv3 auth protocol %q", s.AuthProtocol)
+	}
+	if s.AuthPassword == "" {
+		return nil, errors.New("SNMPv3 auth requires an auth password")
+	}
+	keys.auth = localizeKey(keys.newHash, s.AuthPassword, engineID)
+
+	if s.PrivProtocol != "" {
+		switch strings.ToUpper(s.PrivProtocol) {
+		case "DES", "AES":
+		default:
+			return nil, fmt.Errorf("unsupported SNMPv3 priv protocol %q", s.PrivProtocol)
+		}
+		if s.PrivPassword == "" {
+			return nil, errors.New("SNMPv3 privacy requires a priv password")
+		}
+		// Both DES and AES-128 use the first 16 bytes of the localized key.
+		keys.priv = localizeKey(keys.newHash, s.PrivPassword, engineID)[:16]
+	}
+	return keys, nil
+}
+
+// authenticate returns the HMAC of a message whose auth parameters are zeroed,
+// truncated to 12 bytes.
+func (k *snmpKeys) authenticate(message []byte) []byte {
+	mac := hmac.New(k.newHash, k.auth)
+	mac.Write(message)
+	return mac.Sum(nil)[:12]
+}
+
+// encrypt encrypts the scoped PDU, returning the ciphertext and the privacy
+// parameters (the salt) to send with it.
+func (s *SNMPSource) encrypt(keys *snmpKeys, usm *usmParameters, scopedPDU []byte) ([]byte, []byte, error) {
+	salt := make([]byte, 8)
+	rand.Read(salt)
+
+	if strings.ToUpper(s.PrivProtocol) == "AES" {
+		block, err := aes.NewCipher(keys.priv)
+		if err != nil {
+			return nil, nil, err
+		}
+		ciphertext := make([]byte, len(scopedPDU))
+		cipher.NewCFBEncrypter(block, aesIV(usm.engineBoots, usm.engineTime, salt)).XORKeyStream(ciphertext, scopedPDU)
+		return ciphertext, salt, nil
+	}
+
+	// DES is padded to the block size, and the salt starts with the engine boots.
+	binary.BigEndian.PutUint32(salt, uint32(usm.engineBoots))
+	block, err := des.NewCipher(keys.priv[:8])
+	if err != nil {
+		return nil, nil, err
+	}
+	padded := append([]byte{}, scopedPDU...)
+	for len(padded)%des.BlockSize != 0 {
+		padded = append(padded, 0)
+	}
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, desIV(keys.priv, salt)).CryptBlocks(ciphertext, padded)
+	return ciphertext, salt, nil
+}
+
+func (s *SNMPSource) decrypt(keys *snmpKeys, usm *usmParameters, ciphertext []byte) ([]byte, error) {
+	if len(usm.privParams) != 8 {
+		return nil, errors.New("invalid privacy parameters")
+	}
+
+	if strings.ToUpper(s.PrivProtocol) == "AES" {
+		block, err := aes.NewCipher(keys.priv)
+		if err != nil {
+			return nil, err
+		}
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCFBDecrypter(block, aesIV(usm.engineBoots, usm.engineTime, usm.privParams)).XORKeyStream(plaintext, ciphertext)
+		return plaintext, nil
+	}
+
+	if len(ciphertext)%des.BlockSize != 0 {
+		return nil, errors.New("invalid DES ciphertext length")
+	}
+	block, err := des.NewCipher(keys.priv[:8])
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, desIV(keys.priv, usm.privParams)).CryptBlocks(plaintext, ciphertext)
+	return plaintext, nil
+}
+
+// aesIV is described in RFC 3826 section 3.1.2.1.
+func aesIV(engineBoots, engineTime int64, salt []byte) []byte {
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint32(iv[0:], uint32(engineBoots))
+	binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+	copy(iv[8:], salt)
+	return iv
+}
+
+// desIV is described in RFC 3414 section 8.1.1.1.
+func desIV(privKey []byte, salt []byte) []byte {
+	iv := make([]byte, 8)
+	for i := range iv {
+		iv[i] = privKey[8+i] ^ salt[i]
+	}
+	return iv
+}
+
+// buildSNMPv3Message builds a message containing the PDU, authenticating and
+// encrypting it if the keys are set.
+func (s *SNMPSource) buildSNMPv3Message(keys *snmpKeys, usm usmParameters, msgID int64, pdu []byte) ([]byte, error) {
+	flags := byte(0x04) // reportable
+	scopedPDU := berTLV(berSequence,
+		berTLV(berOctetString, usm.engineID),
+		berTLV(berOctetString, nil),
+		pdu)
+	data := scopedPDU
+
+	if keys != nil {
+		flags |= 0x01
+		usm.authParams = make([]byte, 12)
+		if keys.priv != nil {
+			flags |= 0x02
+			ciphertext, salt, err := s.encrypt(keys, &usm, scopedPDU)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt SNMP request: %w", err)
+			}
+			usm.privParams = salt
+			data = berTLV(berOctetString, ciphertext)
+		}
+	}
+
+	message := berTLV(berSequence,
+		berInt(3),
+		berTLV(berSequence, berInt(msgID), berInt(65507), berTLV(berOctetString, []byte{flags}), berInt(3)),
+		berTLV(berOctetString, usm.encode()),
+		data)
+
+	if keys != nil {
+		// The placeholder is the first run of 12 zero bytes after its header,
+		// because nothing before the security parameters can contain one.
+		placeholder := berTLV(berOctetString, make([]byte, 12))
+		offset := bytes.Index(message, placeholder) + 2
+		copy(message[offset:], keys.authenticate(message))
+	}
+	return message, nil
+}
+
+// getUSM gets the OID using SNMPv3, first discovering the agent's engine ID,
+// boots, and time, which are needed to authenticate requests.
+func (s *SNMPSource) getUSM(ctx context.Context, oid []byte) (byte, []byte, error) {
+	discovery, err := s.buildSNMPv3Message(nil, usmParameters{}, randomInt32(), getRequestPDU(randomInt32(), nil))
+	if err != nil {
+		return 0, nil, err
+	}
+	response, err := s.exchange(ctx, discovery)
+	if err != nil {
+		return 0, nil, err
+	}
+	report, err := parseSNMPv3Message(response)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid SNMP discovery response: %w", err)
+	}
+
+	keys, err := s.keys(report.usm.engineID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	usm := usmParameters{
+		engineID:    report.usm.engineID,
+		engineBoots: report.usm.engineBoots,
+		engineTime:  report.usm.engineTime,
+		username:    []byte(s.Username),
+	}
+	msgID, requestID := randomInt32(), randomInt32()
+	request, err := s.buildSNMPv3Message(keys, usm, msgID, getRequestPDU(requestID, oid))
+	if err != nil {
+		return 0, nil, err
+	}
+	response, err = s.exchange(ctx, request)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	message, err := parseSNMPv3Message(response)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid SNMP response: %w", err)
+	}
+	if message.msgID != msgID {
+		return 0, nil, errors.New("invalid SNMP response: response is for a different request")
+	}
+
+	// Anyone who can send a UDP packet to clouddns could forge a response
+	// without authentication, so only reports, which are how the agent says
+	// that it couldn't authenticate the request, are read from one, and only
+	// to say why the request failed.
+	authenticated := false
+	if keys != nil && message.flags&0x01 != 0 {
+		if len(message.usm.authParams) != 12 {
+			return 0, nil, errors.New("invalid SNMP response: invalid auth parameters")
+		}
+		// The auth parameters are a slice of the raw message, so their offset
+		// can be found from the difference in capacity.
+		offset := cap(message.raw) - cap(message.usm.authParams)
+		zeroed := bytes.Clone(message.raw)
+		copy(zeroed[offset:offset+12], make([]byte, 12))
+		if !hmac.Equal(keys.authenticate(zeroed), message.usm.authParams) {
+			return 0, nil, errors.New("SNMP response failed authentication")
+		}
+		authenticated = true
+	}
+
+	scopedPDU := message.data
+	if message.encrypted {
+		if !authenticated {
+			return 0, nil, errors.New("SNMP response is encrypted but isn't authenticated")
+		}
+		if keys == nil || keys.priv == nil {
+			return 0, nil, errors.New("SNMP response is encrypted, but no priv protocol is configured")
+		}
+		if scopedPDU, err = s.decrypt(keys, &message.usm, scopedPDU); err != nil {
+			return 0, nil, fmt.Errorf("failed to decrypt SNMP response: %w", err)
+		}
+		// The plaintext may be followed by padding, which berRead ignores.
+		_, scopedPDU, _, err = berRead(scopedPDU)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to decrypt SNMP response: %w", err)
+		}
+	}
+
+	// The scoped PDU starts with the context engine ID and context name.
+	if _, scopedPDU, err = berReadString(scopedPDU); err != nil {
+		return 0, nil, fmt.Errorf("invalid SNMP response: %w", err)
+	}
+	if _, scopedPDU, err = berReadString(scopedPDU); err != nil {
+		return 0, nil, fmt.Errorf("invalid SNMP response: %w", err)
+	}
+
+	pduType, responseOID, tag, value, err := parseResponsePDU(scopedPDU, requestID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid SNMP response: %w", err)
+	}
+	if pduType == snmpReport {
+		if reason, ok := snmpReportErrors[responseOID]; ok {
+			return 0, nil, fmt.Errorf("SNMP agent rejected the request: %s", reason)
+		}
+		return 0, nil, fmt.Errorf("SNMP agent rejected the request with report %s", responseOID)
+	}
+	if keys != nil && !authenticated {
+		return 0, nil, errors.New("SNMP response isn't authenticated, so it was ignored")
+	}
+	if keys != nil && keys.priv != nil && !message.encrypted {
+		return 0, nil, errors.New("SNMP response isn't encrypted, so it was ignored")
+	}
+	return tag, value, nil
+}
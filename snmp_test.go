@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"hash"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestSNMPAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		tag        byte
+		value      []byte
+		recordType string
+		want       string
+		wantErr    string
+	}{
+		{"ip address", berIPAddress, []byte{203, 0, 113, 7}, "A", "203.0.113.7", ""},
+		{"raw octet string", berOctetString, []byte{203, 0, 113, 7}, "A", "203.0.113.7", ""},
+		{"text octet string", berOctetString, []byte("203.0.113.7"), "A", "203.0.113.7", ""},
+		// Text that happens to be 4 or 16 bytes long is still text.
+		{"four byte text", berOctetString, []byte("1::1"), "AAAA", "1::1", ""},
+		{"sixteen byte text", berOctetString, []byte("203.0.113.7     "), "A", "203.0.113.7", ""},
+		{"raw ipv6", berOctetString, net.ParseIP("2001:db8::1"), "AAAA", "2001:db8::1", ""},
+		{"mapped ipv4", berOctetString, net.ParseIP("203.0.113.7").To16(), "A", "203.0.113.7", ""},
+		{"ip address is never text", berIPAddress, []byte("1::1"), "A", "49.58.58.49", ""},
+		{"wrong family", berIPAddress, []byte{203, 0, 113, 7}, "AAAA", "", "wrong type of address"},
+		{"garbage", berOctetString, []byte("router"), "A", "", "isn't an IP address"},
+		{"no such object", berNoSuchObject, nil, "A", "", "doesn't exist"},
+		{"no such instance", berNoSuchInstance, nil, "A", "", "doesn't exist"},
+		{"integer", berInteger, []byte{1}, "A", "", "unsupported type"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := snmpAddress(test.tag, test.value, test.recordType)
+			checkError(t, err, test.wantErr)
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseResponsePDU(t *testing.T) {
+	oid, err := berOID("1.3.6.1.2.1.4.20.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := berTLV(berIPAddress, []byte{203, 0, 113, 7})
+
+	tests := []struct {
+		name      string
+		pdu       []byte
+		wantType  byte
+		wantOID   string
+		wantTag   byte
+		wantValue []byte
+		// wantErr is part of the error, or "malformed" if it only has to fail.
+		wantErr string
+	}{
+		{
+			name:      "response",
+			pdu:       snmpTestPDU(snmpResponse, 42, 0, oid, address),
+			wantType:  snmpResponse,
+			wantOID:   "1.3.6.1.2.1.4.20.1.1",
+			wantTag:   berIPAddress,
+			wantValue: []byte{203, 0, 113, 7},
+		},
+		{
+			name:      "report with another request ID",
+			pdu:       snmpTestPDU(snmpReport, 7, 0, snmpTestOID(t, "1.3.6.1.6.3.15.1.1.4.0"), berTLV(0x41, []byte{1})),
+			wantType:  snmpReport,
+			wantOID:   "1.3.6.1.6.3.15.1.1.4.0",
+			wantTag:   0x41,
+			wantValue: []byte{1},
+		},
+		{
+			name:    "response with another request ID",
+			pdu:     snmpTestPDU(snmpResponse, 7, 0, oid, address),
+			wantErr: "different request",
+		},
+		{
+			name:    "error status",
+			pdu:     snmpTestPDU(snmpResponse, 42, 2, oid, address),
+			wantErr: "error status 2",
+		},
+		{
+			name:    "request",
+			pdu:     snmpTestPDU(snmpGetRequest, 42, 0, oid, address),
+			wantErr: "unexpected PDU type",
+		},
+		{
+			name:    "no variable bindings",
+			pdu:     berTLV(snmpResponse, berInt(42), berInt(0), berInt(0), berTLV(berSequence)),
+			wantErr: "no variable bindings",
+		},
+		{
+			name:    "empty",
+			pdu:     nil,
+			wantErr: "malformed",
+		},
+		{
+			name:    "truncated",
+			pdu:     snmpTestPDU(snmpResponse, 42, 0, oid, address)[:10],
+			wantErr: "malformed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pduType, gotOID, tag, value, err := parseResponsePDU(test.pdu, 42)
+			if test.wantErr == "malformed" {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				return
+			}
+			if pduType != test.wantType || gotOID != test.wantOID || tag != test.wantTag || string(value) != string(test.wantValue) {
+				t.Errorf("got (0x%x, %s, 0x%x, %v), want (0x%x, %s, 0x%x, %v)",
+					pduType, gotOID, tag, value, test.wantType, test.wantOID, test.wantTag, test.wantValue)
+			}
+		})
+	}
+}
+
+// TestLocalizeKey uses the test vectors from RFC 3414 section A.3.
+func TestLocalizeKey(t *testing.T) {
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	tests := []struct {
+		name    string
+		newHash func() hash.Hash
+		want    string
+	}{
+		{"MD5", md5.New, "526f5eed9fcce26f8964c2930787d82b"},
+		{"SHA", sha1.New, "6695febc9288e36282235fc7151f128497b38f3f"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := hex.EncodeToString(localizeKey(test.newHash, "maplesyrup", engineID))
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSNMPv3Authentication(t *testing.T) {
+	const wanOID = "1.3.6.1.2.1.4.20.1.1"
+	noAuth := SNMPSource{Username: "ddns"}
+	auth := SNMPSource{Username: "ddns", AuthProtocol: "SHA", AuthPassword: "authpassword"}
+	wrongAuth := SNMPSource{Username: "ddns", AuthProtocol: "SHA", AuthPassword: "wrongpassword"}
+	authPrivAES := SNMPSource{Username: "ddns", AuthProtocol: "SHA", AuthPassword: "authpassword", PrivProtocol: "AES", PrivPassword: "privpassword"}
+	authPrivDES := SNMPSource{Username: "ddns", AuthProtocol: "MD5", AuthPassword: "authpassword", PrivProtocol: "DES", PrivPassword: "privpassword"}
+
+	tests := []struct {
+		name string
+		// client is how clouddns is configured, and agent is how the response
+		// is authenticated and encrypted.
+		client  SNMPSource
+		agent   SNMPSource
+		report  string
+		want    string
+		wantErr string
+	}{
+		{name: "no auth", client: noAuth, agent: noAuth, want: "203.0.113.7"},
+		{name: "auth", client: auth, agent: auth, want: "203.0.113.7"},
+		{name: "auth and AES", client: authPrivAES, agent: authPrivAES, want: "203.0.113.7"},
+		{name: "auth and DES", client: authPrivDES, agent: authPrivDES, want: "203.0.113.7"},
+		{name: "forged without auth", client: auth, agent: noAuth, wantErr: "isn't authenticated"},
+		{name: "forged with the wrong key", client: auth, agent: wrongAuth, wantErr: "failed authentication"},
+		{name: "forged without privacy", client: authPrivAES, agent: auth, wantErr: "isn't encrypted"},
+		{name: "encrypted without auth configured", client: noAuth, agent: authPrivAES, wantErr: "isn't authenticated"},
+		{
+			name:    "unauthenticated report",
+			client:  auth,
+			agent:   noAuth,
+			report:  "1.3.6.1.6.3.15.1.1.3.0",
+			wantErr: "unknown user name",
+		},
+		{
+			name:    "unknown report",
+			client:  auth,
+			agent:   noAuth,
+			report:  "1.3.6.1.6.3.15.1.1.9.0",
+			wantErr: "report 1.3.6.1.6.3.15.1.1.9.0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := test.client
+			client.Version = "3"
+			client.OID = wanOID
+			client.Address = fakeSNMPAgent(t, &test.client, &test.agent, test.report)
+
+			got, err := client.currentIP(context.Background(), "A")
+			checkError(t, err, test.wantErr)
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// fakeSNMPAgent answers an engine discovery request, and then a request from
+// the client with the agent's settings, returning the agent's address. If the
+// report is set, the request is answered with a report for that OID instead.
+func fakeSNMPAgent(t *testing.T, client *SNMPSource, agent *SNMPSource, report string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	engine := usmParameters{engineID: []byte{0x80, 0, 0x1f, 0x88, 4, 't', 'e', 's', 't'}, engineBoots: 3, engineTime: 1200}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request, err := parseSNMPv3Message(buf[:n])
+			if err != nil {
+				t.Errorf("agent got an invalid request: %v", err)
+				return
+			}
+
+			var keys *snmpKeys
+			var pdu []byte
+			if len(request.usm.username) == 0 {
+				// Discovery is answered with the engine's parameters.
+				pdu = snmpTestPDU(snmpReport, 0, 0, snmpTestOID(t, "1.3.6.1.6.3.15.1.1.4.0"), berTLV(0x41, []byte{1}))
+			} else {
+				requestID, err := snmpTestRequestID(client, request)
+				if err != nil {
+					t.Errorf("agent couldn't read the request: %v", err)
+					return
+				}
+				if keys, err = agent.keys(engine.engineID); err != nil {
+					t.Errorf("agent has invalid settings: %v", err)
+					return
+				}
+				if report != "" {
+					pdu = snmpTestPDU(snmpReport, requestID, 0, snmpTestOID(t, report), berTLV(0x41, []byte{1}))
+				} else {
+					pdu = snmpTestPDU(snmpResponse, requestID, 0, snmpTestOID(t, "1.3.6.1.2.1.4.20.1.1"), berTLV(berIPAddress, []byte{203, 0, 113, 7}))
+				}
+			}
+
+			usm := engine
+			usm.username = request.usm.username
+			response, err := agent.buildSNMPv3Message(keys, usm, request.msgID, pdu)
+			if err != nil {
+				t.Errorf("agent couldn't build the response: %v", err)
+				return
+			}
+			conn.WriteTo(response, from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// snmpTestRequestID reads the request ID from a request, decrypting it with
+// the client's keys if it's encrypted.
+func snmpTestRequestID(client *SNMPSource, request *snmpV3Message) (int64, error) {
+	scopedPDU := request.data
+	if request.encrypted {
+		keys, err := client.keys(request.usm.engineID)
+		if err != nil {
+			return 0, err
+		}
+		if scopedPDU, err = client.decrypt(keys, &request.usm, scopedPDU); err != nil {
+			return 0, err
+		}
+		if _, scopedPDU, _, err = berRead(scopedPDU); err != nil {
+			return 0, err
+		}
+	}
+	_, scopedPDU, err := berReadString(scopedPDU)
+	if err != nil {
+		return 0, err
+	}
+	if _, scopedPDU, err = berReadString(scopedPDU); err != nil {
+		return 0, err
+	}
+	_, pdu, _, err := berRead(scopedPDU)
+	if err != nil {
+		return 0, err
+	}
+	requestID, _, err := berReadInt(pdu)
+	return requestID, err
+}
+
+// snmpTestPDU builds a PDU with a single variable binding.
+func snmpTestPDU(pduType byte, requestID int64, errorStatus int64, oid []byte, value []byte) []byte {
+	return berTLV(pduType, berInt(requestID), berInt(errorStatus), berInt(0),
+		berTLV(berSequence, berTLV(berSequence, oid, value)))
+}
+
+func snmpTestOID(t *testing.T, oid string) []byte {
+	t.Helper()
+	encoded, err := berOID(oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+// checkError fails the test unless the error contains want, or is nil if want
+// is empty.
+func checkError(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("got error %v", err)
+	case want != "" && err == nil:
+		t.Fatalf("got no error, want one containing %q", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Fatalf("got error %v, want one containing %q", err, want)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"net"
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes changes to the umask, which is shared by the whole
+// process.
+var umaskMu sync.Mutex
+
+// listenPrivate listens on a Unix domain socket that only the user clouddns
+// runs as can connect to. The socket is created with that mode rather than
+// changed afterwards, so nobody else can connect in between.
+func listenPrivate(path string) (net.Listener, error) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	old := syscall.Umask(0o077)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
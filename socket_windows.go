@@ -0,0 +1,9 @@
+package main
+
+import "net"
+
+// listenPrivate listens on a Unix domain socket. Windows checks access to
+// sockets differently and ignores their mode, so it's created as is.
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
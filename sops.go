@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// isSOPSEncrypted reports whether a parsed configuration file was encrypted
+// with SOPS, which adds a top-level "sops" object holding its metadata.
+func isSOPSEncrypted(value any) bool {
+	config, ok := value.(map[string]any)
+	if !ok {
+		return false
+	}
+	metadata, ok := config["sops"].(map[string]any)
+	if !ok {
+		return false
+	}
+	_, ok = metadata["mac"]
+	return ok
+}
+
+// decryptSOPS decrypts a configuration file with the sops command, which
+// handles every kind of key that SOPS supports, such as age and PGP, and finds
+// the keys the same way it does when it's run by hand. The decrypted
+// configuration is returned as JSON and is never written to disk.
+func decryptSOPS(format string, data []byte) ([]byte, error) {
+	if format != "json" && format != "yaml" {
+		return nil, fmt.Errorf("SOPS encrypted %s files aren't supported", format)
+	}
+
+	sops, err := exec.LookPath("sops")
+	if err != nil {
+		return nil, fmt.Errorf("the configuration is encrypted with SOPS, but sops isn't installed: %w", err)
+	}
+
+	// The encrypted file might have come from stdin or a URL, so it's always
+	// given to sops as a temporary file. It's still encrypted, so nothing is
+	// leaked if it's left behind.
+	file, err := os.CreateTemp("", "clouddns-*."+format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(sops, "--decrypt", "--input-type", format, "--output-type", "json", file.Name())
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("failed to decrypt with sops: %w: %s", err, message)
+		}
+		return nil, fmt.Errorf("failed to decrypt with sops: %w", err)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sourceHealthFileName is the name of the file in the cache directory that the
+// health of IP sources is kept in between runs.
+const sourceHealthFileName = "ip_source_health.json"
+
+// sourceRecoveryTime is how long a source that failed stays at the end of the
+// list. After that, it's tried in its usual place again, so that a source that
+// had a bad day isn't ignored forever.
+const sourceRecoveryTime = time.Hour
+
+// sourceStats is what's known about how an IP source has behaved recently.
+type sourceStats struct {
+	// Latency is a moving average of how long the source takes to answer.
+	Latency time.Duration `json:"latency"`
+	// Failures is how many times in a row the source has failed, including
+	// timing out and disagreeing with the other sources.
+	Failures    int       `json:"failures,omitempty"`
+	LastFailure time.Time `json:"last_failure,omitzero"`
+}
+
+// demoted reports whether the source failed recently enough that it should
+// only be tried after the others.
+func (s *sourceStats) demoted(now time.Time) bool {
+	return s.Failures > 0 && now.Sub(s.LastFailure) < sourceRecoveryTime
+}
+
+// sourceHealth keeps track of how quickly and reliably each IP source answers,
+// so that lookups can try the best ones first.
+type sourceHealth struct {
+	mu      sync.Mutex
+	Sources map[string]*sourceStats `json:"sources"`
+}
+
+// sourceKey identifies the source for the record type, because a source can be
+// fast for one family and unreachable over the other. The source is hashed from
+// its type and where it connects to, and never its credentials, because the
+// keys are saved in the cache directory.
+func sourceKey(source *IPSource, recordType string) string {
+	sum := sha256.Sum256([]byte(source.Type + " " + source.describe()))
+	return recordType + " " + hex.EncodeToString(sum[:])
+}
+
+// isSourceKey reports whether the key was made by sourceKey. Files written by
+// older versions used the source's settings as the key, which can include its
+// credentials, so those keys are dropped when the file is read.
+func isSourceKey(key string) bool {
+	_, sum, ok := strings.Cut(key, " ")
+	decoded, err := hex.DecodeString(sum)
+	return ok && err == nil && len(decoded) == sha256.Size
+}
+
+// stats returns the stats of the source, creating them if they don't exist.
+// The lock must be held.
+func (h *sourceHealth) stats(source *IPSource, recordType string) *sourceStats {
+	if h.Sources == nil {
+		h.Sources = map[string]*sourceStats{}
+	}
+	key := sourceKey(source, recordType)
+	if h.Sources[key] == nil {
+		h.Sources[key] = &sourceStats{}
+	}
+	return h.Sources[key]
+}
+
+// succeeded records that the source answered in the given time.
+func (h *sourceHealth) succeeded(source *IPSource, recordType string, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := h.stats(source, recordType)
+	if stats.Latency == 0 {
+		stats.Latency = latency
+	} else {
+		stats.Latency = (stats.Latency*7 + latency*3) / 10
+	}
+	stats.Failures = 0
+	stats.LastFailure = time.Time{}
+}
+
+// failed records that the source failed, or returned an address that the
+// other sources didn't agree with.
+func (h *sourceHealth) failed(source *IPSource, recordType string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := h.stats(source, recordType)
+	stats.Failures++
+	stats.LastFailure = time.Now()
+}
+
+// order returns the sources sorted so that the ones that answer fastest come
+// first and the ones that failed recently come last. Sources that haven't been
+// used yet come first, so that they're measured, and sources that are equally
+// good stay in the configured order.
+func (h *sourceHealth) order(sources IPSourceList, recordType string) IPSourceList {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	var unknown sourceStats
+	statsOf := func(source *IPSource) *sourceStats {
+		if stats := h.Sources[sourceKey(source, recordType)]; stats != nil {
+			return stats
+		}
+		return &unknown
+	}
+
+	ordered := slices.Clone(sources)
+	slices.SortStableFunc(ordered, func(a, b IPSource) int {
+		statsA, statsB := statsOf(&a), statsOf(&b)
+		demotedA, demotedB := statsA.demoted(now), statsB.demoted(now)
+		switch {
+		case demotedA && demotedB:
+			return cmp.Compare(statsA.Failures, statsB.Failures)
+		case demotedA:
+			return 1
+		case demotedB:
+			return -1
+		}
+		return cmp.Compare(statsA.Latency, statsB.Latency)
+	})
+	return ordered
+}
+
+// loadSourceHealth reads the health of the IP sources from the cache directory.
+// Without a cache directory, or before the file is first written, every
+// source is treated as healthy.
+func loadSourceHealth(basePath string) (*sourceHealth, error) {
+	health := &sourceHealth{}
+	if basePath == "" {
+		return health, nil
+	}
+	data, err := os.ReadFile(filepath.Join(basePath, sourceHealthFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return health, nil
+		}
+		return health, fmt.Errorf("failed to read IP source health: %w", err)
+	}
+	if err := json.Unmarshal(data, health); err != nil {
+		return &sourceHealth{}, fmt.Errorf("failed to parse IP source health: %w", err)
+	}
+	maps.DeleteFunc(health.Sources, func(key string, _ *sourceStats) bool {
+		return !isSourceKey(key)
+	})
+	return health, nil
+}
+
+// save writes the health of the IP sources to the cache directory, where only
+// the user that clouddns runs as can read it.
+func (h *sourceHealth) save(basePath string) error {
+	h.mu.Lock()
+	data, err := json.MarshalIndent(h, "", "  ")
+	h.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal IP source health: %w", err)
+	}
+	if err := writeFileAtomically(filepath.Join(basePath, sourceHealthFileName), data, 0600); err != nil {
+		return fmt.Errorf("failed to write IP source health: %w", err)
+	}
+	return nil
+}
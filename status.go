@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// statusFileName is the name of the file in the cache directory that the
+// outcome of the most recent run is written to.
+const statusFileName = "status.json"
+
+const (
+	recordUpdated   = "updated"
+	recordUnchanged = "unchanged"
+	recordFailed    = "failed"
+)
+
+// RecordStatus is the outcome of syncing a single record
+type RecordStatus struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	RecordID string `json:"record_id"`
+	IP       string `json:"ip,omitempty"`
+	// Result is one of "updated", "unchanged", or "failed".
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RunStatus is the outcome of a run, which is written to the status file
+type RunStatus struct {
+	StartTime  time.Time      `json:"start_time"`
+	FinishTime time.Time      `json:"finish_time"`
+	Records    []RecordStatus `json:"records"`
+}
+
+// failedRecords returns the number of records that failed to sync.
+func (s *RunStatus) failedRecords() int {
+	failed := 0
+	for _, record := range s.Records {
+		if record.Result == recordFailed {
+			failed++
+		}
+	}
+	return failed
+}
+
+func writeRunStatus(basePath string, status *RunStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal status: %w", err)
+	}
+
+	// A healthcheck never reads a partially written file.
+	if err := writeFileAtomically(filepath.Join(basePath, statusFileName), data, 0644); err != nil {
+		return fmt.Errorf("failed to write status file: %w", err)
+	}
+	return nil
+}
+
+func readRunStatus(basePath string) (*RunStatus, error) {
+	data, err := os.ReadFile(filepath.Join(basePath, statusFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status file: %w", err)
+	}
+
+	var status RunStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse status file: %w", err)
+	}
+	return &status, nil
+}
+
+// healthcheckCommand handles "clouddns healthcheck", which succeeds if the most
+// recent run finished recently and every record was synced successfully.
+func healthcheckCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	maxAge := flags.Duration("max-age", 30*time.Minute, "maximum time since the last run finished")
+	flags.Parse(args)
+
+	baseCachePath := getCachePath()
+	if baseCachePath == "" {
+		return fmt.Errorf("DDNS_CACHE_PATH must be set, because the status file is stored in the cache directory")
+	}
+
+	status, err := readRunStatus(baseCachePath)
+	if err != nil {
+		return err
+	}
+
+	age := time.Since(status.FinishTime)
+	if age > *maxAge {
+		return fmt.Errorf("last run finished %s ago, which is longer than %s", age.Round(time.Second), *maxAge)
+	}
+
+	if failed := status.failedRecords(); failed > 0 {
+		return fmt.Errorf("%d of %d records failed to sync in the last run", failed, len(status.Records))
+	}
+
+	logger.Info("Healthy",
+		"last_run", status.FinishTime,
+		"record_count", len(status.Records))
+	return nil
+}
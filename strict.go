@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// shouldLoadStrictly reports whether mistakes in the configuration, like
+// misspelled keys, should stop it from loading instead of being ignored.
+func shouldLoadStrictly() bool {
+	value := os.Getenv("DDNS_STRICT")
+	return value == "1" || value == "true"
+}
+
+// unknownFields returns the path of every key in the configuration that isn't
+// a field of the type it's decoded into. Keys starting with "x-" are allowed
+// anywhere, so that they can be used for YAML anchors and notes.
+func unknownFields(value any, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		items, ok := value.([]any)
+		if !ok {
+			// Lists like ip_sources can also be written as a single item.
+			return unknownFields(value, t.Elem(), path)
+		}
+		var unknown []string
+		for i, item := range items {
+			unknown = append(unknown, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return unknown
+
+	case reflect.Map:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		var unknown []string
+		for key, item := range object {
+			unknown = append(unknown, unknownFields(item, t.Elem(), path+"."+key)...)
+		}
+		slices.Sort(unknown)
+		return unknown
+
+	case reflect.Struct:
+		// Types like Endpoint can also be written as a string, so anything
+		// other than an object is left to the decoder.
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		fields := jsonFields(t)
+		var unknown []string
+		for key, item := range object {
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+			if strings.HasPrefix(key, "x-") {
+				continue
+			}
+			field, ok := fields[key]
+			if !ok {
+				unknown = append(unknown, fieldPath)
+				continue
+			}
+			unknown = append(unknown, unknownFields(item, field, fieldPath)...)
+		}
+		// Map iteration order is random, but the keys should be reported in the
+		// same order every time.
+		slices.Sort(unknown)
+		return unknown
+	}
+	return nil
+}
+
+// jsonFields returns the type of every field of the struct by its JSON name,
+// including the fields of embedded structs.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for name, embedded := range jsonFields(field.Type) {
+				fields[name] = embedded
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// checkStrictly returns an error listing every unknown key and every problem
+// that validateConfiguration finds, or nil if there aren't any.
+func checkStrictly(configJSON []byte, configuration *DNSConfiguration) error {
+	value, err := decodeConfigJSON(configJSON)
+	if err != nil {
+		return err
+	}
+
+	var problems []error
+	for _, path := range unknownFields(value, reflect.TypeFor[DNSConfiguration](), "") {
+		problems = append(problems, fmt.Errorf("%s: unknown key", path))
+	}
+	for _, problem := range validateConfiguration(configuration) {
+		problems = append(problems, errors.New(problem))
+	}
+	return errors.Join(problems...)
+}
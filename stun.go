@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// STUNSource finds the public address with a STUN binding request, which
+// works on networks where UDP is allowed out but HTTP echo services aren't.
+type STUNSource struct {
+	// Server is the host of the STUN server, optionally with a port. The
+	// default is stun.l.google.com:19302, and the default port is 3478.
+	Server string `json:"server,omitempty"`
+}
+
+// STUN message types and attributes, from RFC 5389.
+const (
+	stunBindingRequest     = 0x0001
+	stunBindingSuccess     = 0x0101
+	stunMagicCookie        = 0x2112a442
+	stunMappedAddress      = 0x0001
+	stunXORMappedAddress   = 0x0020
+	stunXORMappedAddressMS = 0x8020
+)
+
+// currentIP sends a binding request over the address family of the record
+// type and returns the address that the server saw it come from.
+func (s *STUNSource) currentIP(ctx context.Context, recordType string) (string, error) {
+	server := s.Server
+	if server == "" {
+		server = "stun.l.google.com:19302"
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "3478")
+	}
+	network := "udp4"
+	if recordType == "AAAA" {
+		network = "udp6"
+	}
+
+	var transactionID [12]byte
+	rand.Read(transactionID[:])
+	request := binary.BigEndian.AppendUint16(nil, stunBindingRequest)
+	request = binary.BigEndian.AppendUint16(request, 0)
+	request = binary.BigEndian.AppendUint32(request, stunMagicCookie)
+	request = append(request, transactionID[:]...)
+
+	conn, err := auditDial(ctx, "ip_lookup", network, server, 5*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to STUN server: %w", err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return "", fmt.Errorf("failed to send STUN request: %w", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, err := conn.Read(buf)
+		if err == nil {
+			if n < 20 || !bytes.Equal(buf[8:20], transactionID[:]) {
+				continue
+			}
+			return parseSTUNResponse(buf[:n])
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			return "", fmt.Errorf("failed to read STUN response: %w", err)
+		}
+	}
+	return "", errors.New("STUN server didn't respond")
+}
+
+// parseSTUNResponse returns the mapped address from a binding response,
+// preferring the XOR-MAPPED-ADDRESS attribute, which NATs that rewrite
+// addresses in packets can't mangle.
+func parseSTUNResponse(message []byte) (string, error) {
+	if binary.BigEndian.Uint16(message[0:2]) != stunBindingSuccess {
+		return "", fmt.Errorf("STUN server returned message type %#04x", binary.BigEndian.Uint16(message[0:2]))
+	}
+	length := int(binary.BigEndian.Uint16(message[2:4]))
+	if 20+length > len(message) {
+		return "", errors.New("STUN response is truncated")
+	}
+
+	var mapped netip.Addr
+	attributes := message[20 : 20+length]
+	for len(attributes) >= 4 {
+		attrType := binary.BigEndian.Uint16(attributes[0:2])
+		attrLength := int(binary.BigEndian.Uint16(attributes[2:4]))
+		if 4+attrLength > len(attributes) {
+			return "", errors.New("STUN response is truncated")
+		}
+		value := attributes[4 : 4+attrLength]
+		// Attributes are padded to a multiple of 4 bytes.
+		attributes = attributes[min(4+(attrLength+3)&^3, len(attributes)):]
+
+		// The value is a reserved byte, the family, the port, then the address.
+		if len(value) < 8 {
+			continue
+		}
+		address := append([]byte{}, value[4:]...)
+		switch attrType {
+		case stunXORMappedAddress, stunXORMappedAddressMS:
+			// The address is XORed with the magic cookie followed by the
+			// transaction ID.
+			key := message[4:20]
+			for i := range address {
+				address[i] ^= key[i]
+			}
+			if ip, ok := netip.AddrFromSlice(address); ok {
+				return ip.Unmap().String(), nil
+			}
+		case stunMappedAddress:
+			if ip, ok := netip.AddrFromSlice(address); ok {
+				mapped = ip
+			}
+		}
+	}
+	if mapped.IsValid() {
+		return mapped.Unmap().String(), nil
+	}
+	return "", errors.New("STUN response doesn't contain a mapped address")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+// TailscaleSource reads the node's Tailscale address from the local tailscaled
+// API, for records in a zone that's only reachable over the tailnet.
+type TailscaleSource struct {
+	// Socket is the path to tailscaled's Unix domain socket. The default is
+	// /var/run/tailscale/tailscaled.sock.
+	Socket string `json:"socket,omitempty"`
+}
+
+// tailscaleStatus is the part of the local API's status that has the node's
+// addresses.
+type tailscaleStatus struct {
+	BackendState string `json:"BackendState"`
+	Self         *struct {
+		TailscaleIPs []string `json:"TailscaleIPs"`
+	} `json:"Self"`
+}
+
+// currentIP returns the node's Tailscale address of the record type's family.
+// These are never public addresses, so the usual check for that is skipped.
+func (s *TailscaleSource) currentIP(recordType string) (string, error) {
+	socket := s.Socket
+	if socket == "" {
+		socket = "/var/run/tailscale/tailscaled.sock"
+	}
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	// The local API only accepts requests for this host name.
+	req, err := http.NewRequest("GET", "http://local-tailscaled.sock/localapi/v0/status?peers=false", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	var status tailscaleStatus
+	if err := doJSON(client, req, &status); err != nil {
+		return "", fmt.Errorf("failed to get status from tailscaled: %w", err)
+	}
+	if status.BackendState != "Running" || status.Self == nil {
+		return "", fmt.Errorf("tailscale isn't connected, its state is %q", status.BackendState)
+	}
+
+	for _, value := range status.Self.TailscaleIPs {
+		address, err := netip.ParseAddr(value)
+		if err != nil {
+			continue
+		}
+		if address.Is4() == (recordType == "A") {
+			return address.String(), nil
+		}
+	}
+	return "", fmt.Errorf("tailscale has no %s address for this node", recordType)
+}
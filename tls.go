@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TLSConfig holds the TLS settings used for outbound HTTPS requests.
+type TLSConfig struct {
+	// CAFile is the path to a PEM bundle of CA certificates to trust in addition
+	// to the system roots.
+	CAFile string `json:"ca_file,omitempty"`
+	// CADir is the path to a directory of PEM files containing CA certificates to
+	// trust in addition to the system roots. Files that don't contain any
+	// certificates are ignored.
+	CADir string `json:"ca_dir,omitempty"`
+	// CertFile is the path to a PEM encoded client certificate, used to
+	// authenticate with servers that require mutual TLS. KeyFile must also be set.
+	CertFile string `json:"cert_file,omitempty"`
+	// KeyFile is the path to the PEM encoded private key for CertFile.
+	KeyFile string `json:"key_file,omitempty"`
+	// MinVersion is the minimum TLS version that will be negotiated, written as
+	// "1.0", "1.1", "1.2", or "1.3". Defaults to "1.2".
+	MinVersion string `json:"min_version,omitempty"`
+	// CipherSuites restricts the cipher suites used for TLS 1.2 and below to the
+	// named suites, using the names from the Go crypto/tls package (for example
+	// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"). TLS 1.3 suites aren't configurable.
+	CipherSuites []string `json:"cipher_suites,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseCipherSuites converts cipher suite names into their IDs. Insecure suites
+// are accepted because some devices don't support anything else, but they have
+// to be named explicitly.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// mergeTLSConfig returns a copy of base with every field that is set in
+// override replaced by the value from override.
+func mergeTLSConfig(base, override *TLSConfig) *TLSConfig {
+	if override == nil {
+		return base
+	}
+	if base == nil {
+		return override
+	}
+
+	merged := *base
+	if override.CAFile != "" {
+		merged.CAFile = override.CAFile
+	}
+	if override.CADir != "" {
+		merged.CADir = override.CADir
+	}
+	// The certificate and key only make sense as a pair, so they're always
+	// overridden together.
+	if override.CertFile != "" || override.KeyFile != "" {
+		merged.CertFile = override.CertFile
+		merged.KeyFile = override.KeyFile
+	}
+	if override.MinVersion != "" {
+		merged.MinVersion = override.MinVersion
+	}
+	if override.CipherSuites != nil {
+		merged.CipherSuites = override.CipherSuites
+	}
+	return &merged
+}
+
+// buildTLSConfig converts the configuration into a *tls.Config.
+// A nil configuration results in a nil *tls.Config, which means Go's defaults.
+func buildTLSConfig(config *TLSConfig) (*tls.Config, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.MinVersion != "" {
+		version, ok := tlsVersions[config.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", config.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if len(config.CipherSuites) > 0 {
+		suites, err := parseCipherSuites(config.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = suites
+	}
+
+	if config.CAFile != "" || config.CADir != "" {
+		pool, err := loadCertPool(config.CAFile, config.CADir)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, fmt.Errorf("cert_file and key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// loadCertPool returns the system certificate pool with the certificates from
+// caFile and every file in caDir appended to it.
+func loadCertPool(caFile, caDir string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		// The system pool isn't available on every platform, but that shouldn't
+		// stop the configured CAs from being used.
+		pool = x509.NewCertPool()
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+		}
+	}
+
+	if caDir != "" {
+		entries, err := os.ReadDir(caDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA directory: %w", err)
+		}
+
+		found := false
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			// Directories like /etc/ssl/certs contain plenty of files that aren't
+			// certificates, so unreadable or empty files are skipped.
+			pem, err := os.ReadFile(filepath.Join(caDir, entry.Name()))
+			if err != nil {
+				continue
+			}
+			if pool.AppendCertsFromPEM(pem) {
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("no certificates found in CA directory %s", caDir)
+		}
+	}
+
+	return pool, nil
+}
+
+// newHTTPClient creates the HTTP client used for all outbound requests, with
+// the shared transport for the settings.
+func newHTTPClient(config *TLSConfig, httpConfig *HTTPConfig) (*http.Client, error) {
+	transport, err := sharedTransport(config, httpConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Timeout:       10 * time.Second,
+		Transport:     transport,
+		CheckRedirect: refuseCrossHostRedirects,
+	}, nil
+}
+
+// withTransport returns a copy of the client with a copy of its transport that
+// modify has changed. If the transport is audited, the audit log is kept. The
+// copy is shared by every caller that derives one from the same transport with
+// the same key, which must describe the change, so it keeps its connections.
+// The version describes any files that the change reads, and a new version
+// replaces the copy.
+func withTransport(client *http.Client, key string, version string, modify func(*http.Transport)) *http.Client {
+	var wrap func(http.RoundTripper) http.RoundTripper
+	wrap = func(roundTripper http.RoundTripper) http.RoundTripper {
+		switch t := roundTripper.(type) {
+		case nil:
+			return wrap(http.DefaultTransport)
+		case *auditTransport:
+			return &auditTransport{next: wrap(t.next), log: t.log}
+		case *http.Transport:
+			modified, _ := cachedTransport(transportKey{base: t, key: key}, version, func() (*http.Transport, error) {
+				modified := t.Clone()
+				if dialer := transportDialer(t); dialer != nil {
+					transportDialers.Store(modified, dialer)
+				}
+				modify(modified)
+				return modified, nil
+			})
+			return modified
+		default:
+			return roundTripper
+		}
+	}
+
+	modified := *client
+	modified.Transport = wrap(client.Transport)
+	return &modified
+}
+
+// refuseCrossHostRedirects only follows redirects that stay on the host of the
+// original request. Without this, an open redirect on an IP source or webhook
+// would let a response come from anywhere.
+func refuseCrossHostRedirects(req *http.Request, via []*http.Request) error {
+	// This is the same limit that the default policy uses.
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	if req.URL.Host != via[0].URL.Host {
+		return fmt.Errorf("refusing to follow redirect from %s to %s", via[0].URL.Host, req.URL.Host)
+	}
+	return nil
+}
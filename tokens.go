@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CloudflareTokenVerifyResponse is the response from the token verification endpoint
+type CloudflareTokenVerifyResponse struct {
+	Success bool              `json:"success"`
+	Errors  []CloudflareError `json:"errors,omitempty"`
+	Result  struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	} `json:"result"`
+}
+
+// CloudflareTokenResponse is the response from the token details endpoint
+type CloudflareTokenResponse struct {
+	Success bool              `json:"success"`
+	Errors  []CloudflareError `json:"errors,omitempty"`
+	Result  struct {
+		Policies []CloudflareTokenPolicy `json:"policies"`
+	} `json:"result"`
+}
+
+// CloudflareTokenPolicy is a single policy granted to an API token
+type CloudflareTokenPolicy struct {
+	Effect string `json:"effect"`
+	// Resources maps resource identifiers, such as "com.cloudflare.api.account.zone.<id>",
+	// to either "*" or a nested map of resources.
+	Resources        map[string]json.RawMessage `json:"resources"`
+	PermissionGroups []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"permission_groups"`
+}
+
+// allowedTokenPermissions are the permission groups that a token only used for
+// updating DNS records needs. Anything else is more than this client requires.
+var allowedTokenPermissions = map[string]bool{
+	"DNS Write": true,
+	"DNS Read":  true,
+}
+
+func shouldVerifyTokens() bool {
+	value := os.Getenv("DDNS_VERIFY_TOKENS")
+	return value == "1" || value == "true"
+}
+
+// cloudflareGet makes a GET request to the Cloudflare API and parses the
+// response into response, returning the status. Like updates, it's retried,
+// waits for the rate limit, and goes through the breaker in the context, so
+// that looking things up during an outage doesn't take any longer than
+// updating would.
+func cloudflareGet(ctx context.Context, client *http.Client, purpose string, token string, url string, response any) (int, error) {
+	var status int
+	err := withRetries(ctx, cloudflareAttempts, func() error {
+		return breakerFrom(ctx).call(func() error {
+			req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+			if err != nil {
+				return fmt.Errorf("failed to create request: %w", err)
+			}
+			req = withPurpose(req, purpose)
+			req.Header.Set("Authorization", "Bearer "+token)
+
+			resp, err := client.Do(req)
+			if err != nil {
+				return requestError(fmt.Errorf("request failed: %w", err))
+			}
+			defer resp.Body.Close()
+			status = resp.StatusCode
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return &transientError{err: fmt.Errorf("failed to read response body: %w", err)}
+			}
+			if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+				return statusError(resp, fmt.Errorf("API error: %d %s", resp.StatusCode, string(body)))
+			}
+
+			if err := json.Unmarshal(body, response); err != nil {
+				return fmt.Errorf("failed to parse response: %w", err)
+			}
+			return nil
+		})
+	})
+	return status, err
+}
+
+// verifyToken checks that the token is valid and active, returning its ID.
+func verifyToken(ctx context.Context, client *http.Client, token string) (string, error) {
+	var verifyResp CloudflareTokenVerifyResponse
+	_, err := cloudflareGet(ctx, client, "token_verification", token, "https://api.cloudflare.com/client/v4/user/tokens/verify", &verifyResp)
+	if err != nil {
+		return "", err
+	}
+
+	if !verifyResp.Success {
+		if len(verifyResp.Errors) > 0 {
+			return "", fmt.Errorf("API error: %s (code: %d)", verifyResp.Errors[0].Message, verifyResp.Errors[0].Code)
+		}
+		return "", fmt.Errorf("token verification failed")
+	}
+
+	if verifyResp.Result.Status != "active" {
+		return verifyResp.Result.ID, fmt.Errorf("token status is %q", verifyResp.Result.Status)
+	}
+
+	return verifyResp.Result.ID, nil
+}
+
+// tokenPolicyWarnings returns a description of every way the policies grant
+// more than DNS access to the given zones. Zones is a set of zone IDs, which
+// has "" in it if a record's zone is discovered, and could be any of them.
+func tokenPolicyWarnings(policies []CloudflareTokenPolicy, zones map[string]bool) []string {
+	var warnings []string
+
+	for _, policy := range policies {
+		if policy.Effect != "allow" {
+			continue
+		}
+
+		for _, group := range policy.PermissionGroups {
+			if !allowedTokenPermissions[group.Name] {
+				warnings = append(warnings, fmt.Sprintf("has the %q permission", group.Name))
+			}
+		}
+
+		for resource := range policy.Resources {
+			zoneID, isZone := strings.CutPrefix(resource, "com.cloudflare.api.account.zone.")
+			switch {
+			case isZone && zoneID == "*":
+				warnings = append(warnings, "applies to all zones")
+			case isZone && !zones[zoneID] && !zones[""]:
+				warnings = append(warnings, fmt.Sprintf("applies to zone %s, which isn't configured", zoneID))
+			case !isZone:
+				warnings = append(warnings, fmt.Sprintf("applies to %s", resource))
+			}
+		}
+	}
+
+	sort.Strings(warnings)
+	return warnings
+}
+
+// checkToken verifies the token and warns if it's allowed to do more than it needs to.
+func checkToken(ctx context.Context, logger *slog.Logger, client *http.Client, token string, zones map[string]bool) {
+	tokenID, err := verifyToken(ctx, client, token)
+	if err != nil {
+		logger.Error("API token verification failed", "error", err)
+		return
+	}
+	logger = logger.With("token_id", tokenID)
+	logger.Info("API token verified")
+
+	// Reading a token's details requires the "API Tokens Read" permission, which
+	// a least-privilege token won't have. Being able to read it is already a
+	// sign that the token has more permissions than it needs.
+	var tokenResp CloudflareTokenResponse
+	status, err := cloudflareGet(ctx, client, "token_verification", token, "https://api.cloudflare.com/client/v4/user/tokens/"+tokenID, &tokenResp)
+	if err != nil && status != http.StatusForbidden {
+		logger.Warn("Failed to read API token policies", "error", err)
+		return
+	}
+	if status == http.StatusForbidden || !tokenResp.Success {
+		logger.Info("API token policies can't be inspected, which is expected for a least-privilege token")
+		return
+	}
+
+	warnings := tokenPolicyWarnings(tokenResp.Result.Policies, zones)
+	if len(warnings) > 0 {
+		logger.Warn("API token has more permissions than needed, consider a token limited to DNS edit on the configured zones",
+			"issues", warnings)
+	} else {
+		logger.Info("API token permissions are limited to DNS on the configured zones")
+	}
+}
+
+// verifyTokens checks every distinct API token in the configuration concurrently.
+func verifyTokens(ctx context.Context, logger *slog.Logger, client *http.Client, configuration *DNSConfiguration) {
+	logger = logger.With("component", "token_verification")
+
+	// Each token is only checked once, no matter how many records use it.
+	tokenZones := make(map[string]map[string]bool)
+	tokenRecords := make(map[string][]string)
+	for _, records := range [][]DNSRecord{configuration.A, configuration.AAAA} {
+		for _, record := range records {
+			if !record.usesCloudflare() {
+				continue
+			}
+			if tokenZones[record.APIToken] == nil {
+				tokenZones[record.APIToken] = make(map[string]bool)
+			}
+			tokenZones[record.APIToken][record.ZoneID] = true
+			tokenRecords[record.APIToken] = append(tokenRecords[record.APIToken], record.Name)
+
+			// The PTR record is in a different zone, and may use a different token.
+			if record.PTR != nil {
+				token := record.PTR.APIToken
+				if token == "" {
+					token = record.APIToken
+				}
+				if tokenZones[token] == nil {
+					tokenZones[token] = make(map[string]bool)
+				}
+				tokenZones[token][record.PTR.ZoneID] = true
+				if token != record.APIToken {
+					tokenRecords[token] = append(tokenRecords[token], record.Name)
+				}
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for token, zones := range tokenZones {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkToken(ctx, logger.With("record_names", tokenRecords[token]), client, token, zones)
+		}()
+	}
+	wg.Wait()
+}
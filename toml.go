@@ -0,0 +1,543 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This is a parser for TOML 1.0. Dates and times are kept as strings, because
+// nothing in the configuration uses them, and the result is made of the same
+// kind of values as encoding/json produces.
+
+type tomlParser struct {
+	text string
+	pos  int
+	// arrayTables records which arrays were created by [[table]] headers, as
+	// opposed to being inline arrays, which can't be extended.
+	arrayTables map[*[]any]bool
+}
+
+func parseTOML(data []byte) (any, error) {
+	p := &tomlParser{
+		text:        strings.ReplaceAll(string(data), "\r\n", "\n"),
+		arrayTables: map[*[]any]bool{},
+	}
+
+	root := map[string]any{}
+	current := root
+	for {
+		p.skipBlankLines()
+		if p.pos >= len(p.text) {
+			return tomlToJSONValue(root), nil
+		}
+
+		var err error
+		if p.text[p.pos] == '[' {
+			current, err = p.parseTableHeader(root)
+		} else {
+			err = p.parseKeyValue(current)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expectLineEnd(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	line := strings.Count(p.text[:min(p.pos, len(p.text))], "\n") + 1
+	return fmt.Errorf("line %d: "+format, append([]any{line}, args...)...)
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.text) && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *tomlParser) skipComment() {
+	if p.pos < len(p.text) && p.text[p.pos] == '#' {
+		for p.pos < len(p.text) && p.text[p.pos] != '\n' {
+			p.pos++
+		}
+	}
+}
+
+// skipBlankLines skips whitespace, comments, and newlines.
+func (p *tomlParser) skipBlankLines() {
+	for {
+		p.skipSpace()
+		p.skipComment()
+		if p.pos >= len(p.text) || p.text[p.pos] != '\n' {
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *tomlParser) expectLineEnd() error {
+	p.skipSpace()
+	p.skipComment()
+	if p.pos < len(p.text) && p.text[p.pos] != '\n' {
+		return p.errorf("expected the end of the line")
+	}
+	return nil
+}
+
+// parseTableHeader parses a [table] or [[array.of.tables]] header, returning
+// the table that following keys belong to.
+func (p *tomlParser) parseTableHeader(root map[string]any) (map[string]any, error) {
+	isArray := strings.HasPrefix(p.text[p.pos:], "[[")
+	if isArray {
+		p.pos += 2
+	} else {
+		p.pos++
+	}
+
+	p.skipSpace()
+	keys, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+
+	closing := "]"
+	if isArray {
+		closing = "]]"
+	}
+	if !strings.HasPrefix(p.text[p.pos:], closing) {
+		return nil, p.errorf("expected %s after table name", closing)
+	}
+	p.pos += len(closing)
+
+	parent, err := p.navigate(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+
+	if isArray {
+		existing, ok := parent[last]
+		if !ok {
+			array := []any{}
+			p.arrayTables[&array] = true
+			parent[last] = &array
+			existing = &array
+		}
+		array, ok := existing.(*[]any)
+		if !ok || !p.arrayTables[array] {
+			return nil, p.errorf("%q is already defined and isn't an array of tables", last)
+		}
+		table := map[string]any{}
+		*array = append(*array, table)
+		return table, nil
+	}
+
+	switch existing := parent[last].(type) {
+	case nil:
+		table := map[string]any{}
+		parent[last] = table
+		return table, nil
+	case map[string]any:
+		return existing, nil
+	default:
+		return nil, p.errorf("%q is already defined and isn't a table", last)
+	}
+}
+
+// navigate returns the table at the path of keys from the given table,
+// creating any tables that don't exist yet. For arrays of tables, the most
+// recently added table is used.
+func (p *tomlParser) navigate(table map[string]any, keys []string) (map[string]any, error) {
+	for _, key := range keys {
+		switch next := table[key].(type) {
+		case nil:
+			created := map[string]any{}
+			table[key] = created
+			table = created
+		case map[string]any:
+			table = next
+		case *[]any:
+			if !p.arrayTables[next] || len(*next) == 0 {
+				return nil, p.errorf("%q is already defined and isn't a table", key)
+			}
+			table = (*next)[len(*next)-1].(map[string]any)
+		default:
+			return nil, p.errorf("%q is already defined and isn't a table", key)
+		}
+	}
+	return table, nil
+}
+
+// parseKey parses a key, which can be made of several dotted parts.
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.text) {
+			return nil, p.errorf("expected a key")
+		}
+
+		var key string
+		switch p.text[p.pos] {
+		case '"':
+			value, err := p.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			key = value
+		case '\'':
+			value, err := p.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			key = value
+		default:
+			start := p.pos
+			for p.pos < len(p.text) && isTOMLBareKeyChar(p.text[p.pos]) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("expected a key")
+			}
+			key = p.text[start:p.pos]
+		}
+		keys = append(keys, key)
+
+		p.skipSpace()
+		if p.pos >= len(p.text) || p.text[p.pos] != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isTOMLBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseKeyValue(table map[string]any) error {
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+
+	p.skipSpace()
+	if p.pos >= len(p.text) || p.text[p.pos] != '=' {
+		return p.errorf("expected = after key")
+	}
+	p.pos++
+	p.skipSpace()
+
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+
+	parent, err := p.navigate(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, exists := parent[last]; exists {
+		return p.errorf("duplicate key %q", last)
+	}
+	parent[last] = value
+	return nil
+}
+
+func (p *tomlParser) parseValue() (any, error) {
+	if p.pos >= len(p.text) {
+		return nil, p.errorf("expected a value")
+	}
+
+	rest := p.text[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, `"""`):
+		return p.parseMultilineBasicString()
+	case strings.HasPrefix(rest, "'''"):
+		return p.parseMultilineLiteralString()
+	case rest[0] == '"':
+		return p.parseBasicString()
+	case rest[0] == '\'':
+		return p.parseLiteralString()
+	case rest[0] == '[':
+		return p.parseArray()
+	case rest[0] == '{':
+		return p.parseInlineTable()
+	}
+
+	start := p.pos
+	for p.pos < len(p.text) && !strings.ContainsRune(",]}#\n", rune(p.text[p.pos])) {
+		// Dates can contain a single space between the date and the time.
+		if p.text[p.pos] == ' ' && !(p.pos-start == 10 && p.pos+1 < len(p.text) && isDigit(p.text[p.pos+1])) {
+			break
+		}
+		p.pos++
+	}
+	token := strings.TrimRight(p.text[start:p.pos], " \t")
+	value, err := resolveTOMLScalar(token)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("%s", err)
+	}
+	return value, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// resolveTOMLScalar converts a boolean, number, or date to its value.
+func resolveTOMLScalar(token string) (any, error) {
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	case "":
+		return nil, fmt.Errorf("expected a value")
+	}
+
+	// Dates and times are kept as the original text.
+	if len(token) >= 8 && (token[4] == '-' || token[2] == ':') && isDigit(token[0]) {
+		return token, nil
+	}
+
+	digits := strings.ReplaceAll(token, "_", "")
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0o") || strings.HasPrefix(digits, "0b") {
+		if n, err := strconv.ParseInt(digits, 0, 64); err == nil {
+			return n, nil
+		}
+	} else if n, err := strconv.ParseInt(digits, 10, 64); err == nil {
+		return n, nil
+	} else if f, err := strconv.ParseFloat(digits, 64); err == nil && !strings.ContainsAny(digits, "xXpPnN") {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", token)
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			return b.String(), nil
+		case '\n':
+			return "", p.errorf("unterminated string")
+		case '\\':
+			if err := p.parseEscape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *tomlParser) parseMultilineBasicString() (string, error) {
+	p.pos += 3
+	// A newline immediately after the opening quotes is trimmed.
+	if p.pos < len(p.text) && p.text[p.pos] == '\n' {
+		p.pos++
+	}
+
+	var b strings.Builder
+	for p.pos < len(p.text) {
+		if strings.HasPrefix(p.text[p.pos:], `"""`) {
+			// Up to two quotes can come right before the closing quotes.
+			for strings.HasPrefix(p.text[p.pos+1:], `"""`) {
+				b.WriteByte('"')
+				p.pos++
+			}
+			p.pos += 3
+			return b.String(), nil
+		}
+
+		c := p.text[p.pos]
+		if c != '\\' {
+			b.WriteByte(c)
+			p.pos++
+			continue
+		}
+
+		// A backslash at the end of a line removes the newline and any
+		// whitespace up to the next non-whitespace character.
+		end := p.pos + 1
+		for end < len(p.text) && (p.text[end] == ' ' || p.text[end] == '\t') {
+			end++
+		}
+		if end < len(p.text) && p.text[end] == '\n' {
+			for end < len(p.text) && strings.ContainsRune(" \t\n", rune(p.text[end])) {
+				end++
+			}
+			p.pos = end
+			continue
+		}
+		if err := p.parseEscape(&b); err != nil {
+			return "", err
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *tomlParser) parseEscape(b *strings.Builder) error {
+	if p.pos+1 >= len(p.text) {
+		return p.errorf("unterminated escape sequence")
+	}
+	p.pos++
+	c := p.text[p.pos]
+	p.pos++
+
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case 'e':
+		b.WriteByte(0x1b)
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if p.pos+size > len(p.text) {
+			return p.errorf("invalid escape sequence")
+		}
+		code, err := strconv.ParseUint(p.text[p.pos:p.pos+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return p.errorf("invalid escape sequence")
+		}
+		b.WriteRune(rune(code))
+		p.pos += size
+	default:
+		return p.errorf("invalid escape sequence \\%c", c)
+	}
+	return nil
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.pos++
+	end := strings.IndexAny(p.text[p.pos:], "'\n")
+	if end < 0 || p.text[p.pos+end] != '\'' {
+		return "", p.errorf("unterminated string")
+	}
+	value := p.text[p.pos : p.pos+end]
+	p.pos += end + 1
+	return value, nil
+}
+
+func (p *tomlParser) parseMultilineLiteralString() (string, error) {
+	p.pos += 3
+	if p.pos < len(p.text) && p.text[p.pos] == '\n' {
+		p.pos++
+	}
+
+	end := strings.Index(p.text[p.pos:], "'''")
+	if end < 0 {
+		return "", p.errorf("unterminated string")
+	}
+	// Up to two quotes can come right before the closing quotes.
+	for strings.HasPrefix(p.text[p.pos+end+1:], "'''") {
+		end++
+	}
+	value := p.text[p.pos : p.pos+end]
+	p.pos += end + 3
+	return value, nil
+}
+
+func (p *tomlParser) parseArray() (any, error) {
+	p.pos++
+	array := []any{}
+	for {
+		p.skipBlankLines()
+		if p.pos < len(p.text) && p.text[p.pos] == ']' {
+			p.pos++
+			return array, nil
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, value)
+
+		p.skipBlankLines()
+		if p.pos < len(p.text) && p.text[p.pos] == ',' {
+			p.pos++
+		} else if p.pos >= len(p.text) || p.text[p.pos] != ']' {
+			return nil, p.errorf("expected , or ] in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (any, error) {
+	p.pos++
+	table := map[string]any{}
+	for first := true; ; first = false {
+		p.skipSpace()
+		if first && p.pos < len(p.text) && p.text[p.pos] == '}' {
+			p.pos++
+			return table, nil
+		}
+
+		if err := p.parseKeyValue(table); err != nil {
+			return nil, err
+		}
+
+		p.skipSpace()
+		if p.pos < len(p.text) && p.text[p.pos] == '}' {
+			p.pos++
+			return table, nil
+		}
+		if p.pos >= len(p.text) || p.text[p.pos] != ',' {
+			return nil, p.errorf("expected , or } in inline table")
+		}
+		p.pos++
+	}
+}
+
+// tomlToJSONValue replaces the arrays of tables, which are held by pointer
+// while parsing so they can be extended, with plain slices.
+func tomlToJSONValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			v[key] = tomlToJSONValue(child)
+		}
+		return v
+	case *[]any:
+		return tomlToJSONValue(*v)
+	case []any:
+		for i, child := range v {
+			v[i] = tomlToJSONValue(child)
+		}
+		return v
+	}
+	return value
+}
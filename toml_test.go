@@ -0,0 +1,214 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    any
+		wantErr string
+	}{
+		{
+			name: "keys and values",
+			input: `
+# A comment.
+zone = "example.com" # a trailing comment
+ttl = 1_200
+proxied = false
+ratio = 0.5
+mask = 0xff
+updated = 2024-05-01T12:00:00Z
+`,
+			want: map[string]any{
+				"zone":    "example.com",
+				"ttl":     int64(1200),
+				"proxied": false,
+				"ratio":   0.5,
+				"mask":    int64(255),
+				"updated": "2024-05-01T12:00:00Z",
+			},
+		},
+		{
+			name: "strings",
+			input: `
+basic = "tab\there \u00e9"
+literal = 'C:\path'
+multiline = """
+one
+two"""
+multiline_literal = '''
+raw \n'''
+`,
+			want: map[string]any{
+				"basic":             "tab\there é",
+				"literal":           `C:\path`,
+				"multiline":         "one\ntwo",
+				"multiline_literal": `raw \n`,
+			},
+		},
+		{
+			name: "tables",
+			input: `
+[ipv4]
+sources = ["https://a.example", "https://b.example"]
+
+[health]
+listen = ":8080"
+`,
+			want: map[string]any{
+				"ipv4":   map[string]any{"sources": []any{"https://a.example", "https://b.example"}},
+				"health": map[string]any{"listen": ":8080"},
+			},
+		},
+		{
+			name: "dotted keys and nested tables",
+			input: `
+a.b = 1
+
+[c.d]
+e = 2
+
+[c]
+f = 3
+
+["quoted key".g]
+h = 4
+`,
+			want: map[string]any{
+				"a":          map[string]any{"b": int64(1)},
+				"c":          map[string]any{"d": map[string]any{"e": int64(2)}, "f": int64(3)},
+				"quoted key": map[string]any{"g": map[string]any{"h": int64(4)}},
+			},
+		},
+		{
+			name: "inline tables and arrays",
+			input: `
+record = { name = "home", tags = ["a", "b"], nested = { ttl = 60 } }
+matrix = [[1, 2], [3]]
+multiline = [
+  1, # one
+  2,
+]
+`,
+			want: map[string]any{
+				"record": map[string]any{
+					"name":   "home",
+					"tags":   []any{"a", "b"},
+					"nested": map[string]any{"ttl": int64(60)},
+				},
+				"matrix":    []any{[]any{int64(1), int64(2)}, []any{int64(3)}},
+				"multiline": []any{int64(1), int64(2)},
+			},
+		},
+		{
+			name: "array of tables",
+			input: `
+[[records]]
+name = "home"
+
+[[records]]
+name = "office"
+proxied = true
+`,
+			want: map[string]any{
+				"records": []any{
+					map[string]any{"name": "home"},
+					map[string]any{"name": "office", "proxied": true},
+				},
+			},
+		},
+		{
+			name: "tables inside an array of tables",
+			input: `
+[[records]]
+name = "home"
+
+[records.webhook]
+url = "https://hooks.example/home"
+
+[[records.sources]]
+url = "https://a.example"
+
+[[records.sources]]
+url = "https://b.example"
+
+[[records]]
+name = "office"
+`,
+			want: map[string]any{
+				"records": []any{
+					map[string]any{
+						"name":    "home",
+						"webhook": map[string]any{"url": "https://hooks.example/home"},
+						"sources": []any{
+							map[string]any{"url": "https://a.example"},
+							map[string]any{"url": "https://b.example"},
+						},
+					},
+					map[string]any{"name": "office"},
+				},
+			},
+		},
+		{
+			name:    "duplicate key",
+			input:   "a = 1\na = 2\n",
+			wantErr: `line 2: duplicate key "a"`,
+		},
+		{
+			name:    "array of tables over a table",
+			input:   "[a]\n[[a]]\n",
+			wantErr: "isn't an array of tables",
+		},
+		{
+			name:    "array of tables over an inline array",
+			input:   "a = [1]\n[[a]]\n",
+			wantErr: "isn't an array of tables",
+		},
+		{
+			name:    "table over a value",
+			input:   "a = 1\n[a]\n",
+			wantErr: "isn't a table",
+		},
+		{
+			name:    "dotted key through a value",
+			input:   "a = 1\na.b = 2\n",
+			wantErr: "isn't a table",
+		},
+		{
+			name:    "unclosed table header",
+			input:   "[a\n",
+			wantErr: "expected ] after table name",
+		},
+		{
+			name:    "missing equals",
+			input:   "a 1\n",
+			wantErr: "expected = after key",
+		},
+		{
+			name:    "two values on a line",
+			input:   "a = 1 b = 2\n",
+			wantErr: "expected the end of the line",
+		},
+		{
+			name:    "invalid value",
+			input:   "a = yes\n",
+			wantErr: `invalid value "yes"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseTOML([]byte(test.input))
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// HTTPConfig tunes the connections of the transport that's shared by every
+// outbound request, to IP sources, Cloudflare, and webhooks alike.
+type HTTPConfig struct {
+	// MaxIdleConnsPerHost is how many idle connections are kept open to each
+	// host, or defaultMaxIdleConnsPerHost if it's 0.
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host,omitempty"`
+	// IdleTimeout is how many seconds an idle connection is kept open for, or
+	// 90 if it's 0. Making it longer than the interval between syncs lets a
+	// daemon reuse its connections from one sync to the next.
+	IdleTimeout int `json:"idle_timeout,omitempty"`
+	// KeepAlive is how many seconds apart TCP keep-alive probes are sent on
+	// open connections, or 30 if it's 0. -1 turns them off.
+	KeepAlive int `json:"keep_alive,omitempty"`
+	// TLSHandshakeTimeout is how many seconds a TLS handshake can take, or 10
+	// if it's 0.
+	TLSHandshakeTimeout int `json:"tls_handshake_timeout,omitempty"`
+	// HTTP2 can be set to false to only use HTTP/1.1, for servers or proxies
+	// that handle HTTP/2 badly.
+	HTTP2 *bool `json:"http2,omitempty"`
+}
+
+// defaultMaxIdleConnsPerHost is enough idle connections to each host for every
+// record that's updated at the same time to reuse one.
+const defaultMaxIdleConnsPerHost = defaultConcurrency
+
+// seconds returns the setting as a duration, or the default if it's 0.
+func seconds(setting int, fallback time.Duration) time.Duration {
+	if setting == 0 {
+		return fallback
+	}
+	return time.Duration(setting) * time.Second
+}
+
+// dialer returns a dialer with the connection settings. The config can be nil,
+// for the defaults.
+func (c *HTTPConfig) dialer() *net.Dialer {
+	if c == nil {
+		c = &HTTPConfig{}
+	}
+	return &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: seconds(c.KeepAlive, 30*time.Second),
+	}
+}
+
+// transportDialers are the dialers that transports connect with, so a copy that
+// binds to an interface or address keeps the timeout and keep-alive settings.
+var transportDialers sync.Map
+
+// setTransportDialer makes the transport connect with the dialer.
+func setTransportDialer(transport *http.Transport, dialer *net.Dialer) {
+	transport.DialContext = dialer.DialContext
+	transportDialers.Store(transport, dialer)
+}
+
+// transportDialer returns the dialer that the transport connects with, or nil
+// if it isn't known.
+func transportDialer(transport *http.Transport) *net.Dialer {
+	if dialer, ok := transportDialers.Load(transport); ok {
+		return dialer.(*net.Dialer)
+	}
+	return nil
+}
+
+// transportKey identifies a shared transport: the one it was derived from,
+// which is nil for the root transports, and what was changed.
+type transportKey struct {
+	base http.RoundTripper
+	key  string
+}
+
+// transports are created once and shared for as long as the process runs, so
+// connections, and the TLS sessions on them, are reused between requests to
+// the same host, and between the runs of a daemon.
+var transports = struct {
+	sync.Mutex
+	cache map[transportKey]cachedTransportEntry
+}{cache: map[transportKey]cachedTransportEntry{}}
+
+// cachedTransportEntry is a shared transport, and the version of the files
+// that it was created from.
+type cachedTransportEntry struct {
+	version   string
+	transport *http.Transport
+}
+
+// cachedTransport returns the transport with the key, calling create to make it
+// the first time. When the version has changed, like after a certificate was
+// renewed, the old transport is replaced rather than kept alongside the new
+// one, so a long-running daemon doesn't collect a transport for every renewal.
+func cachedTransport(key transportKey, version string, create func() (*http.Transport, error)) (*http.Transport, error) {
+	transports.Lock()
+	defer transports.Unlock()
+	entry, ok := transports.cache[key]
+	if ok && entry.version == version {
+		return entry.transport, nil
+	}
+	transport, err := create()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		retireTransport(entry.transport)
+	}
+	transports.cache[key] = cachedTransportEntry{version: version, transport: transport}
+	return transport, nil
+}
+
+// retireTransport removes the transports derived from one that's been
+// replaced, and closes their idle connections. Requests that are still using
+// them finish normally. transports must be locked.
+func retireTransport(transport *http.Transport) {
+	for key, entry := range transports.cache {
+		if key.base == transport {
+			delete(transports.cache, key)
+			retireTransport(entry.transport)
+		}
+	}
+	transport.CloseIdleConnections()
+	transportDialers.Delete(transport)
+}
+
+// tlsKey identifies the TLS settings, not including the files they read.
+func tlsKey(config *TLSConfig) string {
+	settings, _ := json.Marshal(config)
+	return string(settings)
+}
+
+// tlsVersion identifies the state of the files that the TLS settings read, so
+// that a transport is only shared while the files are unchanged, and a renewed
+// certificate is picked up by a new one.
+func tlsVersion(config *TLSConfig) string {
+	var version string
+	if config != nil {
+		for _, path := range []string{config.CAFile, config.CADir, config.CertFile, config.KeyFile} {
+			if info, err := os.Stat(path); path != "" && err == nil {
+				version += fmt.Sprintf(" %s:%d:%d", path, info.Size(), info.ModTime().UnixNano())
+			}
+		}
+	}
+	return version
+}
+
+// sharedTransport returns the transport for the TLS and HTTP settings, which
+// is created the first time they're used.
+func sharedTransport(tlsConfig *TLSConfig, httpConfig *HTTPConfig) (*http.Transport, error) {
+	settings, _ := json.Marshal(httpConfig)
+	key := tlsKey(tlsConfig) + " " + string(settings)
+	return cachedTransport(transportKey{key: key}, tlsVersion(tlsConfig), func() (*http.Transport, error) {
+		built, err := buildTLSConfig(tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+		if httpConfig == nil {
+			httpConfig = &HTTPConfig{}
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		setTransportDialer(transport, httpConfig.dialer())
+		transport.MaxIdleConnsPerHost = httpConfig.MaxIdleConnsPerHost
+		if transport.MaxIdleConnsPerHost == 0 {
+			transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+		}
+		transport.IdleConnTimeout = seconds(httpConfig.IdleTimeout, 90*time.Second)
+		transport.TLSHandshakeTimeout = seconds(httpConfig.TLSHandshakeTimeout, 10*time.Second)
+		if httpConfig.HTTP2 != nil && !*httpConfig.HTTP2 {
+			transport.Protocols = new(http.Protocols)
+			transport.Protocols.SetHTTP1(true)
+		}
+		if built != nil {
+			transport.TLSClientConfig = built
+		}
+		return transport, nil
+	})
+}
+
+// withTLS returns a copy of the client that uses the TLS settings instead of
+// its own, with the rest of its transport, like the HTTP settings and the
+// interface it's bound to, unchanged.
+func withTLS(client *http.Client, config *TLSConfig) (*http.Client, error) {
+	built, err := buildTLSConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+	return withTransport(client, "tls "+tlsKey(config), tlsVersion(config), func(transport *http.Transport) {
+		transport.TLSClientConfig = built
+	}), nil
+}
+
+// validateHTTPConfig checks that none of the settings are out of range.
+func validateHTTPConfig(config *HTTPConfig) error {
+	if config.MaxIdleConnsPerHost < 0 {
+		return fmt.Errorf("max_idle_conns_per_host can't be negative")
+	}
+	if config.IdleTimeout < 0 {
+		return fmt.Errorf("idle_timeout can't be negative")
+	}
+	if config.KeepAlive < -1 {
+		return fmt.Errorf("keep_alive must be -1 to turn keep-alive probes off, or a number of seconds")
+	}
+	if config.TLSHandshakeTimeout < 0 {
+		return fmt.Errorf("tls_handshake_timeout can't be negative")
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// watchTriggerPath calls trigger whenever the file at path is touched or, if
+// it's a FIFO, written to. The path doesn't need to exist yet, and creating it
+// counts as touching it.
+func watchTriggerPath(ctx context.Context, logger *slog.Logger, path string, trigger func(reason string)) {
+	logger = logger.With("component", "trigger", "path", path)
+
+	info, err := os.Stat(path)
+	if err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+		logger.Info("Watching FIFO for writes")
+		watchFIFO(ctx, logger, path, trigger)
+		return
+	}
+
+	logger.Info("Watching file for changes")
+	watchFileTouches(ctx, path, func() { trigger("trigger_file") })
+}
+
+// watchFIFO reads from the FIFO until every writer has closed it, then triggers
+// a sync. Opening a FIFO blocks until there's a writer, so the loop spends
+// almost all of its time waiting in os.Open.
+func watchFIFO(ctx context.Context, logger *slog.Logger, path string, trigger func(reason string)) {
+	for ctx.Err() == nil {
+		fifo, err := os.Open(path)
+		if err != nil {
+			logger.Error("Failed to open FIFO", "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		_, err = io.Copy(io.Discard, fifo)
+		fifo.Close()
+		if err != nil {
+			logger.Warn("Failed to read from FIFO", "error", err)
+		}
+
+		trigger("trigger_fifo")
+	}
+}
+
+// watchFileTouches polls the modification time of the file, or of every file
+// in it if it's a directory, because there's no portable way to be notified of
+// changes.
+func watchFileTouches(ctx context.Context, path string, touched func()) {
+	lastModified, _ := latestModTime(path)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modified, err := latestModTime(path)
+		if err != nil {
+			continue
+		}
+		if !modified.Equal(lastModified) {
+			lastModified = modified
+			touched()
+		}
+	}
+}
+
+// latestModTime returns the modification time of path. For a directory, it's
+// the latest modification time of the directory and the files directly in it,
+// so adding, removing, and editing files all change it.
+func latestModTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest := info.ModTime()
+	if !info.IsDir() {
+		return latest, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Uplink is one of the internet connections of a machine with more than one,
+// like a dual-WAN router, so that records can be published with the address
+// of a particular connection.
+type Uplink struct {
+	// A and AAAA are where the connection's addresses are fetched from. If a
+	// family has no sources, the default ones are used.
+	A    IPSourceList `json:"a,omitempty"`
+	AAAA IPSourceList `json:"aaaa,omitempty"`
+	// Bind is the interface or address that HTTP requests to the uplink's
+	// sources are made from, so that they go out through the connection.
+	Bind *BindConfig `json:"bind,omitempty"`
+}
+
+// sources returns the uplink's sources for the record type, which are bound
+// to the uplink unless they set their own binding.
+func (u *Uplink) sources(recordType string) IPSourceList {
+	sources := u.A
+	if recordType == "AAAA" {
+		sources = u.AAAA
+	}
+	if len(sources) == 0 {
+		sources = defaultIPSources(recordType)
+	}
+
+	// Every record gets its own copy, because the settings of endpoints are
+	// changed in place when the configuration is loaded.
+	sources = slices.Clone(sources)
+	for i := range sources {
+		if sources[i].Endpoint.Bind == nil {
+			sources[i].Endpoint.Bind = u.Bind
+		}
+	}
+	return sources
+}
+
+// applyUplinks sets the IP sources of every record that uses an uplink to the
+// uplink's sources.
+func (c *DNSConfiguration) applyUplinks() error {
+	for _, group := range []struct {
+		recordType string
+		records    []DNSRecord
+	}{{"A", c.A}, {"AAAA", c.AAAA}} {
+		for i := range group.records {
+			record := &group.records[i]
+			if record.Uplink == "" {
+				continue
+			}
+			uplink, ok := c.Uplinks[record.Uplink]
+			if !ok || uplink == nil {
+				return fmt.Errorf("%s uses the uplink %q, which isn't defined", record.Name, record.Uplink)
+			}
+			if len(record.IPSources) > 0 {
+				return fmt.Errorf("%s can't have both an uplink and its own ip_sources", record.Name)
+			}
+			record.IPSources = uplink.sources(group.recordType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// validateConfiguration checks the configuration for mistakes that would
+// otherwise only be noticed when a record fails to sync, and returns a
+// description of each one.
+func validateConfiguration(configuration *DNSConfiguration) []string {
+	var problems []string
+	report := func(location string, format string, args ...any) {
+		problems = append(problems, location+": "+fmt.Sprintf(format, args...))
+	}
+
+	if configuration.TLS != nil {
+		if _, err := buildTLSConfig(configuration.TLS); err != nil {
+			report("tls", "invalid TLS configuration: %v", err)
+		}
+	}
+	if configuration.HTTP != nil {
+		if err := validateHTTPConfig(configuration.HTTP); err != nil {
+			report("http", "%v", err)
+		}
+	}
+	if configuration.Bind != nil {
+		if err := validateBind(configuration.Bind); err != nil {
+			report("bind", "%v", err)
+		}
+	}
+
+	validateIPSource := func(location string, recordType string, source *IPSource) {
+		switch source.Type {
+		case "", "http":
+			if err := validateEndpoint(&source.Endpoint); err != nil {
+				report(location, "%v", err)
+			}
+		case "snmp":
+			if source.SNMP == nil {
+				report(location, "snmp settings are missing")
+				return
+			}
+			if source.SNMP.Address == "" {
+				report(location, "snmp address is missing")
+			}
+			if _, err := berOID(source.SNMP.OID); err != nil {
+				report(location, "%v", err)
+			}
+			switch source.SNMP.Version {
+			case "", "1", "2c", "3":
+			default:
+				report(location, "unsupported snmp version %q", source.SNMP.Version)
+			}
+		case "dns":
+			if source.DNS != nil {
+				if _, err := source.DNS.whoami(); err != nil {
+					report(location, "%v", err)
+				}
+			}
+		case "stun":
+			// Every setting has a default.
+		case "interface":
+			if source.Interface == nil || source.Interface.Name == "" {
+				report(location, "interface name is missing")
+			}
+		case "gateway":
+			if recordType != "A" {
+				report(location, "gateway sources only support A records")
+			}
+			if source.Gateway != nil {
+				switch source.Gateway.Protocol {
+				case "", "natpmp", "upnp":
+				default:
+					report(location, "unknown gateway protocol %q", source.Gateway.Protocol)
+				}
+			}
+		case "tailscale":
+			// Every setting has a default.
+		case "fritzbox":
+			if source.FritzBox != nil {
+				switch source.FritzBox.IPv6 {
+				case "", "address", "prefix":
+				default:
+					report(location, "fritzbox ipv6 must be \"address\" or \"prefix\"")
+				}
+			}
+		case "metadata":
+			if source.Metadata == nil {
+				report(location, "metadata settings are missing")
+			} else if endpoints, ok := metadataEndpoints[source.Metadata.Provider]; !ok {
+				report(location, "unknown metadata provider %q", source.Metadata.Provider)
+			} else if recordType == "AAAA" && endpoints.aaaa == "" {
+				report(location, "the %s metadata service doesn't support AAAA records", source.Metadata.Provider)
+			}
+		case "file":
+			if source.File == nil || source.File.Path == "" {
+				report(location, "file path is missing")
+			}
+		case "exec":
+			if source.Exec == nil {
+				report(location, "exec settings are missing")
+			} else if problem := checkCommand(source.Exec.Command); problem != "" {
+				report(location, "exec %s", problem)
+			}
+		default:
+			report(location, "unknown type %q", source.Type)
+		}
+	}
+	validateIPSources := func(location string, recordType string, sources IPSourceList) {
+		for i := range sources {
+			if len(sources) == 1 {
+				validateIPSource(location, recordType, &sources[i])
+			} else {
+				validateIPSource(fmt.Sprintf("%s[%d]", location, i), recordType, &sources[i])
+			}
+		}
+	}
+	validateRecords := func(key string, records []DNSRecord) {
+		recordIDs := map[string]string{}
+		for i, record := range records {
+			location := fmt.Sprintf("%s[%d]", key, i)
+			if record.Name != "" {
+				location += " (" + record.Name + ")"
+			}
+
+			if record.Name != "" && !isValidDNSName(record.Name) {
+				report(location, "name isn't a valid fully qualified domain name")
+			}
+
+			if strings.TrimSpace(record.Name) == "" {
+				report(location, "name is missing")
+			}
+			for _, problem := range validateProvider(&record) {
+				report(location, "%s", problem)
+			}
+
+			if record.IP != "" {
+				if err := checkFamily(record.IP, strings.ToUpper(key)); err != nil {
+					report(location, "ip %v", err)
+				}
+			}
+
+			if record.TTL != 0 && record.TTL != 1 && (record.TTL < 30 || record.TTL > 86400) {
+				report(location, "ttl must be 1 (automatic) or between 30 and 86400 seconds")
+			}
+
+			if record.RecordID != "" {
+				if other, ok := recordIDs[record.RecordID]; ok {
+					report(location, "record_id is the same as %s", other)
+				} else {
+					recordIDs[record.RecordID] = location
+				}
+			}
+
+			if record.IPv6Suffix != "" {
+				if key != "aaaa" {
+					report(location, "ipv6_suffix is only supported for AAAA records")
+				} else if record.IP != "" {
+					report(location, "ipv6_suffix isn't used when ip is set")
+				} else if _, err := withIPv6Suffix("::", record.IPv6Suffix, record.PrefixLength); err != nil {
+					report(location, "%v", err)
+				}
+			} else if record.PrefixLength != 0 {
+				report(location, "prefix_length is only used with ipv6_suffix")
+			}
+
+			if record.ConfirmChecks < 0 {
+				report(location, "confirm_checks can't be negative")
+			}
+			if record.ConfirmTime < 0 {
+				report(location, "confirm_time can't be negative")
+			}
+			if record.IP != "" && (record.ConfirmChecks > 1 || record.ConfirmTime > 0) {
+				report(location, "confirm_checks and confirm_time aren't used when ip is set")
+			}
+
+			if record.Uplink != "" {
+				// The uplink's sources are checked with the uplink.
+				if record.IP != "" {
+					report(location, "uplink isn't used when ip is set")
+				}
+			} else if len(record.IPSources) > 0 {
+				if record.IP != "" {
+					report(location, "ip_sources isn't used when ip is set")
+				}
+				validateIPSources(location+" ip_sources", strings.ToUpper(key), record.IPSources)
+			}
+
+			for j, webhook := range record.Webhooks {
+				if err := validateEndpoint(&webhook); err != nil {
+					report(fmt.Sprintf("%s webhooks[%d]", location, j), "%v", err)
+				}
+			}
+
+			if record.PTR != nil {
+				if !record.usesCloudflare() {
+					report(location+" ptr", "ptr records are only supported with the cloudflare provider")
+				}
+				if record.PTR.ZoneID == "" {
+					report(location+" ptr", "zone_id is missing")
+				}
+				if record.PTR.RecordID == "" {
+					report(location+" ptr", "record_id is missing")
+				}
+			}
+		}
+	}
+	validateRecords("a", configuration.A)
+	validateRecords("aaaa", configuration.AAAA)
+
+	if configuration.IPSources.Consensus {
+		if len(configuration.IPSources.A) == 1 {
+			report("ip_sources.a", "consensus needs more than one source")
+		}
+		if len(configuration.IPSources.AAAA) == 1 {
+			report("ip_sources.aaaa", "consensus needs more than one source")
+		}
+	}
+	validateIPSources("ip_sources.a", "A", configuration.IPSources.A)
+	validateIPSources("ip_sources.aaaa", "AAAA", configuration.IPSources.AAAA)
+
+	if configuration.Ping != nil {
+		if err := validateEndpoint(configuration.Ping); err != nil {
+			report("ping", "%v", err)
+		}
+	}
+
+	if configuration.Concurrency < 0 {
+		report("concurrency", "must be at least 1")
+	}
+
+	for _, group := range slices.Sorted(maps.Keys(configuration.Schedules)) {
+		inGroup := func(record DNSRecord) bool { return slices.Contains(record.Groups, group) }
+		if !slices.ContainsFunc(configuration.A, inGroup) && !slices.ContainsFunc(configuration.AAAA, inGroup) {
+			report("schedules."+group, "no record is in the group")
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(configuration.Uplinks)) {
+		uplink := configuration.Uplinks[name]
+		location := "uplinks." + name
+		if uplink == nil {
+			report(location, "uplink settings are missing")
+			continue
+		}
+		if uplink.Bind != nil {
+			if err := validateBind(uplink.Bind); err != nil {
+				report(location+".bind", "%v", err)
+			}
+		}
+		validateIPSources(location+".a", "A", uplink.A)
+		validateIPSources(location+".aaaa", "AAAA", uplink.AAAA)
+	}
+
+	return problems
+}
+
+// isValidDNSName reports whether name is a fully qualified domain name that a
+// record can have. The first label can be a wildcard.
+func isValidDNSName(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	labels := strings.Split(name, ".")
+	if len(name) > 253 || len(labels) < 2 {
+		return false
+	}
+	for i, label := range labels {
+		if label == "*" && i == 0 {
+			continue
+		}
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateEndpoint checks that an endpoint has an absolute HTTP or HTTPS URL and
+// that its TLS settings, if any, can be used.
+func validateEndpoint(endpoint *Endpoint) error {
+	parsed, err := url.Parse(endpoint.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("URL %q must start with http:// or https://", endpoint.URL)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("URL %q has no host", endpoint.URL)
+	}
+	if endpoint.TLS != nil {
+		if _, err := buildTLSConfig(endpoint.TLS); err != nil {
+			return fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+	}
+	for name, value := range endpoint.Headers {
+		if name == "" || strings.ContainsFunc(name, func(c rune) bool { return c <= ' ' || c >= 0x7f || c == ':' }) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("header %s contains a line break", name)
+		}
+	}
+	if endpoint.BasicAuth != nil && endpoint.BasicAuth.Username == "" {
+		return fmt.Errorf("basic_auth username is missing")
+	}
+	if endpoint.Bind != nil {
+		if err := validateBind(endpoint.Bind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCommand handles "clouddns validate [path]", which loads the
+// configuration strictly without syncing anything and prints every problem it
+// finds. It exits with a non-zero status if there are any, so it can be used
+// before deploying a configuration.
+func validateCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Parse(args)
+
+	if path := flags.Arg(0); path != "" {
+		os.Setenv("DDNS_CONFIG_PATH", path)
+	}
+	os.Setenv("DDNS_STRICT", "true")
+
+	configuration, err := loadDNSConfiguration(logger)
+	if err != nil {
+		fmt.Println(err)
+		return fmt.Errorf("configuration is invalid")
+	}
+
+	fmt.Printf("Configuration is valid: %d A records, %d AAAA records\n", len(configuration.A), len(configuration.AAAA))
+	return nil
+}
+
+// validateBind checks that the interface exists and that the address is one of
+// this machine's.
+func validateBind(bind *BindConfig) error {
+	if bind.Interface == "" && bind.Address == "" {
+		return fmt.Errorf("bind needs an interface or an address")
+	}
+	if _, err := bind.dialer(nil); err != nil {
+		return err
+	}
+	if bind.Interface != "" {
+		if _, err := net.InterfaceByName(bind.Interface); err != nil {
+			return fmt.Errorf("bind interface %s: %w", bind.Interface, err)
+		}
+	}
+	if bind.Address != "" {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return fmt.Errorf("failed to list local addresses: %w", err)
+		}
+		address := netip.MustParseAddr(bind.Address)
+		if !slices.ContainsFunc(addrs, func(addr net.Addr) bool {
+			prefix, err := netip.ParsePrefix(addr.String())
+			return err == nil && prefix.Addr() == address
+		}) {
+			return fmt.Errorf("bind address %s isn't assigned to any interface", bind.Address)
+		}
+	}
+	return nil
+}
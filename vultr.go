@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// VultrDNS holds the settings for a record in a domain managed by Vultr.
+type VultrDNS struct {
+	// APIKey is a Vultr API key. Vultr keys can't be limited to DNS.
+	APIKey string `json:"api_key"`
+	// APIKeyFile is the path to a file containing the API key, which is read
+	// when the configuration is loaded if APIKey isn't set.
+	APIKeyFile string `json:"api_key_file,omitempty"`
+	// Domain is the name of the domain, such as example.com.
+	Domain string `json:"domain"`
+	// RecordID is the ID of the record in the domain.
+	RecordID string `json:"record_id"`
+}
+
+// vultrRecordUpdate is the body of a request to update a domain record.
+type vultrRecordUpdate struct {
+	Data string `json:"data"`
+	TTL  int    `json:"ttl,omitempty"`
+}
+
+// update points the record at the address.
+func (v *VultrDNS) update(ctx context.Context, client *http.Client, record *DNSRecord, address string) error {
+	update := vultrRecordUpdate{Data: address}
+	if record.TTL > 1 {
+		update.TTL = record.TTL
+	}
+
+	req, err := newJSONRequest(ctx, "PATCH", "https://api.vultr.com/v2/domains/"+url.PathEscape(v.Domain)+"/records/"+url.PathEscape(v.RecordID), update)
+	if err != nil {
+		return err
+	}
+	req = withPurpose(req, "dns_update")
+	req.Header.Set("Authorization", "Bearer "+v.APIKey)
+	return doJSON(client, req, nil)
+}
+
+// validate returns a description of each problem with the settings.
+func (v *VultrDNS) validate() []string {
+	var problems []string
+	for _, field := range []struct{ name, value string }{
+		{"api_key", v.APIKey},
+		{"domain", v.Domain},
+		{"record_id", v.RecordID},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			problems = append(problems, fmt.Sprintf("vultr %s is missing", field.name))
+		}
+	}
+	return problems
+}
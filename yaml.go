@@ -0,0 +1,644 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This is a parser for the subset of YAML that's useful for configuration
+// files: block and flow mappings and sequences, plain and quoted scalars,
+// literal and folded block scalars, comments, anchors, aliases, and merge keys.
+// It produces the same kind of values as encoding/json, so the result can be
+// re-encoded as JSON and decoded like any other configuration file.
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines   []yamlLine
+	pos     int
+	anchors map[string]any
+}
+
+func parseYAML(data []byte) (any, error) {
+	p := &yamlParser{anchors: map[string]any{}}
+
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", i+1)
+		}
+		// Block scalars keep their content exactly, so comments are only
+		// removed when the line is parsed as something else.
+		p.lines = append(p.lines, yamlLine{
+			number: i + 1,
+			indent: len(raw) - len(trimmed),
+			text:   strings.TrimRight(trimmed, " \t"),
+		})
+	}
+
+	p.skipBlank()
+	if p.pos < len(p.lines) && p.lines[p.pos].indent == 0 && stripYAMLComment(p.lines[p.pos].text) == "---" {
+		p.pos++
+		p.skipBlank()
+	}
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+
+	value, err := p.parseNode(p.lines[p.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipBlank()
+	if p.pos < len(p.lines) && stripYAMLComment(p.lines[p.pos].text) != "..." {
+		return nil, fmt.Errorf("line %d: unexpected content", p.lines[p.pos].number)
+	}
+	return value, nil
+}
+
+// stripYAMLComment removes a comment from the end of a line, ignoring any # in
+// quoted strings or that isn't preceded by a space.
+func stripYAMLComment(text string) string {
+	var quote rune
+	for i, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			if i == 0 || strings.ContainsRune(" [{,:-", rune(text[i-1])) {
+				quote = r
+			}
+		case r == '#' && (i == 0 || text[i-1] == ' '):
+			return strings.TrimRight(text[:i], " ")
+		}
+	}
+	return text
+}
+
+// skipBlank moves past lines that are empty or only contain a comment.
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) && stripYAMLComment(p.lines[p.pos].text) == "" {
+		p.pos++
+	}
+}
+
+// current returns the next non-blank line, with its comment removed. The end
+// of the document, "...", ends every node.
+func (p *yamlParser) current() (yamlLine, bool) {
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		return yamlLine{}, false
+	}
+	line := p.lines[p.pos]
+	line.text = stripYAMLComment(line.text)
+	if line.indent == 0 && line.text == "..." {
+		return yamlLine{}, false
+	}
+	return line, true
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits a mapping entry into its key and value, reporting false
+// if the text isn't a mapping entry.
+func splitYAMLKey(text string) (string, string, bool) {
+	if text == "" || strings.ContainsRune("[{", rune(text[0])) {
+		return "", "", false
+	}
+
+	if text[0] == '"' || text[0] == '\'' {
+		key, rest, err := parseYAMLQuoted(text)
+		if err != nil {
+			return "", "", false
+		}
+		rest = strings.TrimLeft(rest, " ")
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimRight(text[:i], " "), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// parseNode parses the block node starting at the current line, which must be
+// indented by exactly indent.
+func (p *yamlParser) parseNode(indent int) (any, error) {
+	line, ok := p.current()
+	if !ok {
+		return nil, nil
+	}
+
+	if isYAMLSequenceItem(line.text) {
+		return p.parseSequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(line.text); ok {
+		return p.parseMapping(indent)
+	}
+
+	p.pos++
+	return p.parseInlineValue(line, line.text)
+}
+
+func (p *yamlParser) parseSequence(indent int) (any, error) {
+	sequence := []any{}
+	for {
+		line, ok := p.current()
+		if !ok || line.indent != indent || !isYAMLSequenceItem(line.text) {
+			return sequence, nil
+		}
+
+		content := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		item, err := p.parseCompactNode(line, content, indent)
+		if err != nil {
+			return nil, err
+		}
+		sequence = append(sequence, item)
+	}
+}
+
+// parseCompactNode parses a node that starts on the same line as the "- " of a
+// sequence item, such as the first key of a mapping.
+func (p *yamlParser) parseCompactNode(line yamlLine, content string, parentIndent int) (any, error) {
+	anchor, content := cutYAMLAnchor(content)
+
+	var value any
+	var err error
+	switch {
+	case content == "":
+		p.pos++
+		value, err = p.parseChild(parentIndent, false)
+	case isYAMLSequenceItem(content) || isYAMLMappingStart(content):
+		// The rest of the line is treated as a line of its own, indented to
+		// where it starts, so that following lines can continue the node.
+		column := line.indent + len(line.text) - len(content)
+		p.lines[p.pos] = yamlLine{number: line.number, indent: column, text: content}
+		value, err = p.parseNode(column)
+	default:
+		p.pos++
+		value, err = p.parseInlineValue(line, content)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if anchor != "" {
+		p.anchors[anchor] = value
+	}
+	return value, nil
+}
+
+func isYAMLMappingStart(text string) bool {
+	_, _, ok := splitYAMLKey(text)
+	return ok
+}
+
+// parseChild parses the block node nested under a key or sequence item. A
+// sequence can be nested at the same indentation as a mapping key.
+func (p *yamlParser) parseChild(parentIndent int, allowSameIndentSequence bool) (any, error) {
+	next, ok := p.current()
+	if !ok {
+		return nil, nil
+	}
+	if next.indent > parentIndent || allowSameIndentSequence && next.indent == parentIndent && isYAMLSequenceItem(next.text) {
+		return p.parseNode(next.indent)
+	}
+	return nil, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (any, error) {
+	mapping := map[string]any{}
+	var merges []map[string]any
+
+	for {
+		line, ok := p.current()
+		if !ok || line.indent != indent || isYAMLSequenceItem(line.text) {
+			break
+		}
+
+		key, content, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected a mapping key", line.number)
+		}
+		if _, exists := mapping[key]; exists {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.number, key)
+		}
+
+		anchor, content := cutYAMLAnchor(content)
+		p.pos++
+		var value any
+		var err error
+		if content == "" {
+			value, err = p.parseChild(indent, true)
+		} else {
+			value, err = p.parseInlineValue(line, content)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if anchor != "" {
+			p.anchors[anchor] = value
+		}
+
+		if key == "<<" {
+			switch merge := value.(type) {
+			case map[string]any:
+				merges = append(merges, merge)
+			case []any:
+				for _, item := range merge {
+					m, ok := item.(map[string]any)
+					if !ok {
+						return nil, fmt.Errorf("line %d: merge key values must be mappings", line.number)
+					}
+					merges = append(merges, m)
+				}
+			default:
+				return nil, fmt.Errorf("line %d: merge key values must be mappings", line.number)
+			}
+			continue
+		}
+		mapping[key] = value
+	}
+
+	// Keys in the mapping itself take precedence over merged keys, and earlier
+	// merged mappings take precedence over later ones.
+	for _, merge := range merges {
+		for key, value := range merge {
+			if _, exists := mapping[key]; !exists {
+				mapping[key] = value
+			}
+		}
+	}
+	return mapping, nil
+}
+
+// cutYAMLAnchor removes an anchor from the start of a value.
+func cutYAMLAnchor(content string) (string, string) {
+	if !strings.HasPrefix(content, "&") {
+		return "", content
+	}
+	anchor, rest, _ := strings.Cut(content[1:], " ")
+	return anchor, strings.TrimLeft(rest, " ")
+}
+
+// parseInlineValue parses a value that starts on the given line, after any key
+// or "- ". Block scalars and multi-line flow collections continue onto the
+// following lines.
+func (p *yamlParser) parseInlineValue(line yamlLine, content string) (any, error) {
+	switch {
+	case strings.HasPrefix(content, "|") || strings.HasPrefix(content, ">"):
+		return p.parseBlockScalar(line, content)
+	case strings.HasPrefix(content, "*"):
+		name := content[1:]
+		value, ok := p.anchors[name]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown alias %q", line.number, name)
+		}
+		return value, nil
+	case strings.HasPrefix(content, "[") || strings.HasPrefix(content, "{"):
+		// Flow collections can span multiple lines, so lines are added until
+		// the brackets are balanced.
+		for !yamlBracketsBalanced(content) {
+			next, ok := p.current()
+			if !ok {
+				return nil, fmt.Errorf("line %d: unterminated flow collection", line.number)
+			}
+			content += " " + next.text
+			p.pos++
+		}
+		flow := &yamlFlowParser{text: content, line: line.number, anchors: p.anchors}
+		value, err := flow.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		flow.skipSpace()
+		if flow.pos != len(flow.text) {
+			return nil, fmt.Errorf("line %d: unexpected content after flow collection", line.number)
+		}
+		return value, nil
+	case content[0] == '"' || content[0] == '\'':
+		value, rest, err := parseYAMLQuoted(content)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.number, err)
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("line %d: unexpected content after quoted string", line.number)
+		}
+		return value, nil
+	}
+	return resolveYAMLScalar(content), nil
+}
+
+func yamlBracketsBalanced(text string) bool {
+	depth := 0
+	var quote rune
+	for _, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[' || r == '{':
+			depth++
+		case r == ']' || r == '}':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+// parseBlockScalar parses a literal (|) or folded (>) block scalar, whose
+// content is every following line that's indented more than the key.
+func (p *yamlParser) parseBlockScalar(line yamlLine, header string) (any, error) {
+	header = stripYAMLComment(header)
+	folded := header[0] == '>'
+	chomping := strings.TrimLeft(header[1:], "0123456789")
+
+	var content []string
+	contentIndent := -1
+	for p.pos < len(p.lines) {
+		next := p.lines[p.pos]
+		if next.text == "" {
+			content = append(content, "")
+			p.pos++
+			continue
+		}
+		if next.indent <= line.indent {
+			break
+		}
+		if contentIndent < 0 {
+			contentIndent = next.indent
+		}
+		if next.indent < contentIndent {
+			break
+		}
+		content = append(content, strings.Repeat(" ", next.indent-contentIndent)+next.text)
+		p.pos++
+	}
+
+	// Trailing blank lines are part of the scalar only if it's kept ("+").
+	trailing := 0
+	for len(content) > 0 && content[len(content)-1] == "" {
+		content = content[:len(content)-1]
+		trailing++
+	}
+
+	var text string
+	if folded {
+		var b strings.Builder
+		for i, l := range content {
+			// Line breaks between lines are folded into spaces, except where
+			// there are blank lines or more indented lines.
+			switch {
+			case i == 0 || content[i-1] == "" && l != "":
+			case l == "" || strings.HasPrefix(l, " ") || strings.HasPrefix(content[i-1], " "):
+				b.WriteString("\n")
+			default:
+				b.WriteString(" ")
+			}
+			b.WriteString(l)
+		}
+		text = b.String()
+	} else {
+		text = strings.Join(content, "\n")
+	}
+
+	switch chomping {
+	case "-":
+	case "+":
+		text += strings.Repeat("\n", trailing+1)
+	default:
+		if len(content) > 0 {
+			text += "\n"
+		}
+	}
+	return text, nil
+}
+
+// parseYAMLQuoted parses a single or double quoted string at the start of
+// text, returning it along with the rest of the text.
+func parseYAMLQuoted(text string) (string, string, error) {
+	quote := text[0]
+	var b strings.Builder
+
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '\'' && c == '\'':
+			if i+1 < len(text) && text[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), text[i+1:], nil
+		case quote == '"' && c == '"':
+			return b.String(), text[i+1:], nil
+		case quote == '"' && c == '\\':
+			if i+1 >= len(text) {
+				return "", "", fmt.Errorf("unterminated escape sequence")
+			}
+			i++
+			switch text[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '0':
+				b.WriteByte(0)
+			case '"', '\\', '/', ' ':
+				b.WriteByte(text[i])
+			case 'x', 'u', 'U':
+				size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[text[i]]
+				if i+size >= len(text) {
+					return "", "", fmt.Errorf("invalid escape sequence")
+				}
+				code, err := strconv.ParseUint(text[i+1:i+1+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(code)) {
+					return "", "", fmt.Errorf("invalid escape sequence")
+				}
+				b.WriteRune(rune(code))
+				i += size
+			default:
+				return "", "", fmt.Errorf("invalid escape sequence \\%c", text[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated quoted string")
+}
+
+// resolveYAMLScalar converts a plain scalar to the type it represents, using
+// the rules of the YAML 1.2 core schema.
+func resolveYAMLScalar(text string) any {
+	switch text {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	case ".inf", ".Inf", ".INF", "+.inf", "+.Inf", "+.INF":
+		return math.Inf(1)
+	case "-.inf", "-.Inf", "-.INF":
+		return math.Inf(-1)
+	}
+
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return n
+	}
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0o") {
+		if n, err := strconv.ParseInt(text, 0, 64); err == nil {
+			return n
+		}
+	}
+	if strings.ContainsAny(text, "0123456789") && !strings.ContainsAny(text, "_xXpP") {
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	}
+	return text
+}
+
+// yamlFlowParser parses flow collections, like [a, b] and {a: b}.
+type yamlFlowParser struct {
+	text    string
+	pos     int
+	line    int
+	anchors map[string]any
+}
+
+func (f *yamlFlowParser) skipSpace() {
+	for f.pos < len(f.text) && f.text[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *yamlFlowParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: "+format, append([]any{f.line}, args...)...)
+}
+
+func (f *yamlFlowParser) parseValue() (any, error) {
+	f.skipSpace()
+	if f.pos >= len(f.text) {
+		return nil, f.errorf("unexpected end of flow collection")
+	}
+
+	switch c := f.text[f.pos]; {
+	case c == '[':
+		return f.parseSequence()
+	case c == '{':
+		return f.parseMapping()
+	case c == '"' || c == '\'':
+		value, rest, err := parseYAMLQuoted(f.text[f.pos:])
+		if err != nil {
+			return nil, f.errorf("%w", err)
+		}
+		f.pos = len(f.text) - len(rest)
+		return value, nil
+	case c == '*':
+		start := f.pos + 1
+		for f.pos < len(f.text) && !strings.ContainsRune(" ,]}", rune(f.text[f.pos])) {
+			f.pos++
+		}
+		value, ok := f.anchors[f.text[start:f.pos]]
+		if !ok {
+			return nil, f.errorf("unknown alias %q", f.text[start:f.pos])
+		}
+		return value, nil
+	}
+
+	start := f.pos
+	for f.pos < len(f.text) {
+		c := f.text[f.pos]
+		if c == ',' || c == ']' || c == '}' || c == ':' && (f.pos+1 == len(f.text) || strings.ContainsRune(" ,]}", rune(f.text[f.pos+1]))) {
+			break
+		}
+		f.pos++
+	}
+	return resolveYAMLScalar(strings.TrimSpace(f.text[start:f.pos])), nil
+}
+
+func (f *yamlFlowParser) parseSequence() (any, error) {
+	f.pos++
+	sequence := []any{}
+	for {
+		f.skipSpace()
+		if f.pos < len(f.text) && f.text[f.pos] == ']' {
+			f.pos++
+			return sequence, nil
+		}
+
+		value, err := f.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		sequence = append(sequence, value)
+
+		f.skipSpace()
+		if f.pos < len(f.text) && f.text[f.pos] == ',' {
+			f.pos++
+		} else if f.pos >= len(f.text) || f.text[f.pos] != ']' {
+			return nil, f.errorf("expected , or ] in flow sequence")
+		}
+	}
+}
+
+func (f *yamlFlowParser) parseMapping() (any, error) {
+	f.pos++
+	mapping := map[string]any{}
+	for {
+		f.skipSpace()
+		if f.pos < len(f.text) && f.text[f.pos] == '}' {
+			f.pos++
+			return mapping, nil
+		}
+
+		key, err := f.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		f.skipSpace()
+		if f.pos >= len(f.text) || f.text[f.pos] != ':' {
+			return nil, f.errorf("expected : in flow mapping")
+		}
+		f.pos++
+
+		var value any
+		f.skipSpace()
+		if f.pos < len(f.text) && f.text[f.pos] != ',' && f.text[f.pos] != '}' {
+			if value, err = f.parseValue(); err != nil {
+				return nil, err
+			}
+		}
+		mapping[fmt.Sprint(key)] = value
+
+		f.skipSpace()
+		if f.pos < len(f.text) && f.text[f.pos] == ',' {
+			f.pos++
+		} else if f.pos >= len(f.text) || f.text[f.pos] != '}' {
+			return nil, f.errorf("expected , or } in flow mapping")
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    any
+		wantErr string
+	}{
+		{
+			name: "mappings and sequences",
+			input: `
+zone: example.com
+records:
+  - name: home
+    proxied: false
+  - name: office
+    ttl: 300
+tags: [a, "b c"]
+`,
+			want: map[string]any{
+				"zone": "example.com",
+				"records": []any{
+					map[string]any{"name": "home", "proxied": false},
+					map[string]any{"name": "office", "ttl": int64(300)},
+				},
+				"tags": []any{"a", "b c"},
+			},
+		},
+		{
+			name: "sequence at the same indent as its key",
+			input: `
+sources:
+- https://a.example
+- https://b.example
+`,
+			want: map[string]any{"sources": []any{"https://a.example", "https://b.example"}},
+		},
+		{
+			name: "comments and quoting",
+			input: `
+# A comment on its own line.
+token: "abc#def" # a trailing comment
+url: https://example.com/#anchor
+single: 'it''s'
+escaped: "tab\there"
+`,
+			want: map[string]any{
+				"token":   "abc#def",
+				"url":     "https://example.com/#anchor",
+				"single":  "it's",
+				"escaped": "tab\there",
+			},
+		},
+		{
+			name: "scalar types",
+			input: `
+null: ~
+yes: true
+number: 42
+hex: 0x1f
+float: 1.5
+version: "2c"
+plain: 2c
+`,
+			want: map[string]any{
+				"null":    nil,
+				"yes":     true,
+				"number":  int64(42),
+				"hex":     int64(31),
+				"float":   1.5,
+				"version": "2c",
+				"plain":   "2c",
+			},
+		},
+		{
+			name: "scalar anchor and alias",
+			input: `
+token: &token secret
+other: *token
+`,
+			want: map[string]any{"token": "secret", "other": "secret"},
+		},
+		{
+			name: "mapping anchor and alias",
+			input: `
+defaults: &defaults
+  ttl: 120
+  proxied: true
+record: *defaults
+`,
+			want: map[string]any{
+				"defaults": map[string]any{"ttl": int64(120), "proxied": true},
+				"record":   map[string]any{"ttl": int64(120), "proxied": true},
+			},
+		},
+		{
+			name: "alias in a flow sequence",
+			input: `
+primary: &primary https://a.example
+sources: [*primary, https://b.example]
+`,
+			want: map[string]any{
+				"primary": "https://a.example",
+				"sources": []any{"https://a.example", "https://b.example"},
+			},
+		},
+		{
+			name: "merge key",
+			input: `
+defaults: &defaults
+  ttl: 120
+  proxied: true
+records:
+  - <<: *defaults
+    name: home
+    ttl: 60
+`,
+			want: map[string]any{
+				"defaults": map[string]any{"ttl": int64(120), "proxied": true},
+				"records": []any{
+					map[string]any{"name": "home", "ttl": int64(60), "proxied": true},
+				},
+			},
+		},
+		{
+			name: "merge key with a list of mappings",
+			input: `
+a: &a {ttl: 1, proxied: true}
+b: &b {ttl: 2, comment: b}
+record:
+  <<: [*a, *b]
+  name: home
+`,
+			want: map[string]any{
+				"a": map[string]any{"ttl": int64(1), "proxied": true},
+				"b": map[string]any{"ttl": int64(2), "comment": "b"},
+				"record": map[string]any{
+					"name":    "home",
+					"ttl":     int64(1),
+					"proxied": true,
+					"comment": "b",
+				},
+			},
+		},
+		{
+			name: "literal block scalar",
+			input: `
+script: |
+  echo one
+    echo indented
+
+  echo two
+next: value
+`,
+			want: map[string]any{
+				"script": "echo one\n  echo indented\n\necho two\n",
+				"next":   "value",
+			},
+		},
+		{
+			name: "literal block scalar chomping",
+			input: `
+strip: |-
+  text
+
+clip: |
+  text
+
+keep: |+
+  text
+
+end: value
+`,
+			want: map[string]any{
+				"strip": "text",
+				"clip":  "text\n",
+				"keep":  "text\n\n",
+				"end":   "value",
+			},
+		},
+		{
+			name: "folded block scalar",
+			input: `
+description: >
+  one
+  two
+
+  three
+    indented
+  four
+`,
+			want: map[string]any{"description": "one two\nthree\n  indented\nfour\n"},
+		},
+		{
+			name: "block scalar keeps comments",
+			input: `
+text: |
+  # not a comment
+`,
+			want: map[string]any{"text": "# not a comment\n"},
+		},
+		{
+			name:  "document markers",
+			input: "---\na: 1\n...\n",
+			want:  map[string]any{"a": int64(1)},
+		},
+		{
+			name:  "empty document",
+			input: "# nothing here\n",
+			want:  nil,
+		},
+		{
+			name:    "unknown alias",
+			input:   "a: *missing\n",
+			wantErr: `unknown alias "missing"`,
+		},
+		{
+			name:    "merge key with a scalar",
+			input:   "a: &a text\nb:\n  <<: *a\n",
+			wantErr: "merge key values must be mappings",
+		},
+		{
+			name:    "duplicate key",
+			input:   "a: 1\na: 2\n",
+			wantErr: `duplicate key "a"`,
+		},
+		{
+			name:    "tab indentation",
+			input:   "a:\n\tb: 1\n",
+			wantErr: "tabs can't be used",
+		},
+		{
+			name:    "unterminated quoted string",
+			input:   "a: \"open\n",
+			wantErr: "unterminated quoted string",
+		},
+		{
+			name:    "unterminated flow collection",
+			input:   "a: [1, 2\n",
+			wantErr: "unterminated flow collection",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(test.input))
+			checkError(t, err, test.wantErr)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// CloudflareZone is a zone returned by the zones endpoint.
+type CloudflareZone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// CloudflareDNSRecord is a DNS record returned by the DNS records endpoint.
+type CloudflareDNSRecord struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// CloudflareListResponse is the response from an endpoint that lists resources.
+type CloudflareListResponse[T any] struct {
+	Success    bool              `json:"success"`
+	Errors     []CloudflareError `json:"errors,omitempty"`
+	Result     []T               `json:"result"`
+	ResultInfo struct {
+		TotalPages int `json:"total_pages"`
+	} `json:"result_info"`
+}
+
+// err returns the error that the API responded with, if it didn't succeed.
+func (r *CloudflareListResponse[T]) err() error {
+	if r.Success {
+		return nil
+	}
+	if len(r.Errors) > 0 {
+		return fmt.Errorf("API error: %s (code: %d)", r.Errors[0].Message, r.Errors[0].Code)
+	}
+	return fmt.Errorf("API request failed")
+}
+
+func cloudflareList[T any](ctx context.Context, client *http.Client, purpose string, token string, url string) ([]T, error) {
+	var response CloudflareListResponse[T]
+	if _, err := cloudflareGet(ctx, client, purpose, token, url, &response); err != nil {
+		return nil, err
+	}
+	if err := response.err(); err != nil {
+		return nil, err
+	}
+	return response.Result, nil
+}
+
+// listZones returns every zone that the token can access, following the pages
+// of results.
+func listZones(ctx context.Context, client *http.Client, purpose string, token string) ([]CloudflareZone, error) {
+	var zones []CloudflareZone
+	for page := 1; ; page++ {
+		var response CloudflareListResponse[CloudflareZone]
+		_, err := cloudflareGet(ctx, client, purpose, token,
+			"https://api.cloudflare.com/client/v4/zones?per_page=50&page="+strconv.Itoa(page), &response)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list zones: %w", err)
+		}
+		if err := response.err(); err != nil {
+			return nil, fmt.Errorf("failed to list zones: %w", err)
+		}
+		zones = append(zones, response.Result...)
+		if page >= response.ResultInfo.TotalPages {
+			return zones, nil
+		}
+	}
+}
+
+// findZoneID returns the ID of the zone with the given name, such as example.com.
+func findZoneID(ctx context.Context, client *http.Client, purpose string, token string, zoneName string) (string, error) {
+	zones, err := cloudflareList[CloudflareZone](ctx, client, purpose, token,
+		"https://api.cloudflare.com/client/v4/zones?name="+url.QueryEscape(zoneName))
+	if err != nil {
+		return "", fmt.Errorf("failed to look up zone %s: %w", zoneName, err)
+	}
+	if len(zones) == 0 {
+		return "", fmt.Errorf("zone %s not found, or the token can't access it", zoneName)
+	}
+	return zones[0].ID, nil
+}
+
+// findDNSRecords returns the records in the zone with the given fully qualified
+// name, of any type.
+func findDNSRecords(ctx context.Context, client *http.Client, purpose string, token string, zoneID string, name string) ([]CloudflareDNSRecord, error) {
+	records, err := cloudflareList[CloudflareDNSRecord](ctx, client, purpose, token,
+		"https://api.cloudflare.com/client/v4/zones/"+zoneID+"/dns_records?name="+url.QueryEscape(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up records for %s: %w", name, err)
+	}
+	return records, nil
+}